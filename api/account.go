@@ -0,0 +1,157 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/danglnh07/zola/service/worker"
+	"github.com/gin-gonic/gin"
+)
+
+// Ask for an archive of the personal data of the requester. It's built in the background, then
+// its download link is sent by email
+func (server *Server) HandleRequestDataExport(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	pending, err := server.queries.DataExports.HasPending(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/users/me/exports: failed to fetch data exports", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if pending {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"A data export is already in progress"})
+		return
+	}
+
+	export := db.DataExport{
+		AccountID: requesterID,
+		Status:    db.DataExportPending,
+	}
+	err = server.queries.Transaction(ctx, func(tx *db.Queries) error {
+		if err := tx.DataExports.Create(ctx, &export); err != nil {
+			return err
+		}
+
+		event, err := worker.NewOutboxEvent(ctx, worker.ExportData, worker.DataExportPayload{ExportID: export.ID})
+		if err != nil {
+			return err
+		}
+		return tx.Outbox.Create(ctx, event)
+	})
+	if err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/users/me/exports: failed to create data export", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, export)
+}
+
+func (server *Server) HandleListDataExports(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	exports, err := server.queries.DataExports.ListByAccount(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/me/exports: failed to fetch data exports", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"total":   len(exports),
+		"exports": exports,
+	})
+}
+
+// Download the archive of a data export. The link is sent by email, so the token in the URL
+// authenticates the request instead of the bearer token. The token is a query parameter, the
+// access log and the traces only record the path, so it never ends up in them
+func (server *Server) HandleDownloadDataExport(ctx *gin.Context) {
+	token := ctx.Query("token")
+	if token == "" {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"Data export not found or expired"})
+		return
+	}
+
+	export, err := server.queries.DataExports.GetByTokenHash(ctx, security.HashLinkToken(token))
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		server.logger.ErrorContext(ctx, "GET /api/exports/download: failed to fetch data export", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if err != nil || export.ExpiresAt == nil || export.ExpiresAt.Before(time.Now()) {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"Data export not found or expired"})
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Referrer-Policy", "no-referrer")
+	ctx.FileAttachment(export.Path, fmt.Sprintf("zola-export-%s.zip", export.CreatedAt.Format("2006-01-02")))
+}
+
+// Schedule the deletion of the requester's account. It's deleted once the grace period is over,
+// until then it keeps working and the deletion can be cancelled
+func (server *Server) HandleScheduleAccountDeletion(ctx *gin.Context) {
+	value, _ := ctx.Get(accountKey)
+	account := value.(*db.Account)
+
+	if account.DeletionScheduledAt != nil {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"Account deletion is already scheduled"})
+		return
+	}
+
+	deleteAt := time.Now().Add(server.config.AccountDeletionGrace)
+	err := server.queries.Transaction(ctx, func(tx *db.Queries) error {
+		if err := tx.Accounts.ScheduleDeletion(ctx, account.ID, &deleteAt); err != nil {
+			return err
+		}
+
+		// The task is only processed once the grace period is over. Every schedule has its own
+		// outbox event, tasks of cancelled deletions are skipped by the worker. The outbox relay
+		// also sweeps the due deletions, in case the task is lost before it's processed
+		event, err := worker.NewScheduledOutboxEvent(
+			ctx,
+			worker.DeleteAccount,
+			worker.DeleteAccountPayload{AccountID: account.ID},
+			deleteAt,
+		)
+		if err != nil {
+			return err
+		}
+		return tx.Outbox.Create(ctx, event)
+	})
+	if err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/users/me/deletion: failed to schedule account deletion", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, map[string]any{
+		"deletion_scheduled_at": deleteAt,
+	})
+}
+
+func (server *Server) HandleCancelAccountDeletion(ctx *gin.Context) {
+	value, _ := ctx.Get(accountKey)
+	account := value.(*db.Account)
+
+	if account.DeletionScheduledAt == nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"No account deletion is scheduled"})
+		return
+	}
+
+	if err := server.queries.Accounts.ScheduleDeletion(ctx, account.ID, nil); err != nil {
+		server.logger.ErrorContext(ctx, "DELETE /api/users/me/deletion: failed to cancel account deletion", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
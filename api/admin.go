@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/danglnh07/zola/util"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Admin server, listens on its own address so its endpoints are never exposed with the public API
+type AdminServer struct {
+	mux        *gin.Engine
+	httpServer *http.Server
+	logger     *slog.Logger
+}
+
+// Constructor method for AdminServer
+func NewAdminServer(config *util.Config, logger *slog.Logger) *AdminServer {
+	mux := gin.New()
+	mux.Use(gin.Recovery())
+
+	return &AdminServer{
+		mux: mux,
+		httpServer: &http.Server{
+			Addr:    config.AdminListenAddr,
+			Handler: mux,
+		},
+		logger: logger,
+	}
+}
+
+// Helper method to register handler to route
+func (server *AdminServer) RegisterHandler() {
+	server.mux.GET("/metrics", gin.WrapH(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})))
+}
+
+// Method to start the admin server, it blocks until the server is shut down
+func (server *AdminServer) Start() error {
+	server.RegisterHandler()
+
+	server.logger.Info("Admin server listening", "address", server.httpServer.Addr)
+
+	err := server.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Method to stop the admin server, waiting for in-flight requests until the context is done
+func (server *AdminServer) Shutdown(ctx context.Context) error {
+	return server.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/media"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/danglnh07/zola/service/worker"
+	"github.com/gin-gonic/gin"
+)
+
+func (server *Server) HandleUploadAttachment(ctx *gin.Context) {
+	// Limit the request body so client cannot fill up the disk
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, server.config.MaxUploadSize)
+
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			ctx.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{"File too large"})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Missing file"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/attachments: failed to open uploaded file", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	defer file.Close()
+
+	// Sniff the content type instead of trusting the one sent by client
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		server.logger.ErrorContext(ctx, "POST /api/attachments: failed to read uploaded file", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/attachments: failed to read uploaded file", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	attachment := db.Attachment{
+		UploaderID:  requesterID,
+		FileName:    filepath.Base(fileHeader.Filename),
+		ContentType: contentType,
+		Size:        fileHeader.Size,
+		Status:      db.AttachmentPending,
+	}
+
+	// Only images need to be processed, other files are ready as is
+	isImage := media.IsSupportedImage(contentType)
+	if !isImage {
+		attachment.Status = db.AttachmentReady
+	}
+
+	// Reject the images too large to be processed right away, only their header is read
+	if isImage {
+		if err := media.CheckPixels(file, server.config.MaxImagePixels); errors.Is(err, media.ErrImageTooLarge) {
+			ctx.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{fmt.Sprintf("Images must be at most %d pixels", server.config.MaxImagePixels)})
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			server.logger.ErrorContext(ctx, "POST /api/attachments: failed to read uploaded file", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+	}
+
+	// Create the record first, so we can use its ID for the storage path
+	var dir string
+	err = server.queries.Transaction(ctx, func(tx *db.Queries) error {
+		if err := tx.Attachments.Create(ctx, &attachment); err != nil {
+			return err
+		}
+
+		dir = filepath.Join(server.config.StorageDir, "attachments", strconv.FormatUint(uint64(attachment.ID), 10))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+
+		attachment.Path = filepath.Join(dir, "original"+filepath.Ext(attachment.FileName))
+		dst, err := os.Create(attachment.Path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, file); err != nil {
+			dst.Close()
+			return err
+		}
+		if err := dst.Close(); err != nil {
+			return err
+		}
+
+		return tx.Attachments.UpdatePath(ctx, &attachment)
+	})
+	if err != nil {
+		// The record is rolled back, so nothing points to the file written so far
+		if dir != "" {
+			if err := os.RemoveAll(dir); err != nil {
+				server.logger.WarnContext(ctx, "POST /api/attachments: failed to remove partial upload", "path", dir, "error", err)
+			}
+		}
+
+		server.logger.ErrorContext(ctx, "POST /api/attachments: failed to store attachment", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	if isImage {
+		err = server.distributor.DistributeTaskProcessImage(ctx, worker.ProcessImagePayload{AttachmentID: attachment.ID})
+		if err != nil {
+			server.logger.ErrorContext(ctx, "POST /api/attachments: failed to create background task process image", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusCreated, attachment)
+}
+
+func (server *Server) HandleGetAttachment(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid attachment ID"})
+		return
+	}
+
+	attachment, err := server.queries.Attachments.GetByID(ctx, uint(id))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"Attachment not found"})
+			return
+		}
+
+		server.logger.ErrorContext(ctx, "GET /api/attachments/:id: failed to fetch attachment from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Only the uploader and the recipients of the message can download the attachment
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+	if !canSeeAttachment(attachment, requesterID) {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"Attachment not found"})
+		return
+	}
+
+	// Serve the thumbnail if requested
+	path := attachment.Path
+	if size := ctx.Query("size"); size != "" {
+		found := false
+		for _, thumbnail := range attachment.Thumbnails {
+			if strconv.Itoa(thumbnail.Size) == size {
+				path = thumbnail.Path
+				found = true
+				break
+			}
+		}
+
+		// The image is smaller than the requested size, fallback to the original
+		if !found && attachment.Status != db.AttachmentReady {
+			ctx.JSON(http.StatusConflict, ErrorResponse{"Attachment is still being processed"})
+			return
+		}
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", attachment.FileName))
+	ctx.File(path)
+}
+
+// Check if the account can see the attachment
+func canSeeAttachment(attachment *db.Attachment, accountID uint) bool {
+	if attachment.UploaderID == accountID {
+		return true
+	}
+
+	if attachment.Message == nil {
+		return false
+	}
+
+	switch attachment.Message.ChatType {
+	case db.PublicChat:
+		return true
+	case db.PrivateChat:
+		return attachment.Message.ReceiverID != nil && *attachment.Message.ReceiverID == accountID
+	}
+
+	return false
+}
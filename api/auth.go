@@ -14,14 +14,16 @@ import (
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
-	"gorm.io/gorm"
 )
 
 // User data return to client
 type UserData struct {
-	ID       uint   `json:"id"` // Account ID
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	ID          uint   `json:"id"` // Account ID
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Username    string `json:"username"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	Email       string `json:"email,omitempty"` // Only set if the email visibility of the account allows it
 }
 
 // Struct holds both access token and refresh token
@@ -96,7 +98,7 @@ func (auth *GoogleOAuth) HandleCallback(ctx *gin.Context) {
 
 	token, err := auth.OAuthConfig.Exchange(context.Background(), code)
 	if err != nil {
-		auth.logger.Error("GET /oauth2/callback: failed to exchange code for token", "error", err)
+		auth.logger.ErrorContext(ctx, "GET /oauth2/callback: failed to exchange code for token", "error", err)
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 		return
 	}
@@ -105,7 +107,7 @@ func (auth *GoogleOAuth) HandleCallback(ctx *gin.Context) {
 	client := auth.OAuthConfig.Client(context.Background(), token)
 	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
 	if err != nil {
-		auth.logger.Error("GET /oauth2/callback: failed to fetch user data from OAuth provider")
+		auth.logger.ErrorContext(ctx, "GET /oauth2/callback: failed to fetch user data from OAuth provider")
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 		return
 	}
@@ -114,44 +116,48 @@ func (auth *GoogleOAuth) HandleCallback(ctx *gin.Context) {
 	// Get user data from response
 	var userData UserDataResp
 	if err = json.NewDecoder(resp.Body).Decode(&userData); err != nil {
-		auth.logger.Error("GET /oauth2/callback: failed to decode user data fetch from OAuth provider", "error", err)
+		auth.logger.ErrorContext(ctx, "GET /oauth2/callback: failed to decode user data fetch from OAuth provider", "error", err)
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 		return
 	}
 
 	// Fetch user from database to check if they exists first
-	var account = db.Account{}
-	result := auth.queries.DB.
-		Where("oauth_provider = ? AND oauth_provider_id = ?", db.Google, userData.ID).
-		First(&account)
-	if result.Error != nil {
+	account, err := auth.queries.Accounts.GetByOAuth(ctx, db.Google, userData.ID)
+	if err != nil {
 		// If not found any user with this oauth_id -> create account
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			account.Username = userData.Username
-			account.Email = userData.Email
-			account.OauthProvider = string(db.Google)
-			account.OauthProviderID = userData.ID
-			account.TokenVersion = 1
-			result = auth.queries.DB.Create(&account)
-			if result.Error != nil {
-				auth.logger.Error("GET /oauth2/callback: failed to inset user data into database")
+		if errors.Is(err, db.ErrNotFound) {
+			account = &db.Account{
+				Username:        userData.Username,
+				Email:           userData.Email,
+				OauthProvider:   string(db.Google),
+				OauthProviderID: userData.ID,
+				TokenVersion:    1,
+			}
+			if err = auth.queries.Accounts.Create(ctx, account); err != nil {
+				auth.logger.ErrorContext(ctx, "GET /oauth2/callback: failed to inset user data into database")
 				ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 				return
 			}
 		} else {
 			// Other database errors
-			auth.logger.Error("GET /oauth2/callback: failed to fetch user data from database")
+			auth.logger.ErrorContext(ctx, "GET /oauth2/callback: failed to fetch user data from database")
 			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 			return
 		}
 	}
 
+	// Banned accounts can't sign in again
+	if account.BannedAt != nil {
+		ctx.JSON(http.StatusForbidden, ErrorResponse{"Account is banned"})
+		return
+	}
+
 	// Create JWT tokens and return it back to client
 	accessToken, err := auth.jwtService.CreateToken(
 		account.ID, security.AccessToken, int(account.TokenVersion),
 	)
 	if err != nil {
-		auth.logger.Error("GET /oauth2/callback: failed to create JWT access token")
+		auth.logger.ErrorContext(ctx, "GET /oauth2/callback: failed to create JWT access token")
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 		return
 	}
@@ -159,17 +165,13 @@ func (auth *GoogleOAuth) HandleCallback(ctx *gin.Context) {
 		account.ID, security.RefreshToken, int(account.TokenVersion),
 	)
 	if err != nil {
-		auth.logger.Error("GET /oauth2/callback: failed to create JWT refresh token")
+		auth.logger.ErrorContext(ctx, "GET /oauth2/callback: failed to create JWT refresh token")
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, AuthResponse{
-		UserData: UserData{
-			ID:       account.ID,
-			Username: account.Username,
-			Email:    account.Email,
-		},
+		UserData: newUserData(account, true),
 		Tokens: Tokens{
 			AccessToken:  accessToken,
 			RefreshToken: refreshToken,
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/gin-gonic/gin"
+)
+
+// Account blocked or muted by the requester
+type RelationData struct {
+	User      UserData  `json:"user"`
+	CreatedAt time.Time `json:"created_at"` // When the account was blocked or muted
+}
+
+// Parse the :id parameter holding an account ID, reply with an error and return false if it's invalid
+func userIDParam(ctx *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid user ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// Get the account targeted by an action of the requester, reply with an error and return false
+// if it's the requester themself or an account that does not exist
+func (server *Server) targetAccount(ctx *gin.Context, route string, id uint, action string) (*db.Account, bool) {
+	claims, _ := ctx.Get(claimsKey)
+	if id == claims.(*security.CustomClaims).ID {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"You can't " + action + " yourself"})
+		return nil, false
+	}
+
+	account, err := server.queries.Accounts.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"User not found"})
+			return nil, false
+		}
+
+		server.logger.ErrorContext(ctx, route+": failed to fetch user from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return nil, false
+	}
+
+	return account, true
+}
+
+func (server *Server) HandleListBlocks(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	blocks, err := server.queries.Blocks.ListByBlocker(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/me/blocks: failed to fetch blocks", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	builder, err := server.newUserDataBuilder(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/me/blocks: failed to fetch contacts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	users := make([]RelationData, 0, len(blocks))
+	for _, block := range blocks {
+		users = append(users, RelationData{
+			User:      builder.userData(&block.Blocked),
+			CreatedAt: block.CreatedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"total": len(users),
+		"users": users,
+	})
+}
+
+// Block an account, blocking it again is a no-op. The blocked account is not told about it, and
+// it's removed from the contacts of the requester
+func (server *Server) HandleBlockUser(ctx *gin.Context) {
+	id, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	if _, ok := server.targetAccount(ctx, "PUT /api/users/me/blocks/:id", id, "block"); !ok {
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	err := server.queries.Transaction(ctx, func(tx *db.Queries) error {
+		if err := tx.Blocks.Create(ctx, requesterID, id); err != nil {
+			return err
+		}
+		_, err := tx.Contacts.DeleteBetween(ctx, requesterID, id)
+		return err
+	})
+	if err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/blocks/:id: failed to block user", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (server *Server) HandleUnblockUser(ctx *gin.Context) {
+	id, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	deleted, err := server.queries.Blocks.Delete(ctx, requesterID, id)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "DELETE /api/users/me/blocks/:id: failed to unblock user", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"User is not blocked"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (server *Server) HandleListMutes(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	mutes, err := server.queries.Mutes.ListByMuter(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/me/mutes: failed to fetch mutes", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	builder, err := server.newUserDataBuilder(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/me/mutes: failed to fetch contacts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	users := make([]RelationData, 0, len(mutes))
+	for _, mute := range mutes {
+		users = append(users, RelationData{
+			User:      builder.userData(&mute.Muted),
+			CreatedAt: mute.CreatedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"total": len(users),
+		"users": users,
+	})
+}
+
+// Mute an account: its messages are still delivered, but don't trigger push notifications
+// nor email digests. Muting it again is a no-op
+func (server *Server) HandleMuteUser(ctx *gin.Context) {
+	id, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+	if _, ok := server.targetAccount(ctx, "PUT /api/users/me/mutes/:id", id, "mute"); !ok {
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	if err := server.queries.Mutes.Create(ctx, requesterID, id); err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/mutes/:id: failed to mute user", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (server *Server) HandleUnmuteUser(ctx *gin.Context) {
+	id, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	deleted, err := server.queries.Mutes.Delete(ctx, requesterID, id)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "DELETE /api/users/me/mutes/:id: failed to unmute user", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"User is not muted"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -3,21 +3,28 @@ package api
 import (
 	"errors"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/metrics"
 	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/ratelimit"
 	"github.com/danglnh07/zola/service/security"
+	"github.com/danglnh07/zola/service/worker"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 )
 
 // Handler for all Web Socket endpoint
 func (server *Server) HandleWS(ctx *gin.Context) {
 	// Upgrade request from HTTP to Web Socket
+	// The upgrader already replied with an HTTP error if the handshake is rejected
 	conn, err := server.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		server.logger.Error("failed to upgrade to Web Socket", "error", err)
-		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		server.logger.WarnContext(ctx, "failed to upgrade to Web Socket", "error", err)
 		return
 	}
 
@@ -26,31 +33,50 @@ func (server *Server) HandleWS(ctx *gin.Context) {
 	requesterID := claims.(*security.CustomClaims).ID
 	client := pubsub.NewClient(requesterID, conn)
 
-	// Subscribe to the server
-	server.hub.Subscribe(client)
+	// Subscribe to the server, which is refused while shutting down
+	if err := server.hub.Subscribe(client); err != nil {
+		client.Close(websocket.CloseServiceRestart, "server is restarting")
+		return
+	}
 	defer server.hub.Unsubscribe(client)
 
-	// Block until client is disconnected
+	// Frames larger than the limit close the connection with a message too big close frame
+	conn.SetReadLimit(server.config.WSMaxFrameSize)
+	limiter := newFrameLimiter(client)
+
+	// Block until client is disconnected, clients that keep flooding the server are disconnected
 	for {
 		_, _, err := conn.ReadMessage()
 		if err != nil {
-			server.logger.Info("client disconnected", "id", requesterID, "err", err)
+			server.logger.InfoContext(ctx, "client disconnected", "id", requesterID, "err", err)
+			break
+		}
+		if !server.allowFrame(ctx, limiter) {
+			client.Close(websocket.ClosePolicyViolation, "too many messages")
 			break
 		}
 	}
 }
 
+// Returned by the message transaction to roll it back when the slow mode rejects the message
+var errSlowMode = errors.New("slow mode is enabled")
+
 type SendMessageRequest struct {
 	SenderID   uint   `json:"sender_id" binding:"required"`
 	ReceiverID uint   `json:"receiver_id"` // If not provided, it would be a broadcast message
 	Content    string `json:"content" binding:"required"`
+
+	AttachmentIDs []uint `json:"attachment_ids"` // Attachments uploaded beforehand by the sender
+
+	// Generated by client to make retries safe, can also be sent in the Idempotency-Key header
+	ClientMessageID string `json:"client_message_id" binding:"max=255"`
 }
 
 func (server *Server) HandleSendMessage(ctx *gin.Context) {
 	// Get the request body and validate
 	var req SendMessageRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		server.logger.Error("POST /api/messages: failed to parse request body", "error", err)
+		server.logger.ErrorContext(ctx, "POST /api/messages: failed to parse request body", "error", err)
 		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
 		return
 	}
@@ -63,38 +89,94 @@ func (server *Server) HandleSendMessage(ctx *gin.Context) {
 		return
 	}
 
+	// Get the idempotency key, from either the header or the request body
+	clientMessageID := req.ClientMessageID
+	if key := ctx.GetHeader("Idempotency-Key"); key != "" {
+		if clientMessageID != "" && clientMessageID != key {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"Idempotency-Key header and client_message_id do not match"})
+			return
+		}
+		if len(key) > 255 {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"Idempotency-Key is too long"})
+			return
+		}
+		clientMessageID = key
+	}
+
+	// If this is a retry, return the message stored by the first attempt
+	if clientMessageID != "" {
+		existing, err := server.queries.Messages.GetByClientID(ctx, req.SenderID, clientMessageID)
+		if err == nil {
+			ctx.JSON(http.StatusOK, existing)
+			return
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			server.logger.ErrorContext(ctx, "POST /api/messages: failed to fetch message by client ID", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+	}
+
+	// Muted accounts can't send messages until the mute is over
+	value, _ := ctx.Get(accountKey)
+	if mutedUntil := value.(*db.Account).MutedUntil; mutedUntil != nil && mutedUntil.After(time.Now()) {
+		ctx.JSON(http.StatusForbidden, ErrorResponse{"You are muted until " + mutedUntil.UTC().Format(time.RFC3339)})
+		return
+	}
+
 	// Build the message model
 	var message = db.Message{
 		Model:    gorm.Model{},
 		SenderID: req.SenderID,
 		Content:  req.Content,
 	}
-
-	var sender db.Account
-	result := server.queries.DB.Where("id = ?", req.SenderID).First(&sender)
-	if result.Error != nil {
-		server.logger.Error("POST /api/messages: failed to get sender from database", "error", result.Error)
-		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
-		return
+	if clientMessageID != "" {
+		message.ClientMessageID = &clientMessageID
 	}
-	message.Sender = sender
+
+	// The sender is the requester, already fetched by the AuthMiddleware
+	message.Sender = *value.(*db.Account)
 
 	if req.ReceiverID != 0 {
-		var receiver db.Account
-		result = server.queries.DB.Where("id = ?", req.ReceiverID).First(&receiver)
-		if result.Error != nil {
-			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		receiver, err := server.queries.Accounts.GetByID(ctx, req.ReceiverID)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
 				ctx.JSON(http.StatusBadRequest, ErrorResponse{"receiver_id not match any account"})
 				return
 			}
 
-			server.logger.Error("POST /api/messages: failed to fetch receiver from database", "error", result.Error)
+			server.logger.ErrorContext(ctx, "POST /api/messages: failed to fetch receiver from database", "error", err)
 			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 			return
 		}
+
+		// Blocks go both ways. The error doesn't tell who blocked who, nor whether the receiver
+		// only accepts messages from their contacts
+		blocked, err := server.queries.Blocks.Between(ctx, req.SenderID, req.ReceiverID)
+		if err != nil {
+			server.logger.ErrorContext(ctx, "POST /api/messages: failed to check blocks", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+
+		// The receiver may only accept private messages from their contacts
+		allowed := !blocked
+		if allowed && receiver.DMPrivacy == db.DMContacts {
+			allowed, err = server.queries.Contacts.AreContacts(ctx, req.SenderID, req.ReceiverID)
+			if err != nil {
+				server.logger.ErrorContext(ctx, "POST /api/messages: failed to check contacts", "error", err)
+				ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+				return
+			}
+		}
+		if !allowed {
+			ctx.JSON(http.StatusForbidden, ErrorResponse{"You can't send messages to this user"})
+			return
+		}
+
 		receiverID := req.ReceiverID
 		message.ReceiverID = &receiverID
-		message.Receiver = &receiver
+		message.Receiver = receiver
 		message.ChatType = db.PrivateChat
 	} else {
 		message.ReceiverID = nil
@@ -102,45 +184,148 @@ func (server *Server) HandleSendMessage(ctx *gin.Context) {
 		message.ChatType = db.PublicChat
 	}
 
-	// Add message to database
-	result = server.queries.DB.Create(&message)
-	if result.Error != nil {
-		server.logger.Error("POST /api/messages: failed to create message in database", "error", result.Error)
+	// Get the slow mode of the conversation, retries of a stored message are answered above
+	policy, err := server.slowModePolicy(ctx, &message)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/messages: failed to get slow mode", "error", err)
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 		return
 	}
 
-	// Publish the send message event through hub
-	err := server.distributor.DistributeTaskSendMessage(ctx, message)
+	// Add message to database, along with its attachments and the outbox event to deliver it.
+	// Everything is committed together, the outbox relay will enqueue the task even if
+	// the task queue is currently unavailable
+	var slowMode ratelimit.Result
+	err = server.queries.Transaction(ctx, func(tx *db.Queries) error {
+		if err := tx.Messages.Create(ctx, &message); err != nil {
+			return err
+		}
+
+		if len(req.AttachmentIDs) > 0 {
+			if err := tx.Attachments.AttachToMessage(ctx, &message, req.AttachmentIDs); err != nil {
+				return err
+			}
+		}
+
+		event, err := worker.NewOutboxEvent(ctx, worker.SendMessage, message)
+		if err != nil {
+			return err
+		}
+		if err := tx.Outbox.Create(ctx, event); err != nil {
+			return err
+		}
+
+		// The slow mode is checked last, so a message which fails to be stored doesn't take the
+		// token, and a rejected message is rolled back
+		if policy == nil {
+			return nil
+		}
+		slowMode, err = server.rateLimits.Allow(ctx, slowModeKey(&message), *policy)
+		if err != nil {
+			server.logger.ErrorContext(ctx, "POST /api/messages: failed to check slow mode", "error", err)
+			return nil
+		}
+		if !slowMode.Allowed {
+			return errSlowMode
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errSlowMode) {
+			metrics.RateLimitRejections.WithLabelValues(policy.Name).Inc()
+			ctx.Header("Retry-After", strconv.Itoa(ceilSeconds(slowMode.RetryAfter)))
+			ctx.JSON(http.StatusTooManyRequests, ErrorResponse{"Slow mode is enabled, wait before sending another message"})
+			return
+		}
+		if errors.Is(err, db.ErrInvalidAttachments) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"attachment_ids contains invalid attachments"})
+			return
+		}
+
+		// A concurrent retry stored the message first
+		if errors.Is(err, db.ErrDuplicated) && clientMessageID != "" {
+			existing, err := server.queries.Messages.GetByClientID(ctx, req.SenderID, clientMessageID)
+			if err == nil {
+				ctx.JSON(http.StatusOK, existing)
+				return
+			}
+			server.logger.ErrorContext(ctx, "POST /api/messages: failed to fetch message by client ID", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+
+		server.logger.ErrorContext(ctx, "POST /api/messages: failed to create message in database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, message)
+}
+
+type MarkReadRequest struct {
+	SenderID uint `json:"sender_id" binding:"required"`
+}
+
+// Mark all private messages from a sender to the requester as read
+func (server *Server) HandleMarkMessagesRead(ctx *gin.Context) {
+	var req MarkReadRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/messages/read: failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	updated, err := server.queries.Messages.MarkConversationRead(ctx, req.SenderID, requesterID)
 	if err != nil {
-		server.logger.Error("POST/api/messages: failed to create background task send message", "error", err)
+		server.logger.ErrorContext(ctx, "POST /api/messages/read: failed to mark messages as read", "error", err)
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 		return
 	}
 
-	ctx.JSON(http.StatusCreated, "Message sent successfully")
+	ctx.JSON(http.StatusOK, map[string]any{
+		"updated": updated,
+	})
 }
 
 func (server *Server) HandleGetOnlineUsers(ctx *gin.Context) {
-	var users []UserData
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
 
-	for _, client := range server.hub.Clients {
-		var user db.Account
-		result := server.queries.DB.Select("id", "username", "email").Where("id = ?", client.AccountID).First(&user)
-		if result.Error != nil {
-			server.logger.Error("GET /api/users/online: failed to fetch user data from database", "error", result.Error)
-			continue
-		}
+	// The accounts blocked by the requester are hidden
+	blockedIDs, err := server.queries.Blocks.ListBlockedIDs(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/online: failed to fetch blocks", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	onlineIDs := slices.DeleteFunc(server.hub.OnlineAccountIDs(), func(id uint) bool {
+		return slices.Contains(blockedIDs, id)
+	})
+
+	accounts, err := server.queries.Accounts.ListByIDs(ctx, onlineIDs)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/online: failed to fetch user data from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	builder, err := server.newUserDataBuilder(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/online: failed to fetch contacts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
 
-		users = append(users, UserData{
-			ID:       user.ID,
-			Username: user.Username,
-			Email:    user.Email,
-		})
+	users := make([]UserData, 0, len(accounts))
+	for _, account := range accounts {
+		users = append(users, builder.userData(&account))
 	}
 
 	ctx.JSON(http.StatusOK, map[string]any{
-		"total": len(server.hub.Clients),
+		"total": len(users),
 		"users": users,
 	})
 }
@@ -0,0 +1,385 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/danglnh07/zola/service/worker"
+	"github.com/gin-gonic/gin"
+)
+
+// Errors of the contact request transaction, turned into a conflict response
+var (
+	errAlreadyContacts = errors.New("already in your contacts")
+	errRequestPending  = errors.New("contact request already sent")
+)
+
+type SendContactRequestRequest struct {
+	AccountID uint `json:"account_id" binding:"required"`
+}
+
+type UpdatePrivacyRequest struct {
+	DMPrivacy db.DMPrivacy `json:"dm_privacy" binding:"required,oneof=everyone contacts"`
+}
+
+type ContactData struct {
+	User  UserData   `json:"user"`
+	Since *time.Time `json:"since"` // When the request was accepted
+}
+
+// Pending contact request, the user is the other side of the request
+type ContactRequestData struct {
+	ID        uint      `json:"id"`
+	User      UserData  `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Queue the event for the account in the outbox of the transaction, so it's only delivered if
+// the transaction is committed
+func queueAccountEvent(ctx context.Context, tx *db.Queries, accountID uint, event pubsub.Event) error {
+	outboxEvent, err := worker.NewOutboxEvent(ctx, worker.DeliverEvent, worker.DeliverEventPayload{
+		AccountIDs: []uint{accountID},
+		Event:      event,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Outbox.Create(ctx, outboxEvent)
+}
+
+// Build a contact request event, the account is the other side of the request for the recipient
+func contactRequestEvent(eventType string, contact *db.Contact, account *db.Account) pubsub.Event {
+	return pubsub.Event{
+		Type: eventType,
+		Payload: pubsub.ContactRequestPayload{
+			RequestID:   contact.ID,
+			AccountID:   account.ID,
+			Handle:      account.Handle,
+			DisplayName: account.DisplayName,
+		},
+	}
+}
+
+func (server *Server) HandleListContacts(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	contacts, err := server.queries.Contacts.ListContacts(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/contacts: failed to fetch contacts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	builder, err := server.newUserDataBuilder(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/contacts: failed to fetch contacts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	resp := make([]ContactData, 0, len(contacts))
+	for _, contact := range contacts {
+		resp = append(resp, ContactData{
+			User:  builder.userData(contact.Other(requesterID)),
+			Since: contact.AcceptedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"total":    len(resp),
+		"contacts": resp,
+	})
+}
+
+// Remove an account from the contacts, on both sides. The other account is not told about it
+func (server *Server) HandleRemoveContact(ctx *gin.Context) {
+	id, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	contact, err := server.queries.Contacts.Between(ctx, requesterID, id)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		server.logger.ErrorContext(ctx, "DELETE /api/contacts/:id: failed to fetch contact", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if err != nil || contact.Status != db.ContactAccepted {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"User is not in your contacts"})
+		return
+	}
+
+	if err := server.queries.Contacts.Delete(ctx, contact.ID); err != nil {
+		server.logger.ErrorContext(ctx, "DELETE /api/contacts/:id: failed to delete contact", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// List the pending requests, the ones received by default or the ones sent with ?direction=outgoing
+func (server *Server) HandleListContactRequests(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	var contacts []db.Contact
+	var err error
+	switch ctx.DefaultQuery("direction", "incoming") {
+	case "incoming":
+		contacts, err = server.queries.Contacts.ListIncoming(ctx, requesterID)
+	case "outgoing":
+		contacts, err = server.queries.Contacts.ListOutgoing(ctx, requesterID)
+	default:
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"direction must be either incoming or outgoing"})
+		return
+	}
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/contacts/requests: failed to fetch contact requests", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	builder, err := server.newUserDataBuilder(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/contacts/requests: failed to fetch contacts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	requests := make([]ContactRequestData, 0, len(contacts))
+	for _, contact := range contacts {
+		requests = append(requests, ContactRequestData{
+			ID:        contact.ID,
+			User:      builder.userData(contact.Other(requesterID)),
+			CreatedAt: contact.CreatedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"total":    len(requests),
+		"requests": requests,
+	})
+}
+
+// Send a contact request. If the other account already sent one to the requester, it's accepted
+// instead, so both accounts become contacts
+func (server *Server) HandleSendContactRequest(ctx *gin.Context) {
+	var req SendContactRequestRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/contacts/requests: failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	addressee, ok := server.targetAccount(ctx, "POST /api/contacts/requests", req.AccountID, "add")
+	if !ok {
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	requester, err := server.queries.Accounts.GetByID(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/contacts/requests: failed to get requester from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Blocks go both ways. The error doesn't tell who blocked who
+	blocked, err := server.queries.Blocks.Between(ctx, requesterID, addressee.ID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/contacts/requests: failed to check blocks", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if blocked {
+		ctx.JSON(http.StatusForbidden, ErrorResponse{"You can't send a contact request to this user"})
+		return
+	}
+
+	// Create the request, or accept the one sent by the other account, along with the event
+	// telling the other account about it
+	var contact *db.Contact
+	status := http.StatusCreated
+	err = server.queries.Transaction(ctx, func(tx *db.Queries) error {
+		existing, err := tx.Contacts.Between(ctx, requesterID, addressee.ID)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+
+		switch {
+		case existing == nil:
+			contact = &db.Contact{RequesterID: requesterID, AddresseeID: addressee.ID, Status: db.ContactPending}
+			if err := tx.Contacts.Create(ctx, contact); err != nil {
+				return err
+			}
+			return queueAccountEvent(ctx, tx, addressee.ID,
+				contactRequestEvent(pubsub.ContactRequestReceived, contact, requester))
+		case existing.Status == db.ContactAccepted:
+			return errAlreadyContacts
+		case existing.RequesterID == requesterID:
+			return errRequestPending
+		default:
+			contact, status = existing, http.StatusOK
+			if err := tx.Contacts.Accept(ctx, contact); err != nil {
+				return err
+			}
+			return queueAccountEvent(ctx, tx, addressee.ID,
+				contactRequestEvent(pubsub.ContactRequestAccepted, contact, requester))
+		}
+	})
+	switch {
+	case errors.Is(err, errAlreadyContacts):
+		ctx.JSON(http.StatusConflict, ErrorResponse{"User is already in your contacts"})
+		return
+	case errors.Is(err, errRequestPending), errors.Is(err, db.ErrDuplicated):
+		// The duplicate comes from a concurrent request of the same requester
+		ctx.JSON(http.StatusConflict, ErrorResponse{"Contact request already sent"})
+		return
+	case err != nil:
+		server.logger.ErrorContext(ctx, "POST /api/contacts/requests: failed to save contact request", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(status, contact)
+}
+
+// Get the pending request of the :id parameter, reply with an error and return false if it's
+// invalid or if the requester is not the given side of the request
+func (server *Server) pendingContactRequest(ctx *gin.Context, route string, asAddressee bool) (*db.Contact, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request ID"})
+		return nil, false
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	contact, err := server.queries.Contacts.GetByID(ctx, uint(id))
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		server.logger.ErrorContext(ctx, route+": failed to fetch contact request", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return nil, false
+	}
+
+	// Requests of other accounts are reported as not found, so their IDs can't be probed
+	if err != nil || contact.Status != db.ContactPending ||
+		(asAddressee && contact.AddresseeID != requesterID) ||
+		(!asAddressee && contact.RequesterID != requesterID) {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"Contact request not found"})
+		return nil, false
+	}
+
+	return contact, true
+}
+
+func (server *Server) HandleAcceptContactRequest(ctx *gin.Context) {
+	contact, ok := server.pendingContactRequest(ctx, "POST /api/contacts/requests/:id/accept", true)
+	if !ok {
+		return
+	}
+
+	err := server.queries.Transaction(ctx, func(tx *db.Queries) error {
+		if err := tx.Contacts.Accept(ctx, contact); err != nil {
+			return err
+		}
+		return queueAccountEvent(ctx, tx, contact.RequesterID,
+			contactRequestEvent(pubsub.ContactRequestAccepted, contact, &contact.Addressee))
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"Contact request not found"})
+		return
+	}
+	if err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/contacts/requests/:id/accept: failed to accept contact request", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, contact)
+}
+
+// Decline a received request. The requester is not told about it, and can send it again
+func (server *Server) HandleDeclineContactRequest(ctx *gin.Context) {
+	contact, ok := server.pendingContactRequest(ctx, "POST /api/contacts/requests/:id/decline", true)
+	if !ok {
+		return
+	}
+
+	if err := server.queries.Contacts.Delete(ctx, contact.ID); err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/contacts/requests/:id/decline: failed to delete contact request", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// Cancel a sent request
+func (server *Server) HandleCancelContactRequest(ctx *gin.Context) {
+	contact, ok := server.pendingContactRequest(ctx, "DELETE /api/contacts/requests/:id", false)
+	if !ok {
+		return
+	}
+
+	if err := server.queries.Contacts.Delete(ctx, contact.ID); err != nil {
+		server.logger.ErrorContext(ctx, "DELETE /api/contacts/requests/:id: failed to delete contact request", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (server *Server) HandleGetPrivacy(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	account, err := server.queries.Accounts.GetByID(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/me/privacy: failed to get account from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"dm_privacy": account.DMPrivacy,
+	})
+}
+
+// Choose who can send private messages to the requester, either everyone or only their contacts
+func (server *Server) HandleUpdatePrivacy(ctx *gin.Context) {
+	var req UpdatePrivacyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/privacy: failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	if err := server.queries.Accounts.SetDMPrivacy(ctx, requesterID, req.DMPrivacy); err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/privacy: failed to update privacy", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"dm_privacy": req.DMPrivacy,
+	})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/danglnh07/zola/util"
+	"github.com/gin-gonic/gin"
+)
+
+// Methods and request headers allowed on cross-origin requests
+var (
+	corsAllowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	corsAllowedHeaders = []string{"Content-Type", "Authorization", "X-Requested-With", "Idempotency-Key", "X-Request-ID"}
+)
+
+// Response headers readable by cross-origin clients, on top of the CORS safelisted ones
+var corsExposedHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After", "X-Request-ID"}
+
+// Check an origin against the allowed origins of the config, either an exact match or a
+// wildcard such as https://*.example.com, which matches every subdomain but not the domain
+// itself. If no origin is configured, only the origin of the base URL is allowed
+func originAllowed(config *util.Config, origin string) bool {
+	originURL, err := url.Parse(strings.ToLower(origin))
+	if err != nil || originURL.Scheme == "" || originURL.Host == "" {
+		return false
+	}
+
+	allowed := config.CORSOrigins
+	if len(allowed) == 0 {
+		baseURL, err := url.Parse(strings.ToLower(config.BaseURL))
+		if err != nil {
+			return false
+		}
+		allowed = []string{baseURL.Scheme + "://" + baseURL.Host}
+	}
+
+	for _, pattern := range allowed {
+		patternURL, err := url.Parse(pattern)
+		if err != nil || patternURL.Scheme != originURL.Scheme || patternURL.Port() != originURL.Port() {
+			continue
+		}
+		if domain, ok := strings.CutPrefix(patternURL.Hostname(), "*"); ok {
+			if strings.HasSuffix(originURL.Hostname(), domain) && len(originURL.Hostname()) > len(domain) {
+				return true
+			}
+		} else if patternURL.Hostname() == originURL.Hostname() {
+			return true
+		}
+	}
+	return false
+}
+
+// Method used by the WebSocket upgrader to check the origin of the handshake. Browsers always
+// send the Origin header, requests without it come from other clients and can't be hijacked
+func (server *Server) checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if !originAllowed(server.runtime.Get(), origin) {
+		server.logger.WarnContext(r.Context(), "WebSocket handshake from disallowed origin rejected", "origin", origin)
+		return false
+	}
+	return true
+}
+
+// CORS middleware, answers the preflight requests and adds the CORS headers to the responses
+// of allowed origins. Requests of other origins get no CORS headers, so browsers block them
+func (server *Server) CORSMiddlware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// The allowed origins can be reloaded, so they're read on every request
+		config := server.runtime.Get()
+		header := ctx.Writer.Header()
+		header.Add("Vary", "Origin")
+
+		origin := ctx.GetHeader("Origin")
+		preflight := ctx.Request.Method == http.MethodOptions && ctx.GetHeader("Access-Control-Request-Method") != ""
+		if origin == "" {
+			ctx.Next()
+			return
+		}
+
+		if !originAllowed(config, origin) {
+			if preflight {
+				ctx.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Origin", origin)
+		if config.CORSCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORSMaxAge.Seconds())))
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		header.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		ctx.Next()
+	}
+}
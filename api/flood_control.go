@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/ratelimit"
+	"github.com/danglnh07/zola/util"
+)
+
+// WebSocket flood control policies, built from the runtime config on every frame so they can be reloaded
+func wsFramePolicy(config *util.Config) ratelimit.Policy {
+	return ratelimit.Policy{Name: "ws-frame", Limit: config.WSMaxFrames, Interval: config.WSFrameRefillRate}
+}
+
+func wsAccountFramePolicy(config *util.Config) ratelimit.Policy {
+	return ratelimit.Policy{Name: "ws-account-frame", Limit: config.WSAccountMaxFrames, Interval: config.WSAccountFrameRefillRate}
+}
+
+func wsViolationPolicy(config *util.Config) ratelimit.Policy {
+	return ratelimit.Policy{Name: "ws-violation", Limit: config.WSMaxViolations, Interval: config.WSViolationForgiveRate}
+}
+
+// Slow mode policy of the conversation of the message, nil if slow mode is disabled. The interval
+// set by a moderator on the conversation overrides the one of the config for its chat type
+func (server *Server) slowModePolicy(ctx context.Context, message *db.Message) (*ratelimit.Policy, error) {
+	config := server.runtime.Get()
+	interval := config.PublicSlowMode
+	if message.ChatType == db.PrivateChat {
+		interval = config.PrivateSlowMode
+	}
+
+	slowMode, err := server.queries.SlowModes.Get(ctx, conversationKey(message))
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		interval = time.Duration(slowMode.IntervalSeconds) * time.Second
+	}
+
+	if interval <= 0 {
+		return nil, nil
+	}
+	return &ratelimit.Policy{Name: "slow-mode", Limit: 1, Interval: interval}, nil
+}
+
+// Key of the conversation of the message, the public chat or the private conversation
+func conversationKey(message *db.Message) string {
+	if message.ReceiverID == nil {
+		return db.PublicConversation
+	}
+	return db.PrivateConversation(message.SenderID, *message.ReceiverID)
+}
+
+// Key of the slow mode bucket, every sender has their own bucket in every conversation
+func slowModeKey(message *db.Message) string {
+	if message.ReceiverID == nil {
+		return fmt.Sprintf("account:%d:public", message.SenderID)
+	}
+	return fmt.Sprintf("account:%d:private:%d", message.SenderID, *message.ReceiverID)
+}
+
+// Flood control of a single WebSocket connection. The connection buckets are kept in their own
+// memory store, so they are dropped with the connection, while the account bucket is in the
+// server store to be shared by all the connections of the account
+type frameLimiter struct {
+	client *pubsub.Client
+	local  *ratelimit.MemoryStore
+}
+
+// Constructor method for frameLimiter
+func newFrameLimiter(client *pubsub.Client) *frameLimiter {
+	return &frameLimiter{
+		client: client,
+		local:  ratelimit.NewMemoryStore(2),
+	}
+}
+
+// Method to check an inbound frame against the connection and account limits. A rejected frame
+// is answered with an error event and counts as a violation, return false once the client
+// has too many violations and should be disconnected
+func (server *Server) allowFrame(ctx context.Context, limiter *frameLimiter) bool {
+	config := server.runtime.Get()
+
+	policy := wsFramePolicy(config)
+	result, err := limiter.local.Allow(ctx, "connection", policy)
+	if err == nil && result.Allowed {
+		// Let the frame pass if the store is unavailable, like the HTTP rate limiting does
+		policy = wsAccountFramePolicy(config)
+		key := fmt.Sprintf("account:%d", limiter.client.AccountID)
+		accountResult, err := server.rateLimits.Allow(ctx, key, policy)
+		if err != nil {
+			server.logger.ErrorContext(ctx, "failed to check WebSocket rate limit", "id", limiter.client.AccountID, "error", err)
+			return true
+		}
+		result = accountResult
+	}
+	if result.Allowed {
+		return true
+	}
+	metrics.RateLimitRejections.WithLabelValues(policy.Name).Inc()
+
+	violation, _ := limiter.local.Allow(ctx, "connection", wsViolationPolicy(config))
+	if !violation.Allowed {
+		server.logger.WarnContext(ctx, "disconnecting WebSocket client flooding the server", "id", limiter.client.AccountID)
+		return false
+	}
+
+	err = limiter.client.WriteMessage(pubsub.Event{
+		Type: pubsub.Error,
+		Payload: pubsub.ErrorPayload{
+			Code:       pubsub.ErrorRateLimited,
+			Message:    "Too many messages at a time",
+			RetryAfter: result.RetryAfter.Milliseconds(),
+		},
+	})
+	if err != nil {
+		server.logger.WarnContext(ctx, "failed to send error event", "id", limiter.client.AccountID, "error", err)
+	}
+	return true
+}
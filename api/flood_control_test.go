@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/gorilla/websocket"
+)
+
+// The frame budget of an account is shared by all its connections, opening another tab
+// doesn't give a fresh budget
+func TestAccountFrameBudgetShared(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"WS_MAX_FRAMES":                "100",
+		"WS_ACCOUNT_MAX_FRAMES":        "2",
+		"WS_ACCOUNT_FRAME_REFILL_RATE": "3600",
+	})
+	alice, aliceToken := ts.createAccount(t, "alice")
+	conns := []*websocket.Conn{ts.connect(t, alice.ID, aliceToken), ts.connect(t, alice.ID, aliceToken)}
+
+	// Each connection stays under its own limit, but not the account together
+	for i, frames := range []int{2, 1} {
+		for range frames {
+			if err := conns[i].WriteMessage(websocket.TextMessage, []byte("{}")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The frames of the connections are processed concurrently, either of them gets the error
+	rejected := 0
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			_, data, err := conn.ReadMessage()
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var event struct {
+				Type    string              `json:"type"`
+				Payload pubsub.ErrorPayload `json:"payload"`
+			}
+			if err := json.Unmarshal(data, &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Type == pubsub.Error && event.Payload.Code == pubsub.ErrorRateLimited {
+				rejected++
+			}
+		}
+	}
+	if rejected != 1 {
+		t.Fatalf("got %d rejected frames, want 1", rejected)
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/danglnh07/zola/service/health"
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+type HealthResponse struct {
+	Status string          `json:"status"`
+	Checks []health.Result `json:"checks,omitempty"`
+}
+
+// Liveness probe, the process is up and serving requests
+func (server *Server) HandleHealthz(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readiness probe, fail if a dependency is unavailable or if the server is shutting down
+func (server *Server) HandleReadyz(ctx *gin.Context) {
+	if server.health.Draining() {
+		ctx.JSON(http.StatusServiceUnavailable, HealthResponse{Status: "draining"})
+		return
+	}
+
+	checks, healthy := server.health.Run(ctx)
+	if !healthy {
+		server.logger.WarnContext(ctx, "GET /readyz: server is not ready", "checks", checks)
+		ctx.JSON(http.StatusServiceUnavailable, HealthResponse{Status: "failing", Checks: checks})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, HealthResponse{Status: "ok", Checks: checks})
+}
+
+type BuildInfo struct {
+	GoVersion string `json:"go_version"`
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified"`
+}
+
+type AdminStatusResponse struct {
+	Build      BuildInfo           `json:"build"`
+	StartedAt  time.Time           `json:"started_at"`
+	Uptime     string              `json:"uptime"`
+	Clients    int                 `json:"clients"` // Open WebSocket connections
+	Draining   bool                `json:"draining"`
+	Checks     []health.Result     `json:"checks"`
+	Queues     []metrics.QueueStat `json:"queues"`
+	QueueError string              `json:"queue_error,omitempty"`
+}
+
+// Get the build info embedded by the Go toolchain
+func buildInfo() BuildInfo {
+	var info BuildInfo
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	info.GoVersion = build.GoVersion
+	info.Version = build.Main.Version
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// Get the status of the server and its dependencies, for admins only
+func (server *Server) HandleAdminStatus(ctx *gin.Context) {
+	checks, _ := server.health.Run(ctx)
+	res := AdminStatusResponse{
+		Build:     buildInfo(),
+		StartedAt: server.startedAt,
+		Uptime:    time.Since(server.startedAt).Round(time.Second).String(),
+		Clients:   server.hub.Count(),
+		Draining:  server.health.Draining(),
+		Checks:    checks,
+	}
+
+	queues, err := server.queueStats()
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /admin/status: failed to get queue stats", "error", err)
+		res.QueueError = err.Error()
+	}
+	res.Queues = queues
+
+	ctx.JSON(http.StatusOK, res)
+}
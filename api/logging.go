@@ -0,0 +1,87 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/danglnh07/zola/service/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Check that a request ID sent by the client is safe to log and echo back
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+	for _, char := range requestID {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9':
+		case char == '-', char == '_', char == '.', char == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Request ID middleware, honors the X-Request-ID sent by the client or a proxy and generates
+// one otherwise. The ID is returned in the response and carried by the request context, so
+// every line logged for the request, down to the background tasks it creates, can be tied together
+func (server *Server) RequestIDMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestID := ctx.GetHeader(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		ctx.Header(requestIDHeader, requestID)
+		ctx.Request = ctx.Request.WithContext(logging.WithRequestID(ctx.Request.Context(), requestID))
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("zola.request_id", requestID))
+
+		ctx.Next()
+	}
+}
+
+// Access log middleware, logs one line per request once it's handled
+func (server *Server) AccessLogMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", ctx.Request.Method),
+			slog.String("route", ctx.FullPath()),
+			slog.String("path", ctx.Request.URL.Path), // Without the query, which may hold link tokens
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int("bytes", max(ctx.Writer.Size(), 0)),
+			slog.String("client_ip", ctx.ClientIP()),
+			slog.String("user_agent", ctx.Request.UserAgent()),
+		}
+		if len(ctx.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", ctx.Errors.String()))
+		}
+		server.logger.LogAttrs(ctx, level, "HTTP request", attrs...)
+	}
+}
+
+// Recovery middleware, logs the panics of the handlers with the request context and answers 500
+func (server *Server) RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(ctx *gin.Context, err any) {
+		server.logger.ErrorContext(ctx, "Handler panicked", "error", err, "stack", string(debug.Stack()))
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+	})
+}
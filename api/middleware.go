@@ -2,20 +2,50 @@ package api
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/danglnh07/zola/db"
 	"github.com/danglnh07/zola/service/security"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 const (
-	claimsKey = "claims-key"
+	claimsKey        = "claims-key"
+	accountKey       = "account-key"
+	resolvedTokenKey = "resolved-token-key"
 )
 
+// Token of the request resolved to its claims and account
+type resolvedToken struct {
+	token   string
+	claims  *security.CustomClaims
+	account *db.Account
+	err     error
+}
+
+// Verify the token and fetch its account. The result is cached in the context, so the rate
+// limiters and the AuthMiddleware only fetch the account once per request. The claims are nil
+// if the token is invalid, the account is nil if it couldn't be fetched
+func (server *Server) resolveToken(ctx *gin.Context, token string) (*security.CustomClaims, *db.Account, error) {
+	if value, ok := ctx.Get(resolvedTokenKey); ok {
+		if resolved := value.(*resolvedToken); resolved.token == token {
+			return resolved.claims, resolved.account, resolved.err
+		}
+	}
+
+	resolved := &resolvedToken{token: token}
+	resolved.claims, resolved.err = server.jwtService.VerifyToken(token)
+	if resolved.err != nil {
+		resolved.claims = nil
+	} else {
+		resolved.account, resolved.err = server.queries.Accounts.GetByID(ctx, resolved.claims.ID)
+	}
+	ctx.Set(resolvedTokenKey, resolved)
+
+	return resolved.claims, resolved.account, resolved.err
+}
+
 func (server *Server) AuthMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Get the token from request header
@@ -25,26 +55,34 @@ func (server *Server) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Verify token
-		claims, err := server.jwtService.VerifyToken(token)
-		if err != nil {
+		// Verify token, and fetch its account unless a rate limiter already did
+		claims, account, err := server.resolveToken(ctx, token)
+		if claims == nil {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{"Invalid token: " + err.Error()})
 			return
 		}
 
 		// Check if the token version is match with database
-		var account db.Account
-		result := server.queries.DB.First(&account, claims.ID)
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		if errors.Is(err, db.ErrNotFound) {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{"Invalid token: ID not exists"})
 			return
 		}
+		if err != nil {
+			server.logger.ErrorContext(ctx, "failed to fetch account from database", "error", err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
 
 		if claims.Version != int(account.TokenVersion) {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{"Invalid token: token version not match"})
 			return
 		}
 
+		if account.BannedAt != nil {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{"Account is banned"})
+			return
+		}
+
 		// Check token type
 		path := ctx.FullPath()
 		tokenType := security.TokenType(claims.TokenType)
@@ -57,6 +95,7 @@ func (server *Server) AuthMiddleware() gin.HandlerFunc {
 		if path == "/api/auth/token/refresh" && tokenType == security.RefreshToken ||
 			path != "/api/auth/token/refresh" && tokenType != security.RefreshToken {
 			ctx.Set(claimsKey, claims)
+			ctx.Set(accountKey, account)
 			ctx.Next()
 			return
 		}
@@ -65,23 +104,26 @@ func (server *Server) AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-func (server *Server) CORSMiddlware() gin.HandlerFunc {
+// Only let admins through, must be used after AuthMiddleware
+func (server *Server) AdminMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", fmt.Sprintf("http://%s", server.config.BaseURL))
-		ctx.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
+		account, _ := ctx.Get(accountKey)
+		if account.(*db.Account).Role != db.RoleAdmin {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{"You have no authorization to proceed with this request"})
+			return
+		}
 		ctx.Next()
 	}
 }
 
-// Rate limiting middleware
-func (server *Server) RateLimitingMiddleware() gin.HandlerFunc {
+// Only let moderators and admins through, must be used after AuthMiddleware
+func (server *Server) ModeratorMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		if !server.limiter.Allow() {
-			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{"Too many request at a time"})
+		account, _ := ctx.Get(accountKey)
+		if role := account.(*db.Account).Role; role != db.RoleModerator && role != db.RoleAdmin {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{"You have no authorization to proceed with this request"})
 			return
 		}
-
 		ctx.Next()
 	}
 }
@@ -0,0 +1,641 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/danglnh07/zola/service/worker"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultModerationLimit = 50
+	maxModerationLimit     = 200
+
+	maxMuteDuration = 30 * 24 * 60 // In minutes
+
+	maxSlowModeInterval = 24 * 60 * 60 // In seconds
+)
+
+var reportReasons = []db.ReportReason{
+	db.ReportSpam,
+	db.ReportHarassment,
+	db.ReportHate,
+	db.ReportViolence,
+	db.ReportSexual,
+	db.ReportOther,
+}
+
+// Actions taken on a report, the action on the account closes the report with it
+var reportActions = []db.ModerationActionType{
+	db.ModerationDismiss,
+	db.ModerationDeleteMessage,
+	db.ModerationWarn,
+	db.ModerationMute,
+	db.ModerationBan,
+}
+
+// Actions taken directly on an account, without a report
+var accountActions = []db.ModerationActionType{
+	db.ModerationWarn,
+	db.ModerationMute,
+	db.ModerationUnmute,
+	db.ModerationBan,
+	db.ModerationUnban,
+}
+
+// Returned in the moderation transaction when another moderator closed the report first
+var errReportClosed = errors.New("report is already closed")
+
+type CreateReportRequest struct {
+	AccountID uint            `json:"account_id"` // Not needed when reporting a message
+	MessageID uint            `json:"message_id"`
+	Reason    db.ReportReason `json:"reason" binding:"required"`
+	Details   string          `json:"details" binding:"max=1000"`
+}
+
+// Report an account or one of its messages to the moderators
+func (server *Server) HandleCreateReport(ctx *gin.Context) {
+	var req CreateReportRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/reports: failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	if !slices.Contains(reportReasons, req.Reason) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{fmt.Sprintf("reason must be one of %v", reportReasons)})
+		return
+	}
+	if req.AccountID == 0 && req.MessageID == 0 {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Either account_id or message_id is required"})
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	report := db.Report{
+		ReporterID: requesterID,
+		Reason:     req.Reason,
+		Details:    req.Details,
+		Status:     db.ReportOpen,
+	}
+
+	if req.MessageID != 0 {
+		message, err := server.queries.Messages.GetByID(ctx, req.MessageID)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			server.logger.ErrorContext(ctx, "POST /api/reports: failed to fetch message", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+
+		// Private messages of other conversations are reported as not found
+		if err != nil || message.ChatType == db.PrivateChat && message.SenderID != requesterID &&
+			(message.ReceiverID == nil || *message.ReceiverID != requesterID) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"Message not found"})
+			return
+		}
+		if message.SenderID == requesterID {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"You can't report yourself"})
+			return
+		}
+		if req.AccountID != 0 && req.AccountID != message.SenderID {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"account_id does not match the sender of the message"})
+			return
+		}
+
+		report.AccountID = message.SenderID
+		report.MessageID = &message.ID
+		report.MessageContent = message.Content
+	} else {
+		account, ok := server.targetAccount(ctx, "POST /api/reports", req.AccountID, "report")
+		if !ok {
+			return
+		}
+		report.AccountID = account.ID
+	}
+
+	exists, err := server.queries.Reports.HasOpen(ctx, requesterID, report.AccountID, report.MessageID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/reports: failed to check reports", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if exists {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"You already reported it, the report is waiting for review"})
+		return
+	}
+
+	if err := server.queries.Reports.Create(ctx, &report); err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/reports: failed to create report", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, report)
+}
+
+type ListReportsRequest struct {
+	Status    string `form:"status"` // open by default, all to list every report
+	AccountID uint   `form:"account_id"`
+	Limit     int    `form:"limit"`
+	Offset    int    `form:"offset"`
+}
+
+// Get the moderation queue, the open reports oldest first
+func (server *Server) HandleListReports(ctx *gin.Context) {
+	var req ListReportsRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/moderation/reports: failed to parse query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+
+	filter := db.ReportFilter{
+		Status:    db.ReportStatus(req.Status),
+		AccountID: req.AccountID,
+		Limit:     moderationLimit(req.Limit),
+		Offset:    max(req.Offset, 0),
+	}
+	switch filter.Status {
+	case "":
+		filter.Status = db.ReportOpen
+	case "all":
+		filter.Status = ""
+	case db.ReportOpen, db.ReportResolved, db.ReportDismissed:
+	default:
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"status must be one of open, resolved, dismissed or all"})
+		return
+	}
+
+	reports, err := server.queries.Reports.List(ctx, filter)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/moderation/reports: failed to fetch reports", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"total":   len(reports),
+		"reports": reports,
+	})
+}
+
+func (server *Server) HandleGetReport(ctx *gin.Context) {
+	report, ok := server.reportParam(ctx, "GET /api/moderation/reports/:id")
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+type ModerationActionRequest struct {
+	Action   db.ModerationActionType `json:"action" binding:"required"`
+	Reason   string                  `json:"reason" binding:"max=500"` // Shown to the account, required to warn it
+	Duration int                     `json:"duration"`                 // Minutes, only used to mute
+}
+
+// Close a report of the queue, either by dismissing it or by taking an action on the reported
+// account or message
+func (server *Server) HandleTakeReportAction(ctx *gin.Context) {
+	const route = "POST /api/moderation/reports/:id/actions"
+
+	var req ModerationActionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, route+": failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+	if !slices.Contains(reportActions, req.Action) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{fmt.Sprintf("action must be one of %v", reportActions)})
+		return
+	}
+
+	report, ok := server.reportParam(ctx, route)
+	if !ok {
+		return
+	}
+	if report.Status != db.ReportOpen {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"Report is already closed"})
+		return
+	}
+	if req.Action == db.ModerationDeleteMessage && report.MessageID == nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"The report is not about a message"})
+		return
+	}
+
+	server.moderate(ctx, route, req, report.AccountID, report)
+}
+
+// Take an action on an account without a report, such as lifting a mute or a ban
+func (server *Server) HandleTakeUserAction(ctx *gin.Context) {
+	const route = "POST /api/moderation/users/:id/actions"
+
+	var req ModerationActionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, route+": failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+	if !slices.Contains(accountActions, req.Action) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{fmt.Sprintf("action must be one of %v", accountActions)})
+		return
+	}
+
+	id, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	server.moderate(ctx, route, req, id, nil)
+}
+
+type ListModerationActionsRequest struct {
+	AccountID   uint `form:"account_id"`
+	ModeratorID uint `form:"moderator_id"`
+	Limit       int  `form:"limit"`
+	Offset      int  `form:"offset"`
+}
+
+// Get the audit trail of the moderation actions, latest first
+func (server *Server) HandleListModerationActions(ctx *gin.Context) {
+	var req ListModerationActionsRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/moderation/actions: failed to parse query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+
+	actions, err := server.queries.ModerationActions.List(ctx, db.ModerationActionFilter{
+		AccountID:   req.AccountID,
+		ModeratorID: req.ModeratorID,
+		Limit:       moderationLimit(req.Limit),
+		Offset:      max(req.Offset, 0),
+	})
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/moderation/actions: failed to fetch moderation actions", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"total":   len(actions),
+		"actions": actions,
+	})
+}
+
+type SetSlowModeRequest struct {
+	AccountIDs []uint `json:"account_ids"` // The two accounts of a private conversation, empty for the public chat
+	// Interval in seconds, 0 disables slow mode. Null removes the slow mode of the conversation,
+	// so the interval of the config applies again
+	IntervalSeconds *int `json:"interval_seconds"`
+}
+
+type SlowModeResponse struct {
+	Conversation    string `json:"conversation"`
+	IntervalSeconds int    `json:"interval_seconds"`
+	Default         bool   `json:"default"` // The interval comes from the config
+}
+
+// Set the slow mode of the public chat or of a private conversation, overriding the config
+func (server *Server) HandleSetSlowMode(ctx *gin.Context) {
+	const route = "PUT /api/moderation/slow-mode"
+
+	var req SetSlowModeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, route+": failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+	if req.IntervalSeconds != nil && (*req.IntervalSeconds < 0 || *req.IntervalSeconds > maxSlowModeInterval) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{fmt.Sprintf("interval_seconds must be between 0 and %d", maxSlowModeInterval)})
+		return
+	}
+
+	// Find the conversation
+	conversation := db.PublicConversation
+	chatType := db.PublicChat
+	switch len(req.AccountIDs) {
+	case 0:
+	case 2:
+		if req.AccountIDs[0] == 0 || req.AccountIDs[1] == 0 || req.AccountIDs[0] == req.AccountIDs[1] {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"account_ids must be two different accounts"})
+			return
+		}
+		for _, id := range req.AccountIDs {
+			if _, err := server.queries.Accounts.GetByID(ctx, id); err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					ctx.JSON(http.StatusNotFound, ErrorResponse{"User not found"})
+					return
+				}
+
+				server.logger.ErrorContext(ctx, route+": failed to fetch user from database", "error", err)
+				ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+				return
+			}
+		}
+		conversation = db.PrivateConversation(req.AccountIDs[0], req.AccountIDs[1])
+		chatType = db.PrivateChat
+	default:
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"account_ids must be empty for the public chat, or hold the two accounts of a private conversation"})
+		return
+	}
+
+	value, _ := ctx.Get(accountKey)
+	moderator := value.(*db.Account)
+
+	// Without an interval, the conversation goes back to the interval of the config
+	if req.IntervalSeconds == nil {
+		if _, err := server.queries.SlowModes.Delete(ctx, conversation); err != nil {
+			server.logger.ErrorContext(ctx, route+": failed to remove slow mode", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+
+		config := server.runtime.Get()
+		interval := config.PublicSlowMode
+		if chatType == db.PrivateChat {
+			interval = config.PrivateSlowMode
+		}
+		server.logger.InfoContext(ctx, "Slow mode removed", "conversation", conversation, "moderator_id", moderator.ID)
+		ctx.JSON(http.StatusOK, SlowModeResponse{
+			Conversation:    conversation,
+			IntervalSeconds: int(interval.Seconds()),
+			Default:         true,
+		})
+		return
+	}
+
+	slowMode := db.SlowMode{
+		Conversation:    conversation,
+		IntervalSeconds: *req.IntervalSeconds,
+		SetByID:         &moderator.ID,
+	}
+	if err := server.queries.SlowModes.Set(ctx, &slowMode); err != nil {
+		server.logger.ErrorContext(ctx, route+": failed to set slow mode", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	server.logger.InfoContext(ctx, "Slow mode set", "conversation", conversation, "interval_seconds", slowMode.IntervalSeconds, "moderator_id", moderator.ID)
+	ctx.JSON(http.StatusOK, SlowModeResponse{
+		Conversation:    conversation,
+		IntervalSeconds: slowMode.IntervalSeconds,
+	})
+}
+
+// Clamp the limit of a moderation list
+func moderationLimit(limit int) int {
+	if limit <= 0 {
+		return defaultModerationLimit
+	}
+	return min(limit, maxModerationLimit)
+}
+
+// Get the report of the :id parameter, reply with an error and return false if it's invalid
+func (server *Server) reportParam(ctx *gin.Context, route string) (*db.Report, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid report ID"})
+		return nil, false
+	}
+
+	report, err := server.queries.Reports.GetByID(ctx, uint(id))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"Report not found"})
+			return nil, false
+		}
+
+		server.logger.ErrorContext(ctx, route+": failed to fetch report", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return nil, false
+	}
+
+	return report, true
+}
+
+// Take the moderation action on the account, closing the report if set. The action, its audit
+// entry, the report and the events telling the clients are committed together. A banned
+// account is disconnected right away, the other servers disconnect it once the outbox is relayed
+func (server *Server) moderate(ctx *gin.Context, route string, req ModerationActionRequest, accountID uint, report *db.Report) {
+	value, _ := ctx.Get(accountKey)
+	moderator := value.(*db.Account)
+
+	action := db.ModerationAction{
+		ModeratorID: &moderator.ID,
+		AccountID:   accountID,
+		Action:      req.Action,
+		Reason:      req.Reason,
+	}
+	if report != nil {
+		action.ReportID = &report.ID
+		action.MessageID = report.MessageID
+	}
+
+	// Check the action against the current state of the account
+	var message *db.Message
+	switch req.Action {
+	case db.ModerationDismiss:
+	case db.ModerationDeleteMessage:
+		// The message may already be gone, the report is closed all the same
+		found, err := server.queries.Messages.GetByID(ctx, *report.MessageID)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			server.logger.ErrorContext(ctx, route+": failed to fetch message", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+		if err == nil {
+			message = found
+		}
+	default:
+		account, err := server.queries.Accounts.GetByID(ctx, accountID)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				ctx.JSON(http.StatusNotFound, ErrorResponse{"User not found"})
+				return
+			}
+
+			server.logger.ErrorContext(ctx, route+": failed to fetch user from database", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+
+		// Only admins can moderate the moderators, and no one can moderate the admins
+		if account.Role == db.RoleAdmin || account.Role == db.RoleModerator && moderator.Role != db.RoleAdmin {
+			ctx.JSON(http.StatusForbidden, ErrorResponse{"You can't moderate this user"})
+			return
+		}
+
+		muted := account.MutedUntil != nil && account.MutedUntil.After(time.Now())
+		switch {
+		case req.Action == db.ModerationWarn && req.Reason == "":
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"reason is required to warn a user"})
+			return
+		case req.Action == db.ModerationMute && (req.Duration < 1 || req.Duration > maxMuteDuration):
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{fmt.Sprintf("duration must be between 1 and %d minutes", maxMuteDuration)})
+			return
+		case req.Action == db.ModerationUnmute && !muted:
+			ctx.JSON(http.StatusConflict, ErrorResponse{"User is not muted"})
+			return
+		case req.Action == db.ModerationBan && account.BannedAt != nil:
+			ctx.JSON(http.StatusConflict, ErrorResponse{"User is already banned"})
+			return
+		case req.Action == db.ModerationUnban && account.BannedAt == nil:
+			ctx.JSON(http.StatusConflict, ErrorResponse{"User is not banned"})
+			return
+		}
+
+		if req.Action == db.ModerationMute {
+			expiresAt := time.Now().Add(time.Duration(req.Duration) * time.Minute)
+			action.ExpiresAt = &expiresAt
+		}
+	}
+
+	err := server.queries.Transaction(ctx, func(tx *db.Queries) error {
+		var event *worker.DeliverEventPayload
+		switch req.Action {
+		case db.ModerationDeleteMessage:
+			if message == nil {
+				break
+			}
+			if err := tx.Messages.Delete(ctx, message.ID); err != nil && !errors.Is(err, db.ErrNotFound) {
+				return err
+			}
+
+			// Tell the clients which may show the message, every client for the public chat
+			event = &worker.DeliverEventPayload{
+				Event: pubsub.Event{Type: pubsub.MessageDeleted, Payload: pubsub.MessageDeletedPayload{MessageID: message.ID}},
+			}
+			if message.ChatType == db.PrivateChat && message.ReceiverID != nil {
+				event.AccountIDs = []uint{message.SenderID, *message.ReceiverID}
+			}
+		case db.ModerationWarn:
+			event = &worker.DeliverEventPayload{
+				AccountIDs: []uint{accountID},
+				Event:      pubsub.Event{Type: pubsub.AccountWarned, Payload: pubsub.AccountWarnedPayload{Reason: req.Reason}},
+			}
+		case db.ModerationMute:
+			if err := tx.Accounts.SetMutedUntil(ctx, accountID, action.ExpiresAt); err != nil {
+				return err
+			}
+			event = &worker.DeliverEventPayload{
+				AccountIDs: []uint{accountID},
+				Event: pubsub.Event{
+					Type:    pubsub.AccountMuted,
+					Payload: pubsub.AccountMutedPayload{Reason: req.Reason, Until: *action.ExpiresAt},
+				},
+			}
+		case db.ModerationUnmute:
+			if err := tx.Accounts.SetMutedUntil(ctx, accountID, nil); err != nil {
+				return err
+			}
+		case db.ModerationBan:
+			// Banning revokes the tokens, so the account is rejected by AuthMiddleware
+			if err := tx.Accounts.Ban(ctx, accountID, req.Reason); err != nil {
+				return err
+			}
+			event = &worker.DeliverEventPayload{
+				AccountIDs:  []uint{accountID},
+				Event:       bannedEvent(req.Reason),
+				CloseReason: "account banned",
+			}
+		case db.ModerationUnban:
+			if err := tx.Accounts.Unban(ctx, accountID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.ModerationActions.Create(ctx, &action); err != nil {
+			return err
+		}
+
+		if report != nil {
+			report.Status = db.ReportResolved
+			if req.Action == db.ModerationDismiss {
+				report.Status = db.ReportDismissed
+			}
+			report.Action = req.Action
+			report.ResolvedByID = &moderator.ID
+			if err := tx.Reports.Resolve(ctx, report); err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					return errReportClosed
+				}
+				return err
+			}
+		}
+
+		// The other reports of a deleted message or of a banned account have nothing left to review
+		switch req.Action {
+		case db.ModerationDeleteMessage:
+			if _, err := tx.Reports.ResolveByMessage(ctx, *action.MessageID, moderator.ID); err != nil {
+				return err
+			}
+		case db.ModerationBan:
+			if _, err := tx.Reports.ResolveByAccount(ctx, accountID, moderator.ID); err != nil {
+				return err
+			}
+		}
+
+		if event != nil {
+			outboxEvent, err := worker.NewOutboxEvent(ctx, worker.DeliverEvent, event)
+			if err != nil {
+				return err
+			}
+			if err := tx.Outbox.Create(ctx, outboxEvent); err != nil {
+				return err
+			}
+		}
+
+		// The account is emailed about the actions restricting it, so it's told even if it's offline
+		if req.Action == db.ModerationWarn || req.Action == db.ModerationMute || req.Action == db.ModerationBan {
+			outboxEvent, err := worker.NewOutboxEvent(ctx, worker.SendModerationNotice, worker.ModerationNoticePayload{ActionID: action.ID})
+			if err != nil {
+				return err
+			}
+			return tx.Outbox.Create(ctx, outboxEvent)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errReportClosed) {
+			ctx.JSON(http.StatusConflict, ErrorResponse{"Report is already closed"})
+			return
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"User not found"})
+			return
+		}
+
+		server.logger.ErrorContext(ctx, route+": failed to take moderation action", "action", req.Action, "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Disconnect the banned account from this server right away, without waiting for the outbox
+	if req.Action == db.ModerationBan {
+		if _, err := server.hub.SendTo(accountID, bannedEvent(req.Reason)); err != nil {
+			server.logger.WarnContext(ctx, route+": failed to send ban event", "account_id", accountID, "error", err)
+		}
+		server.hub.Disconnect(accountID, websocket.ClosePolicyViolation, "account banned")
+	}
+
+	server.logger.InfoContext(ctx, "Moderation action taken", "action", req.Action, "account_id", accountID, "moderator_id", moderator.ID)
+
+	ctx.JSON(http.StatusCreated, action)
+}
+
+// Build the event sent to a banned account, right before its connections are closed
+func bannedEvent(reason string) pubsub.Event {
+	return pubsub.Event{Type: pubsub.AccountBanned, Payload: pubsub.AccountBannedPayload{Reason: reason}}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/gorilla/websocket"
+)
+
+func TestSetSlowMode(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"PUBLIC_SLOW_MODE": "60"})
+	moderator, moderatorToken := ts.createAccount(t, "moderator")
+	if err := ts.queries.Accounts.SetRole(context.Background(), moderator.ID, db.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	alice, aliceToken := ts.createAccount(t, "alice")
+	bob, bobToken := ts.createAccount(t, "bob")
+	carol, carolToken := ts.createAccount(t, "carol")
+
+	setSlowMode := func(token string, req SetSlowModeRequest) (int, SlowModeResponse) {
+		t.Helper()
+		var resp SlowModeResponse
+		res := ts.request(t, http.MethodPut, "/api/moderation/slow-mode", token, req, &resp)
+		return res.StatusCode, resp
+	}
+	send := func(token string, senderID, receiverID uint) int {
+		t.Helper()
+		res := ts.request(t, http.MethodPost, "/api/messages", token,
+			SendMessageRequest{SenderID: senderID, ReceiverID: receiverID, Content: "hello"}, nil)
+		return res.StatusCode
+	}
+	interval := func(seconds int) *int {
+		return &seconds
+	}
+
+	// Only the moderators can set the slow mode
+	if status, _ := setSlowMode(aliceToken, SetSlowModeRequest{IntervalSeconds: interval(0)}); status != http.StatusForbidden {
+		t.Fatalf("got status %d for a user, want 403", status)
+	}
+	for _, req := range []SetSlowModeRequest{
+		{IntervalSeconds: interval(-1)},
+		{AccountIDs: []uint{alice.ID}, IntervalSeconds: interval(10)},
+		{AccountIDs: []uint{alice.ID, alice.ID}, IntervalSeconds: interval(10)},
+		{AccountIDs: []uint{alice.ID, 0}, IntervalSeconds: interval(10)},
+	} {
+		if status, _ := setSlowMode(moderatorToken, req); status != http.StatusBadRequest {
+			t.Fatalf("got status %d for %+v, want 400", status, req)
+		}
+	}
+
+	// The interval of the public chat overrides the config
+	status, resp := setSlowMode(moderatorToken, SetSlowModeRequest{IntervalSeconds: interval(0)})
+	if status != http.StatusOK || resp.Conversation != db.PublicConversation || resp.IntervalSeconds != 0 || resp.Default {
+		t.Fatalf("got status %d and %+v", status, resp)
+	}
+	for range 2 {
+		if status := send(aliceToken, alice.ID, 0); status != http.StatusCreated {
+			t.Fatalf("got status %d with slow mode disabled, want 201", status)
+		}
+	}
+
+	// Removing it brings back the interval of the config
+	status, resp = setSlowMode(moderatorToken, SetSlowModeRequest{})
+	if status != http.StatusOK || resp.IntervalSeconds != 60 || !resp.Default {
+		t.Fatalf("got status %d and %+v", status, resp)
+	}
+
+	// A message which isn't stored doesn't count against the slow mode
+	res := ts.request(t, http.MethodPost, "/api/messages", bobToken,
+		SendMessageRequest{SenderID: bob.ID, Content: "hello", AttachmentIDs: []uint{999}}, nil)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %d with an invalid attachment, want 400", res.StatusCode)
+	}
+	if status := send(bobToken, bob.ID, 0); status != http.StatusCreated {
+		t.Fatalf("got status %d, want 201", status)
+	}
+	if status := send(bobToken, bob.ID, 0); status != http.StatusTooManyRequests {
+		t.Fatalf("got status %d with slow mode enabled, want 429", status)
+	}
+	var stored int64
+	if err := ts.queries.DB.Model(&db.Message{}).Where("sender_id = ?", bob.ID).Count(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored != 1 {
+		t.Fatalf("got %d messages stored, the rejected one must be rolled back", stored)
+	}
+
+	// The slow mode of a private conversation applies to both of its accounts, in both directions,
+	// and to no other conversation
+	status, resp = setSlowMode(moderatorToken, SetSlowModeRequest{AccountIDs: []uint{bob.ID, alice.ID}, IntervalSeconds: interval(60)})
+	if status != http.StatusOK || resp.Conversation != db.PrivateConversation(alice.ID, bob.ID) {
+		t.Fatalf("got status %d and %+v", status, resp)
+	}
+	for _, tt := range []struct {
+		token            string
+		sender, receiver uint
+		status           int
+	}{
+		{aliceToken, alice.ID, bob.ID, http.StatusCreated},
+		{aliceToken, alice.ID, bob.ID, http.StatusTooManyRequests},
+		{bobToken, bob.ID, alice.ID, http.StatusCreated},
+		{bobToken, bob.ID, alice.ID, http.StatusTooManyRequests},
+		{carolToken, carol.ID, alice.ID, http.StatusCreated},
+		{carolToken, carol.ID, alice.ID, http.StatusCreated},
+	} {
+		if status := send(tt.token, tt.sender, tt.receiver); status != tt.status {
+			t.Fatalf("got status %d from %d to %d, want %d", status, tt.sender, tt.receiver, tt.status)
+		}
+	}
+}
+
+// Every connection of a banned account is closed, such as its other tabs and devices
+func TestBanDisconnectsEveryConnection(t *testing.T) {
+	ts := newTestServer(t, nil)
+	moderator, moderatorToken := ts.createAccount(t, "moderator")
+	if err := ts.queries.Accounts.SetRole(context.Background(), moderator.ID, db.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	alice, aliceToken := ts.createAccount(t, "alice")
+	conns := []*websocket.Conn{ts.connect(t, alice.ID, aliceToken), ts.connect(t, alice.ID, aliceToken)}
+
+	res := ts.request(t, http.MethodPost, fmt.Sprintf("/api/moderation/users/%d/actions", alice.ID), moderatorToken,
+		ModerationActionRequest{Action: db.ModerationBan, Reason: "spam"}, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/moderation/users/:id/actions: status %d", res.StatusCode)
+	}
+
+	for i, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		banned := false
+		for {
+			var event pubsub.Event
+			err := conn.ReadJSON(&event)
+			if websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("connection %d: %v", i, err)
+			}
+			banned = banned || event.Type == pubsub.AccountBanned
+		}
+		if !banned {
+			t.Errorf("connection %d closed without the ban event", i)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.hub.IsOnline(alice.ID) || ts.hub.Count() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients still subscribed to the hub", ts.hub.Count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
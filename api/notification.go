@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/gin-gonic/gin"
+)
+
+type UpdateNotificationPreferenceRequest struct {
+	EmailEnabled    *bool   `json:"email_enabled"`
+	PushEnabled     *bool   `json:"push_enabled"`
+	DigestDelay     *int    `json:"digest_delay" binding:"omitempty,min=0,max=1440"`
+	QuietHoursStart *string `json:"quiet_hours_start"` // Empty string to disable quiet hours
+	QuietHoursEnd   *string `json:"quiet_hours_end"`   // Empty string to disable quiet hours
+	Timezone        *string `json:"timezone"`
+}
+
+func (server *Server) HandleGetNotificationPreference(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	pref, err := server.queries.NotificationPreferences.GetOrCreate(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/me/notifications: failed to fetch notification preference", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, pref)
+}
+
+func (server *Server) HandleUpdateNotificationPreference(ctx *gin.Context) {
+	var req UpdateNotificationPreferenceRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/notifications: failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	pref, err := server.queries.NotificationPreferences.GetOrCreate(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/notifications: failed to fetch notification preference", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	if req.EmailEnabled != nil {
+		pref.EmailEnabled = *req.EmailEnabled
+	}
+	if req.PushEnabled != nil {
+		pref.PushEnabled = *req.PushEnabled
+	}
+	if req.DigestDelay != nil {
+		pref.DigestDelay = *req.DigestDelay
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid timezone"})
+			return
+		}
+		pref.Timezone = *req.Timezone
+	}
+	if req.QuietHoursStart != nil {
+		pref.QuietHoursStart = req.QuietHoursStart
+	}
+	if req.QuietHoursEnd != nil {
+		pref.QuietHoursEnd = req.QuietHoursEnd
+	}
+
+	// Quiet hours are either both set or both disabled
+	if pref.QuietHoursStart != nil && *pref.QuietHoursStart == "" {
+		pref.QuietHoursStart = nil
+	}
+	if pref.QuietHoursEnd != nil && *pref.QuietHoursEnd == "" {
+		pref.QuietHoursEnd = nil
+	}
+	if (pref.QuietHoursStart == nil) != (pref.QuietHoursEnd == nil) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"quiet_hours_start and quiet_hours_end must be set together"})
+		return
+	}
+	if pref.QuietHoursStart != nil {
+		if _, err := db.ParseClock(*pref.QuietHoursStart); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{err.Error()})
+			return
+		}
+		if _, err := db.ParseClock(*pref.QuietHoursEnd); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{err.Error()})
+			return
+		}
+	}
+
+	if err := server.queries.NotificationPreferences.Save(ctx, pref); err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/notifications: failed to save notification preference", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, pref)
+}
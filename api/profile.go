@@ -0,0 +1,435 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/media"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/gin-gonic/gin"
+)
+
+// Number of accounts returned by the directory search, by default and at most
+const (
+	defaultDirectoryLimit = 20
+	maxDirectoryLimit     = 50
+)
+
+// Profile of an account. The settings are only set on the profile of the requester
+type ProfileData struct {
+	UserData
+	Bio       string    `json:"bio"`
+	Timezone  string    `json:"timezone"`
+	CreatedAt time.Time `json:"created_at"`
+
+	EmailVisibility     db.EmailVisibility `json:"email_visibility,omitempty"`
+	DMPrivacy           db.DMPrivacy       `json:"dm_privacy,omitempty"`
+	DeletionScheduledAt *time.Time         `json:"deletion_scheduled_at,omitempty"`
+	MutedUntil          *time.Time         `json:"muted_until,omitempty"` // Only set while the mute is not over
+}
+
+type UpdateProfileRequest struct {
+	DisplayName     *string             `json:"display_name" binding:"omitempty,max=64"`
+	Handle          *string             `json:"handle"`
+	Bio             *string             `json:"bio" binding:"omitempty,max=500"`
+	Timezone        *string             `json:"timezone"`
+	EmailVisibility *db.EmailVisibility `json:"email_visibility" binding:"omitempty,oneof=everyone contacts nobody"`
+}
+
+// Build the user data of the account, the email is only set if withEmail is true
+func newUserData(account *db.Account, withEmail bool) UserData {
+	data := UserData{
+		ID:          account.ID,
+		Handle:      account.Handle,
+		DisplayName: account.DisplayName,
+		Username:    account.Username,
+	}
+
+	// The file name changes with every upload, so the URL can be cached
+	if account.AvatarPath != "" {
+		version := strings.TrimSuffix(filepath.Base(account.AvatarPath), filepath.Ext(account.AvatarPath))
+		data.AvatarURL = fmt.Sprintf("/api/users/%d/avatar?v=%s", account.ID, version)
+	}
+	if withEmail {
+		data.Email = account.Email
+	}
+	return data
+}
+
+// Build the user data of the accounts as seen by a requester, applying their email visibility
+type userDataBuilder struct {
+	requesterID uint
+	contacts    map[uint]bool
+}
+
+// Constructor method for userDataBuilder, the contacts of the requester are loaded once
+func (server *Server) newUserDataBuilder(ctx context.Context, requesterID uint) (*userDataBuilder, error) {
+	contactIDs, err := server.queries.Contacts.ListContactIDs(ctx, requesterID)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := make(map[uint]bool, len(contactIDs))
+	for _, id := range contactIDs {
+		contacts[id] = true
+	}
+	return &userDataBuilder{requesterID: requesterID, contacts: contacts}, nil
+}
+
+// Method to check if the requester can see the email of the account
+func (builder *userDataBuilder) canSeeEmail(account *db.Account) bool {
+	if account.ID == builder.requesterID {
+		return true
+	}
+
+	switch account.EmailVisibility {
+	case db.EmailEveryone:
+		return true
+	case db.EmailContacts:
+		return builder.contacts[account.ID]
+	default:
+		return false
+	}
+}
+
+// Method to build the user data of the account
+func (builder *userDataBuilder) userData(account *db.Account) UserData {
+	return newUserData(account, builder.canSeeEmail(account))
+}
+
+// Method to build the profile of the account, along with its settings if it's the requester
+func (builder *userDataBuilder) profile(account *db.Account) ProfileData {
+	profile := ProfileData{
+		UserData:  builder.userData(account),
+		Bio:       account.Bio,
+		Timezone:  account.Timezone,
+		CreatedAt: account.CreatedAt,
+	}
+	if account.ID == builder.requesterID {
+		profile.EmailVisibility = account.EmailVisibility
+		profile.DMPrivacy = account.DMPrivacy
+		profile.DeletionScheduledAt = account.DeletionScheduledAt
+		if account.MutedUntil != nil && account.MutedUntil.After(time.Now()) {
+			profile.MutedUntil = account.MutedUntil
+		}
+	}
+	return profile
+}
+
+func (server *Server) HandleGetProfile(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	account, err := server.queries.Accounts.GetByID(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/me: failed to get account from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// The requester can always see their own email, no need to load their contacts
+	builder := &userDataBuilder{requesterID: requesterID}
+	ctx.JSON(http.StatusOK, builder.profile(account))
+}
+
+func (server *Server) HandleUpdateProfile(ctx *gin.Context) {
+	var req UpdateProfileRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me: failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	account, err := server.queries.Accounts.GetByID(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me: failed to get account from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	if req.DisplayName != nil {
+		displayName := strings.TrimSpace(*req.DisplayName)
+		if displayName == "" {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"Display name can't be empty"})
+			return
+		}
+		account.DisplayName = displayName
+	}
+	if req.Handle != nil {
+		// Handles are case insensitive, and may be written with their @
+		handle := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(*req.Handle), "@"))
+		if !db.ValidHandle(handle) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"Handle must be 3 to 30 letters, digits or underscores"})
+			return
+		}
+		account.Handle = handle
+	}
+	if req.Bio != nil {
+		account.Bio = strings.TrimSpace(*req.Bio)
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid timezone"})
+			return
+		}
+		account.Timezone = *req.Timezone
+	}
+	if req.EmailVisibility != nil {
+		account.EmailVisibility = *req.EmailVisibility
+	}
+
+	if err := server.queries.Accounts.UpdateProfile(ctx, account); err != nil {
+		if errors.Is(err, db.ErrDuplicated) {
+			ctx.JSON(http.StatusConflict, ErrorResponse{"Handle is already taken"})
+			return
+		}
+
+		server.logger.ErrorContext(ctx, "PUT /api/users/me: failed to update profile", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	builder := &userDataBuilder{requesterID: requesterID}
+	ctx.JSON(http.StatusOK, builder.profile(account))
+}
+
+func (server *Server) HandleGetUser(ctx *gin.Context) {
+	id, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	account, err := server.queries.Accounts.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"User not found"})
+			return
+		}
+
+		server.logger.ErrorContext(ctx, "GET /api/users/:id: failed to get account from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	builder, err := server.newUserDataBuilder(ctx, claims.(*security.CustomClaims).ID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/:id: failed to fetch contacts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, builder.profile(account))
+}
+
+// Search the user directory by prefix of the handle or the display name
+func (server *Server) HandleSearchUsers(ctx *gin.Context) {
+	query := strings.TrimPrefix(strings.TrimSpace(ctx.Query("q")), "@")
+	if query == "" {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"q is required"})
+		return
+	}
+
+	limit := defaultDirectoryLimit
+	if value := ctx.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDirectoryLimit {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{fmt.Sprintf("limit must be between 1 and %d", maxDirectoryLimit)})
+			return
+		}
+		limit = parsed
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	accounts, err := server.queries.Accounts.Search(ctx, db.DirectorySearchParams{
+		RequesterID: requesterID,
+		Query:       query,
+		Limit:       limit,
+	})
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/search: failed to search users", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	builder, err := server.newUserDataBuilder(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/users/search: failed to fetch contacts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	users := make([]UserData, 0, len(accounts))
+	for _, account := range accounts {
+		users = append(users, builder.userData(&account))
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"total": len(users),
+		"users": users,
+	})
+}
+
+// Upload the avatar of the requester. The center square of the image is kept, scaled down to
+// the avatar size, which also strips the metadata of the image
+func (server *Server) HandleUploadAvatar(ctx *gin.Context) {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, server.config.MaxAvatarSize)
+
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			ctx.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{"File too large"})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Missing file"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/avatar: failed to open uploaded file", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/avatar: failed to read uploaded file", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Sniff the content type instead of trusting the one sent by client
+	if !media.IsSupportedImage(http.DetectContentType(data)) {
+		ctx.JSON(http.StatusUnsupportedMediaType, ErrorResponse{"Avatar must be a JPEG, PNG or GIF image"})
+		return
+	}
+	img, err := media.Decode(data, server.config.MaxImagePixels)
+	if errors.Is(err, media.ErrImageTooLarge) {
+		ctx.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{fmt.Sprintf("Avatar must be at most %d pixels", server.config.MaxImagePixels)})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid image"})
+		return
+	}
+	avatar := img.Avatar(media.AvatarSize)
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	account, err := server.queries.Accounts.GetByID(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/avatar: failed to get account from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Every upload gets a new file name, so the avatar URL changes with it
+	var buf bytes.Buffer
+	if err := avatar.Encode(&buf); err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/avatar: failed to encode avatar", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	dir := filepath.Join(server.config.StorageDir, "avatars", strconv.FormatUint(uint64(requesterID), 10))
+	path := filepath.Join(dir, fmt.Sprintf("%d.%s", time.Now().UnixNano(), avatar.Extension()))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/avatar: failed to create avatar directory", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/avatar: failed to store avatar", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	if err := server.queries.Accounts.SetAvatar(ctx, requesterID, path); err != nil {
+		os.Remove(path)
+		server.logger.ErrorContext(ctx, "PUT /api/users/me/avatar: failed to save avatar", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	server.removeAvatar(ctx, account.AvatarPath)
+
+	account.AvatarPath = path
+	builder := &userDataBuilder{requesterID: requesterID}
+	ctx.JSON(http.StatusOK, builder.profile(account))
+}
+
+func (server *Server) HandleDeleteAvatar(ctx *gin.Context) {
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	account, err := server.queries.Accounts.GetByID(ctx, requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "DELETE /api/users/me/avatar: failed to get account from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	if account.AvatarPath == "" {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"No avatar to delete"})
+		return
+	}
+
+	if err := server.queries.Accounts.SetAvatar(ctx, requesterID, ""); err != nil {
+		server.logger.ErrorContext(ctx, "DELETE /api/users/me/avatar: failed to delete avatar", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	server.removeAvatar(ctx, account.AvatarPath)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (server *Server) HandleGetAvatar(ctx *gin.Context) {
+	id, ok := userIDParam(ctx)
+	if !ok {
+		return
+	}
+
+	account, err := server.queries.Accounts.GetByID(ctx, id)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		server.logger.ErrorContext(ctx, "GET /api/users/:id/avatar: failed to get account from database", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if err != nil || account.AvatarPath == "" {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"Avatar not found"})
+		return
+	}
+
+	// The URL changes with every upload, see newUserData
+	ctx.Header("Cache-Control", "private, max-age=86400")
+	ctx.File(account.AvatarPath)
+}
+
+// Remove the file of a replaced avatar. The account no longer points to it, so a failure only
+// leaves an orphan file behind
+func (server *Server) removeAvatar(ctx context.Context, path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		server.logger.WarnContext(ctx, "failed to remove previous avatar", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/danglnh07/zola/service/webpush"
+	"github.com/gin-gonic/gin"
+)
+
+// Same shape as PushSubscription.toJSON() in the browser
+type CreatePushSubscriptionRequest struct {
+	Endpoint string `json:"endpoint" binding:"required"`
+	Keys     struct {
+		P256dh string `json:"p256dh" binding:"required"`
+		Auth   string `json:"auth" binding:"required"`
+	} `json:"keys" binding:"required"`
+}
+
+func (server *Server) HandleGetVAPIDPublicKey(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, map[string]string{
+		"public_key": server.pusher.PublicKey(),
+	})
+}
+
+func (server *Server) HandleCreatePushSubscription(ctx *gin.Context) {
+	var req CreatePushSubscriptionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/push/subscriptions: failed to parse request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	err := webpush.Subscription{Endpoint: req.Endpoint, P256dh: req.Keys.P256dh, Auth: req.Keys.Auth}.Validate()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	subscription := db.PushSubscription{
+		AccountID: requesterID,
+		Endpoint:  req.Endpoint,
+		P256dh:    req.Keys.P256dh,
+		Auth:      req.Keys.Auth,
+		UserAgent: ctx.Request.UserAgent(),
+	}
+
+	// The same browser may subscribe again (new keys) or another account may log in on it,
+	// so the endpoint always belong to the latest subscriber
+	if err := server.queries.PushSubscriptions.Upsert(ctx, &subscription); err != nil {
+		server.logger.ErrorContext(ctx, "POST /api/push/subscriptions: failed to save push subscription", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, subscription)
+}
+
+func (server *Server) HandleDeletePushSubscription(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid subscription ID"})
+		return
+	}
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	deleted, err := server.queries.PushSubscriptions.DeleteForAccount(ctx, uint(id), requesterID)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "DELETE /api/push/subscriptions/:id: failed to delete push subscription", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{"Subscription not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
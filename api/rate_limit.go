@@ -1,54 +1,91 @@
 package api
 
 import (
-	"sync"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/danglnh07/zola/service/ratelimit"
+	"github.com/danglnh07/zola/util"
+	"github.com/gin-gonic/gin"
 )
 
-// Rate limiter struct, used Token Bucket strategy
-type RateLimiter struct {
-	tokens     int
-	maxToken   int
-	refillRate time.Duration
-	lastRefill time.Time
-	mutex      sync.Mutex
+// Rate limit policies, built from the runtime config on every request so they can be reloaded
+func defaultRateLimitPolicy(config *util.Config) ratelimit.Policy {
+	return ratelimit.Policy{Name: "default", Limit: config.MaxRequest, Interval: config.RefillRate}
 }
 
-// Constructor method for RateLimiter
-func NewRateLimiter(maxToken int, refillRate time.Duration) *RateLimiter {
-	return &RateLimiter{
-		tokens:     maxToken,
-		maxToken:   maxToken,
-		refillRate: refillRate,
-		lastRefill: time.Now(),
-	}
+func sendMessageRateLimitPolicy(config *util.Config) ratelimit.Policy {
+	return ratelimit.Policy{Name: "send-message", Limit: config.MessageMaxRequest, Interval: config.MessageRefillRate}
 }
 
-// Method to check if the current request can pass on, by checking the available token
-// while refill token if needed
-func (limiter *RateLimiter) Allow() bool {
-	// Use mutex to avoid race condition
-	limiter.mutex.Lock()
-	defer limiter.mutex.Unlock()
-
-	// Refill token
-	elapsed := time.Since(limiter.lastRefill)
-	refill := int(elapsed / limiter.refillRate)
-	if refill > 0 {
-		limiter.tokens += refill
-		// If tokens exceed max token, we flatten it down
-		if limiter.tokens > limiter.maxToken {
-			limiter.tokens = limiter.maxToken
+// Rate limiting middleware, every account has its own bucket, and every IP when the request
+// is not authenticated. A revoked token or the token of a banned account counts against the IP,
+// so it can't be used to get a fresh bucket. The AuthMiddleware still rejects these requests
+func (server *Server) RateLimitingMiddleware(policy func(config *util.Config) ratelimit.Policy) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := "ip:" + ctx.ClientIP()
+		if accountID, ok := server.rateLimitAccount(ctx); ok {
+			key = fmt.Sprintf("account:%d", accountID)
+		}
+
+		currentPolicy := policy(server.runtime.Get())
+		result, err := server.rateLimits.Allow(ctx, key, currentPolicy)
+		if err != nil {
+			// Let the request pass, the store being unavailable shouldn't take the API down
+			server.logger.ErrorContext(ctx, "failed to check rate limit", "policy", currentPolicy.Name, "error", err)
+			ctx.Next()
+			return
 		}
-		limiter.lastRefill = time.Now()
+
+		header := ctx.Writer.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", currentPolicy.Limit, ceilSeconds(currentPolicy.Window())))
+
+		if !result.Allowed {
+			metrics.RateLimitRejections.WithLabelValues(currentPolicy.Name).Inc()
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{"Too many request at a time"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Get the account of the request if its token is still valid, the same checks as the AuthMiddleware
+func (server *Server) rateLimitAccount(ctx *gin.Context) (uint, bool) {
+	token := strings.TrimSpace(strings.TrimPrefix(ctx.Request.Header.Get("Authorization"), "Bearer"))
+	if token == "" {
+		return 0, false
 	}
 
-	// Consume token
-	if limiter.tokens > 0 {
-		limiter.tokens--
-		return true
+	claims, account, err := server.resolveToken(ctx, token)
+	if claims == nil {
+		return 0, false
+	}
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			server.logger.ErrorContext(ctx, "failed to fetch account for rate limit", "error", err)
+		}
+		return 0, false
+	}
+	if claims.Version != int(account.TokenVersion) || account.BannedAt != nil {
+		return 0, false
 	}
 
-	// If no token available, simply refuse
-	return false
+	return account.ID, true
+}
+
+// Round up to the next second, so clients never retry too early
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
 }
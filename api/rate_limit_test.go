@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/danglnh07/zola/service/security"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Only a token which would pass the AuthMiddleware gets the bucket of its account
+func TestRateLimitRevokedToken(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"MAX_REQUEST": "2", "REFILL_RATE": "3600"})
+	alice, staleToken := ts.createAccount(t, "alice")
+
+	remaining := func(token string, wantStatus int) string {
+		t.Helper()
+		res := ts.request(t, http.MethodGet, "/api/users/me", token, nil, nil)
+		if res.StatusCode != wantStatus {
+			t.Fatalf("GET /api/users/me: status %d, want %d", res.StatusCode, wantStatus)
+		}
+		return res.Header.Get("RateLimit-Remaining")
+	}
+
+	if got := remaining(staleToken, http.StatusOK); got != "1" {
+		t.Fatalf("got %s remaining requests for the account, want 1", got)
+	}
+
+	// The revoked token counts against the IP until its bucket is empty
+	if err := ts.queries.Accounts.RevokeTokens(context.Background(), alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"1", "0"} {
+		if got := remaining(staleToken, http.StatusUnauthorized); got != want {
+			t.Fatalf("got %s remaining requests for the IP, want %s", got, want)
+		}
+	}
+	remaining(staleToken, http.StatusTooManyRequests)
+
+	// A fresh token still has what's left of the account bucket
+	token, err := ts.jwtService.CreateToken(alice.ID, security.AccessToken, int(alice.TokenVersion)+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := remaining(token, http.StatusOK); got != "0" {
+		t.Fatalf("got %s remaining requests for the account, want 0", got)
+	}
+
+	// A banned account is limited by IP as well
+	bob, bobToken := ts.createAccount(t, "bob")
+	if err := ts.queries.Accounts.Ban(context.Background(), bob.ID, "spam"); err != nil {
+		t.Fatal(err)
+	}
+	remaining(bobToken, http.StatusTooManyRequests)
+}
+
+// The rate limiters and the AuthMiddleware share the account fetched for the request
+func TestRateLimitFetchesAccountOnce(t *testing.T) {
+	ts := newTestServer(t, nil)
+	alice, aliceToken := ts.createAccount(t, "alice")
+
+	// Only count the queries of the request, not the ones of the tasks it enqueues
+	var fetched atomic.Int32
+	err := ts.queries.DB.Callback().Query().After("gorm:query").Register("test:count_accounts", func(tx *gorm.DB) {
+		if _, ok := tx.Statement.Context.(*gin.Context); ok && tx.Statement.Table == "accounts" {
+			fetched.Add(1)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := ts.request(t, http.MethodPost, "/api/messages", aliceToken, SendMessageRequest{SenderID: alice.ID, Content: "hello"}, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/messages: status %d", res.StatusCode)
+	}
+	if got := fetched.Load(); got != 1 {
+		t.Fatalf("fetched the account %d times, want 1", got)
+	}
+}
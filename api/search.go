@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type SearchMessagesRequest struct {
+	Query    string      `form:"q" binding:"required"`
+	ChatType db.ChatType `form:"chat_type"` // Only search in public chat or private chats
+	With     uint        `form:"with"`      // Only search in the private conversation with this account
+	SenderID uint        `form:"sender_id"`
+	From     *time.Time  `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       *time.Time  `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Cursor   string      `form:"cursor"`
+	Limit    int         `form:"limit"`
+}
+
+type SearchMessagesResponse struct {
+	Results    []db.MessageSearchResult `json:"results"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+func (server *Server) HandleSearchMessages(ctx *gin.Context) {
+	var req SearchMessagesRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/messages/search: failed to parse query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+
+	if req.ChatType != "" && req.ChatType != db.PublicChat && req.ChatType != db.PrivateChat {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"chat_type must be either public-chat or private-chat"})
+		return
+	}
+
+	if req.Limit <= 0 {
+		req.Limit = defaultSearchLimit
+	}
+	req.Limit = min(req.Limit, maxSearchLimit)
+
+	claims, _ := ctx.Get(claimsKey)
+	requesterID := claims.(*security.CustomClaims).ID
+
+	params := db.MessageSearchParams{
+		RequesterID: requesterID,
+		Query:       req.Query,
+		ChatType:    req.ChatType,
+		With:        req.With,
+		SenderID:    req.SenderID,
+		From:        req.From,
+		To:          req.To,
+		// Fetch one extra row to know if there is a next page
+		Limit: req.Limit + 1,
+	}
+	if req.Cursor != "" {
+		id, err := decodeCursor(req.Cursor)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid cursor"})
+			return
+		}
+		params.BeforeID = id
+	}
+
+	results, err := server.queries.Messages.Search(ctx, params)
+	if err != nil {
+		server.logger.ErrorContext(ctx, "GET /api/messages/search: failed to search messages", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	resp := SearchMessagesResponse{Results: results}
+	if len(results) > req.Limit {
+		resp.Results = results[:req.Limit]
+		last := resp.Results[len(resp.Results)-1].Message
+		resp.NextCursor = encodeCursor(last.ID)
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// Encode the position of the last returned row into an opaque cursor, the ID of its message
+func encodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+func decodeCursor(cursor string) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if id == 0 {
+		return 0, fmt.Errorf("malformed cursor")
+	}
+
+	return uint(id), nil
+}
@@ -1,67 +1,109 @@
 package api
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/health"
+	"github.com/danglnh07/zola/service/metrics"
 	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/ratelimit"
 	"github.com/danglnh07/zola/service/security"
+	"github.com/danglnh07/zola/service/tracing"
+	"github.com/danglnh07/zola/service/webpush"
 	"github.com/danglnh07/zola/service/worker"
 	"github.com/danglnh07/zola/util"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type Server struct {
-	mux     *gin.Engine
-	queries *db.Queries
+	mux        *gin.Engine
+	httpServer *http.Server
+	queries    *db.Queries
 
-	limiter     *RateLimiter
+	rateLimits  ratelimit.Store
 	jwtService  *security.JWTService
 	oauth       OAuth
 	upgrader    *websocket.Upgrader
 	distributor worker.TaskDistributor
 	hub         *pubsub.Hub
+	pusher      *webpush.Client
 
-	config *util.Config
-	logger *slog.Logger
+	health     *health.Checker
+	queueStats func() ([]metrics.QueueStat, error)
+	startedAt  time.Time
+
+	runtime *util.RuntimeStore
+	config  *util.Config // Config at startup, runtime fields must be read from runtime
+	logger  *slog.Logger
 }
 
 func NewServer(
 	queries *db.Queries,
-	config *util.Config,
+	runtime *util.RuntimeStore,
 	hub *pubsub.Hub,
 	distributor worker.TaskDistributor,
+	pusher *webpush.Client,
+	rateLimits ratelimit.Store,
+	checker *health.Checker,
+	queueStats func() ([]metrics.QueueStat, error),
 	logger *slog.Logger,
 ) *Server {
-	logger.Info("", "Server hub", fmt.Sprintf("%p", hub))
-
 	// Create depenency
+	config := runtime.Get()
 	jwtService := security.NewJWTService(config)
 	oauth := NewGoogleAuth(queries, jwtService, config, logger)
 
-	return &Server{
-		mux:     gin.Default(),
+	// Requests are logged and recovered by our own middlewares, instead of the gin logger
+	mux := gin.New()
+
+	// Handlers pass the gin context down to the database and the task queue, it must carry
+	// the values of the request context such as the trace span
+	mux.ContextWithFallback = true
+
+	// Only trust X-Forwarded-For from the configured proxies, the client IP is used for rate limiting
+	if err := mux.SetTrustedProxies(config.TrustedProxies); err != nil {
+		logger.Error("Invalid trusted proxies", "error", err)
+	}
+
+	server := &Server{
+		mux: mux,
+		httpServer: &http.Server{
+			Addr:    config.ListenAddr,
+			Handler: mux,
+		},
 		queries: queries,
 
-		limiter:    NewRateLimiter(config.MaxRequest, config.RefillRate),
+		rateLimits: rateLimits,
 		jwtService: jwtService,
 		oauth:      oauth,
 		upgrader: &websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
 		},
 		distributor: distributor,
 		hub:         hub,
+		pusher:      pusher,
 
-		config: config,
-		logger: logger,
+		health:     checker,
+		queueStats: queueStats,
+		startedAt:  time.Now(),
+
+		runtime: runtime,
+		config:  config,
+		logger:  logger,
 	}
+
+	// The WebSocket handshake follows the same origin policy as the REST API
+	server.upgrader.CheckOrigin = server.checkWSOrigin
+
+	return server
 }
 
 type ErrorResponse struct {
@@ -70,8 +112,20 @@ type ErrorResponse struct {
 
 // Helper method to register handler to route
 func (server *Server) RegisterHandler() {
+	// Probes are registered before the global middlewares, so they're never rate limited nor logged
+	server.mux.GET("/healthz", server.HandleHealthz)
+	server.mux.GET("/readyz", server.HandleReadyz)
+
 	// Setup global middlewares
-	server.mux.Use(server.CORSMiddlware(), server.RateLimitingMiddleware())
+	server.mux.Use(
+		otelgin.Middleware(tracing.ServiceName),
+		server.RequestIDMiddleware(),
+		server.AccessLogMiddleware(),
+		server.RecoveryMiddleware(),
+		server.MetricsMiddleware(),
+		server.CORSMiddlware(),
+		server.RateLimitingMiddleware(defaultRateLimitPolicy),
+	)
 
 	api := server.mux.Group("/api")
 	{
@@ -79,10 +133,80 @@ func (server *Server) RegisterHandler() {
 		api.GET("/oauth", server.oauth.HandleOAuth)
 
 		// Send messages
-		api.POST("/messages", server.AuthMiddleware(), server.HandleSendMessage)
+		api.POST("/messages", server.RateLimitingMiddleware(sendMessageRateLimitPolicy), server.AuthMiddleware(), server.HandleSendMessage)
+
+		// Mark messages as read
+		api.POST("/messages/read", server.AuthMiddleware(), server.HandleMarkMessagesRead)
+
+		// Search messages
+		api.GET("/messages/search", server.AuthMiddleware(), server.HandleSearchMessages)
+
+		// Attachments
+		api.POST("/attachments", server.AuthMiddleware(), server.HandleUploadAttachment)
+		api.GET("/attachments/:id", server.AuthMiddleware(), server.HandleGetAttachment)
 
 		// Get online users
 		api.GET("/users/online", server.AuthMiddleware(), server.HandleGetOnlineUsers)
+
+		// Profiles and user directory
+		api.GET("/users/me", server.AuthMiddleware(), server.HandleGetProfile)
+		api.PUT("/users/me", server.AuthMiddleware(), server.HandleUpdateProfile)
+		api.PUT("/users/me/avatar", server.AuthMiddleware(), server.HandleUploadAvatar)
+		api.DELETE("/users/me/avatar", server.AuthMiddleware(), server.HandleDeleteAvatar)
+		api.GET("/users/search", server.AuthMiddleware(), server.HandleSearchUsers)
+		api.GET("/users/:id", server.AuthMiddleware(), server.HandleGetUser)
+		api.GET("/users/:id/avatar", server.AuthMiddleware(), server.HandleGetAvatar)
+
+		// Web Push subscriptions
+		api.GET("/push/vapid-public-key", server.HandleGetVAPIDPublicKey)
+		api.POST("/push/subscriptions", server.AuthMiddleware(), server.HandleCreatePushSubscription)
+		api.DELETE("/push/subscriptions/:id", server.AuthMiddleware(), server.HandleDeletePushSubscription)
+
+		// Notification preferences
+		api.GET("/users/me/notifications", server.AuthMiddleware(), server.HandleGetNotificationPreference)
+		api.PUT("/users/me/notifications", server.AuthMiddleware(), server.HandleUpdateNotificationPreference)
+
+		// Blocked and muted users
+		api.GET("/users/me/blocks", server.AuthMiddleware(), server.HandleListBlocks)
+		api.PUT("/users/me/blocks/:id", server.AuthMiddleware(), server.HandleBlockUser)
+		api.DELETE("/users/me/blocks/:id", server.AuthMiddleware(), server.HandleUnblockUser)
+		api.GET("/users/me/mutes", server.AuthMiddleware(), server.HandleListMutes)
+		api.PUT("/users/me/mutes/:id", server.AuthMiddleware(), server.HandleMuteUser)
+		api.DELETE("/users/me/mutes/:id", server.AuthMiddleware(), server.HandleUnmuteUser)
+
+		// Contacts and contact requests
+		api.GET("/contacts", server.AuthMiddleware(), server.HandleListContacts)
+		api.DELETE("/contacts/:id", server.AuthMiddleware(), server.HandleRemoveContact)
+		api.GET("/contacts/requests", server.AuthMiddleware(), server.HandleListContactRequests)
+		api.POST("/contacts/requests", server.AuthMiddleware(), server.HandleSendContactRequest)
+		api.POST("/contacts/requests/:id/accept", server.AuthMiddleware(), server.HandleAcceptContactRequest)
+		api.POST("/contacts/requests/:id/decline", server.AuthMiddleware(), server.HandleDeclineContactRequest)
+		api.DELETE("/contacts/requests/:id", server.AuthMiddleware(), server.HandleCancelContactRequest)
+
+		// Privacy settings
+		api.GET("/users/me/privacy", server.AuthMiddleware(), server.HandleGetPrivacy)
+		api.PUT("/users/me/privacy", server.AuthMiddleware(), server.HandleUpdatePrivacy)
+
+		// Personal data export and account deletion
+		api.GET("/users/me/exports", server.AuthMiddleware(), server.HandleListDataExports)
+		api.POST("/users/me/exports", server.AuthMiddleware(), server.HandleRequestDataExport)
+		api.GET("/exports/download", server.HandleDownloadDataExport)
+		api.POST("/users/me/deletion", server.AuthMiddleware(), server.HandleScheduleAccountDeletion)
+		api.DELETE("/users/me/deletion", server.AuthMiddleware(), server.HandleCancelAccountDeletion)
+
+		// Reports of accounts and messages, reviewed by the moderators
+		api.POST("/reports", server.AuthMiddleware(), server.HandleCreateReport)
+	}
+
+	// Moderation routes, admins are moderators too
+	moderation := server.mux.Group("/api/moderation", server.AuthMiddleware(), server.ModeratorMiddleware())
+	{
+		moderation.GET("/reports", server.HandleListReports)
+		moderation.GET("/reports/:id", server.HandleGetReport)
+		moderation.POST("/reports/:id/actions", server.HandleTakeReportAction)
+		moderation.POST("/users/:id/actions", server.HandleTakeUserAction)
+		moderation.GET("/actions", server.HandleListModerationActions)
+		moderation.PUT("/slow-mode", server.HandleSetSlowMode)
 	}
 
 	// Websocket routes
@@ -91,12 +215,30 @@ func (server *Server) RegisterHandler() {
 		ws.GET("/messages", server.AuthMiddleware(), server.HandleWS)
 	}
 
+	// Admin routes
+	admin := server.mux.Group("/admin", server.AuthMiddleware(), server.AdminMiddleware())
+	{
+		admin.GET("/status", server.HandleAdminStatus)
+	}
+
 	// Callback URL for OAuth2
 	server.mux.GET("/oauth2/callback", server.oauth.HandleCallback)
 }
 
-// Method to start the server
+// Method to start the server, it blocks until the server is shut down
 func (server *Server) Start() error {
 	server.RegisterHandler()
-	return server.mux.Run(":8080")
+	server.logger.Info("Server listening", "address", server.config.ListenAddr)
+
+	err := server.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Method to stop accepting new connections and wait for in-flight requests to complete.
+// WebSocket connections are hijacked, so they're left to the hub
+func (server *Server) Shutdown(ctx context.Context) error {
+	return server.httpServer.Shutdown(ctx)
 }
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/health"
+	"github.com/danglnh07/zola/service/logging"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/ratelimit"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/danglnh07/zola/service/webpush"
+	"github.com/danglnh07/zola/service/worker"
+	"github.com/danglnh07/zola/util"
+	"github.com/gorilla/websocket"
+)
+
+// Mailer discarding the emails
+type testMailer struct{}
+
+func (testMailer) SendEmail(to, subject, body string) error {
+	return nil
+}
+
+// API server backed by an in-memory SQLite database and the in-memory task backend, with the
+// task processor and the outbox relay running
+type testServer struct {
+	*Server
+	url string
+}
+
+// Create and start a test server, env sets config values on top of the defaults
+func newTestServer(t *testing.T, env map[string]string) *testServer {
+	t.Helper()
+
+	t.Setenv("DB_CONN", "sqlite::memory:")
+	t.Setenv("SECRET_KEY", strings.Repeat("s", 40))
+	t.Setenv("TASK_BACKEND", util.TaskBackendMemory)
+	t.Setenv("STORAGE_DIR", t.TempDir())
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	config, _, err := util.LoadConfig([]string{"-env-file", filepath.Join(t.TempDir(), ".env")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logging.NewLogger(io.Discard, "text", nil)
+
+	queries, err := db.NewQueries(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := db.NewMigrator(queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pusher := webpush.NewClient(keys, "mailto:admin@example.com")
+
+	runtime := util.NewRuntimeStore(config, nil, logger)
+	hub := pubsub.NewHub()
+	broker := worker.NewInMemoryBroker(config.MemoryQueueSize)
+	distributor := worker.NewInMemoryTaskDistributor(broker, logger)
+	processor := worker.NewInMemoryTaskProcessor(broker, 2, queries, hub, distributor, testMailer{}, pusher, runtime, logger)
+	if err = processor.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		worker.NewOutboxRelay(queries, distributor, logger).Run(relayCtx)
+	}()
+
+	server := NewServer(queries, runtime, hub, distributor, pusher, ratelimit.NewMemoryStore(config.RateLimitCacheSize),
+		health.NewChecker(), broker.QueueStats, logger)
+	server.RegisterHandler()
+	httpServer := httptest.NewServer(server.mux)
+
+	// Same order as the serve command
+	t.Cleanup(func() {
+		httpServer.Close()
+		stopRelay()
+		<-relayDone
+		processor.Shutdown()
+		hub.Shutdown(0)
+		queries.Close()
+	})
+
+	return &testServer{Server: server, url: httpServer.URL}
+}
+
+// Create an account and an access token for it
+func (ts *testServer) createAccount(t *testing.T, handle string) (*db.Account, string) {
+	t.Helper()
+
+	account := &db.Account{
+		Username:        handle,
+		Email:           handle + "@example.com",
+		OauthProvider:   "google",
+		OauthProviderID: handle,
+		Handle:          handle,
+	}
+	if err := ts.queries.Accounts.Create(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+	token, err := ts.jwtService.CreateToken(account.ID, security.AccessToken, int(account.TokenVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account, token
+}
+
+// Send a JSON request, and decode the JSON response into out if it's not nil
+func (ts *testServer) request(t *testing.T, method, path, token string, body, out any) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.url+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+	return res
+}
+
+// Connect a WebSocket client, and wait until the hub has subscribed it
+func (ts *testServer) connect(t *testing.T, accountID uint, token string) *websocket.Conn {
+	t.Helper()
+
+	connected := ts.hub.Count()
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.url, "http")+"/ws/messages", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !ts.hub.IsOnline(accountID) || ts.hub.Count() <= connected {
+		if time.Now().After(deadline) {
+			t.Fatal("client not subscribed to the hub")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return conn
+}
+
+// Read the next message pushed to the client
+func readMessage(t *testing.T, conn *websocket.Conn) db.Message {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message db.Message
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// A message posted to the API goes through the outbox, the task queue and the hub to the
+// connected receiver
+func TestSendMessageDelivered(t *testing.T) {
+	ts := newTestServer(t, nil)
+	alice, aliceToken := ts.createAccount(t, "alice")
+	bob, bobToken := ts.createAccount(t, "bob")
+	conn := ts.connect(t, bob.ID, bobToken)
+
+	var sent db.Message
+	res := ts.request(t, http.MethodPost, "/api/messages", aliceToken,
+		SendMessageRequest{SenderID: alice.ID, ReceiverID: bob.ID, Content: "hello bob"}, &sent)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/messages: status %d", res.StatusCode)
+	}
+
+	received := readMessage(t, conn)
+	if received.ID != sent.ID || received.Content != "hello bob" || received.SenderID != alice.ID {
+		t.Fatalf("received %+v, want message %d", received, sent.ID)
+	}
+
+	// Public messages are broadcast to every connected client, the sender included
+	aliceConn := ts.connect(t, alice.ID, aliceToken)
+	res = ts.request(t, http.MethodPost, "/api/messages", aliceToken,
+		SendMessageRequest{SenderID: alice.ID, Content: "hello everyone"}, &sent)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/messages: status %d", res.StatusCode)
+	}
+	for _, conn := range []*websocket.Conn{conn, aliceConn} {
+		if received := readMessage(t, conn); received.ID != sent.ID || received.ChatType != db.PublicChat {
+			t.Fatalf("received %+v, want public message %d", received, sent.ID)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/worker"
+	"github.com/danglnh07/zola/util"
+)
+
+const broadcastUsage = `Usage: zola broadcast <message>
+
+Send a system announcement to every connected client. It is delivered by the running server,
+once it relays the outbox event`
+
+// Run the broadcast subcommand
+func RunBroadcast(args []string, config *util.Config, logger *slog.Logger) error {
+	message := strings.TrimSpace(strings.Join(args, " "))
+	if message == "" {
+		return usageError(broadcastUsage)
+	}
+
+	queries, _, err := connectDatabase(config, logger)
+	if err != nil {
+		return err
+	}
+	defer queries.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	event, err := worker.NewOutboxEvent(ctx, worker.DeliverEvent, worker.DeliverEventPayload{
+		Event: pubsub.Event{
+			Type:    pubsub.Announcement,
+			Payload: pubsub.AnnouncementPayload{Message: message, SentAt: time.Now()},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := queries.Outbox.Create(ctx, event); err != nil {
+		return err
+	}
+
+	logger.Info("Announcement queued", "outbox_event_id", event.ID)
+	return nil
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Handles are 3 to 30 lowercase letters, digits or underscores
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+// Check if the handle is valid, handles are lowercased before being checked
+func ValidHandle(handle string) bool {
+	return handlePattern.MatchString(handle)
+}
+
+// Derive a handle from a name, such as the one given by the OAuth provider
+func handleFrom(name string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			builder.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.':
+			builder.WriteRune('_')
+		}
+	}
+
+	handle := strings.Trim(builder.String(), "_")
+	if len(handle) > 25 {
+		handle = handle[:25]
+	}
+	if len(handle) < 3 {
+		handle = "user"
+	}
+	return handle
+}
+
+// Name shown in place of the name of a deleted account
+const deletedAccountName = "Deleted user"
+
+// Escape the LIKE wildcards of a user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Filter of the user directory search
+type DirectorySearchParams struct {
+	RequesterID uint   // Accounts blocking or blocked by the requester are left out
+	Query       string // Prefix of the handle or the display name, case insensitive
+	Limit       int
+}
+
+// Filter of the account list, the zero value matches every account
+type AccountFilter struct {
+	Search string // Part of the username, email or handle, case insensitive
+	Role   Role
+	Banned *bool
+	Limit  int
+	Offset int
+}
+
+// Account repository backed by gorm, used by both Postgres and SQLite
+type gormAccountRepository struct {
+	DB *gorm.DB
+}
+
+func (repo *gormAccountRepository) GetByID(ctx context.Context, id uint) (*Account, error) {
+	var account Account
+	if err := repo.DB.WithContext(ctx).First(&account, id).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (repo *gormAccountRepository) ListByIDs(ctx context.Context, ids []uint) ([]Account, error) {
+	var accounts []Account
+	if len(ids) == 0 {
+		return accounts, nil
+	}
+
+	err := repo.DB.WithContext(ctx).Where("id IN ?", ids).Order("id").Find(&accounts).Error
+	return accounts, err
+}
+
+func (repo *gormAccountRepository) GetByOAuth(ctx context.Context, provider OauthProvider, providerID string) (*Account, error) {
+	var account Account
+	result := repo.DB.WithContext(ctx).
+		Where("oauth_provider = ? AND oauth_provider_id = ?", provider, providerID).
+		First(&account)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &account, nil
+}
+
+// Create the account. If the handle is not set, one is derived from the username, with a random
+// suffix if it's already taken
+func (repo *gormAccountRepository) Create(ctx context.Context, account *Account) error {
+	if account.DisplayName == "" {
+		account.DisplayName = account.Username
+	}
+	if account.Handle == "" {
+		handle, err := repo.availableHandle(ctx, handleFrom(account.Username))
+		if err != nil {
+			return err
+		}
+		account.Handle = handle
+	}
+	return repo.DB.WithContext(ctx).Create(account).Error
+}
+
+// Find a handle not taken yet, starting with base then adding a random suffix to it
+func (repo *gormAccountRepository) availableHandle(ctx context.Context, base string) (string, error) {
+	handle := base
+	for range 10 {
+		var count int64
+		err := repo.DB.WithContext(ctx).Model(&Account{}).Unscoped().Where("handle = ?", handle).Count(&count).Error
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return handle, nil
+		}
+		handle = fmt.Sprintf("%s%04d", base, rand.IntN(10000))
+	}
+	return "", fmt.Errorf("no handle available for %q", base)
+}
+
+// Search the user directory, matching accounts are ordered by handle. Banned accounts are left out
+func (repo *gormAccountRepository) Search(ctx context.Context, params DirectorySearchParams) ([]Account, error) {
+	pattern := likeEscaper.Replace(strings.ToLower(params.Query)) + "%"
+
+	var accounts []Account
+	err := repo.DB.WithContext(ctx).
+		Where(`(handle LIKE ? ESCAPE '\' OR LOWER(display_name) LIKE ? ESCAPE '\')`, pattern, pattern).
+		Where("banned_at IS NULL").
+		Where("id NOT IN (SELECT blocked_id FROM blocks WHERE blocker_id = ?)", params.RequesterID).
+		Where("id NOT IN (SELECT blocker_id FROM blocks WHERE blocked_id = ?)", params.RequesterID).
+		Order("handle").
+		Limit(params.Limit).
+		Find(&accounts).Error
+	return accounts, err
+}
+
+// Save the profile fields of the account, return ErrDuplicated if the handle is taken
+func (repo *gormAccountRepository) UpdateProfile(ctx context.Context, account *Account) error {
+	return repo.update(ctx, account.ID, map[string]any{
+		"display_name":     account.DisplayName,
+		"handle":           account.Handle,
+		"bio":              account.Bio,
+		"timezone":         account.Timezone,
+		"email_visibility": account.EmailVisibility,
+	})
+}
+
+// Set the path of the avatar, empty to remove it
+func (repo *gormAccountRepository) SetAvatar(ctx context.Context, id uint, path string) error {
+	return repo.update(ctx, id, map[string]any{"avatar_path": path})
+}
+
+func (repo *gormAccountRepository) List(ctx context.Context, filter AccountFilter) ([]Account, error) {
+	query := repo.DB.WithContext(ctx).Order("id")
+	if filter.Search != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(filter.Search)) + "%"
+		query = query.Where(`(LOWER(username) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\' OR handle LIKE ? ESCAPE '\')`,
+			pattern, pattern, pattern)
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.Banned != nil {
+		if *filter.Banned {
+			query = query.Where("banned_at IS NOT NULL")
+		} else {
+			query = query.Where("banned_at IS NULL")
+		}
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	var accounts []Account
+	err := query.Find(&accounts).Error
+	return accounts, err
+}
+
+func (repo *gormAccountRepository) Ban(ctx context.Context, id uint, reason string) error {
+	return repo.update(ctx, id, map[string]any{
+		"banned_at":     time.Now(),
+		"ban_reason":    reason,
+		"token_version": gorm.Expr("COALESCE(token_version, 0) + 1"),
+	})
+}
+
+func (repo *gormAccountRepository) Unban(ctx context.Context, id uint) error {
+	return repo.update(ctx, id, map[string]any{
+		"banned_at":  nil,
+		"ban_reason": "",
+	})
+}
+
+func (repo *gormAccountRepository) RevokeTokens(ctx context.Context, id uint) error {
+	return repo.update(ctx, id, map[string]any{"token_version": gorm.Expr("COALESCE(token_version, 0) + 1")})
+}
+
+func (repo *gormAccountRepository) SetRole(ctx context.Context, id uint, role Role) error {
+	return repo.update(ctx, id, map[string]any{"role": role})
+}
+
+func (repo *gormAccountRepository) SetDMPrivacy(ctx context.Context, id uint, privacy DMPrivacy) error {
+	return repo.update(ctx, id, map[string]any{"dm_privacy": privacy})
+}
+
+// Mute the account until the given time, nil lifts the mute
+func (repo *gormAccountRepository) SetMutedUntil(ctx context.Context, id uint, until *time.Time) error {
+	return repo.update(ctx, id, map[string]any{"muted_until": until})
+}
+
+// Schedule the deletion of the account at the given time, nil cancels the pending deletion
+func (repo *gormAccountRepository) ScheduleDeletion(ctx context.Context, id uint, at *time.Time) error {
+	return repo.update(ctx, id, map[string]any{"deletion_scheduled_at": at})
+}
+
+func (repo *gormAccountRepository) ListDueDeletions(ctx context.Context, before time.Time, limit int) ([]uint, error) {
+	// SQLite compares the times as text, the time is bound in the local time zone like gorm stores them
+	var ids []uint
+	err := repo.DB.WithContext(ctx).Model(&Account{}).
+		Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?", before.Local()).
+		Order("id").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// Delete the account. The row is kept for the messages it sent, but everything identifying
+// its owner is removed, the OAuth identity included, so signing in again creates a new account
+func (repo *gormAccountRepository) Erase(ctx context.Context, id uint) error {
+	return repo.update(ctx, id, map[string]any{
+		"username":              deletedAccountName,
+		"display_name":          deletedAccountName,
+		"handle":                fmt.Sprintf("deleted-%d", id), // Not a valid handle, so no one can take it
+		"email":                 "",
+		"bio":                   "",
+		"avatar_path":           "",
+		"timezone":              "UTC",
+		"email_visibility":      EmailNobody,
+		"oauth_provider_id":     fmt.Sprintf("deleted:%d", id),
+		"deletion_scheduled_at": nil,
+		"token_version":         gorm.Expr("COALESCE(token_version, 0) + 1"),
+		"deleted_at":            time.Now(),
+	})
+}
+
+// Update the columns of an account, return ErrNotFound if it does not exist
+func (repo *gormAccountRepository) update(ctx context.Context, id uint, columns map[string]any) error {
+	result := repo.DB.WithContext(ctx).Model(&Account{}).Where("id = ?", id).Updates(columns)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Returned when some attachments cannot be attached to the message, because they don't exist,
+// belong to another account or were already sent
+var ErrInvalidAttachments = errors.New("invalid attachments")
+
+// Attachment repository backed by gorm, used by both Postgres and SQLite
+type gormAttachmentRepository struct {
+	DB *gorm.DB
+}
+
+func (repo *gormAttachmentRepository) Create(ctx context.Context, attachment *Attachment) error {
+	return repo.DB.WithContext(ctx).Create(attachment).Error
+}
+
+// Get the attachment along with its message and thumbnails
+func (repo *gormAttachmentRepository) GetByID(ctx context.Context, id uint) (*Attachment, error) {
+	var attachment Attachment
+	result := repo.DB.WithContext(ctx).Preload("Message").Preload("Thumbnails").First(&attachment, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &attachment, nil
+}
+
+func (repo *gormAttachmentRepository) UpdatePath(ctx context.Context, attachment *Attachment) error {
+	return repo.DB.WithContext(ctx).Model(attachment).Update("path", attachment.Path).Error
+}
+
+// Attach the attachments uploaded by the sender to the message, and load them into message.Attachments
+func (repo *gormAttachmentRepository) AttachToMessage(ctx context.Context, message *Message, attachmentIDs []uint) error {
+	DB := repo.DB.WithContext(ctx)
+
+	// Only attachments uploaded by the sender and not yet sent can be attached
+	result := DB.Model(&Attachment{}).
+		Where("id IN ? AND uploader_id = ? AND message_id IS NULL", attachmentIDs, message.SenderID).
+		Update("message_id", message.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if int(result.RowsAffected) != len(attachmentIDs) {
+		return ErrInvalidAttachments
+	}
+
+	return DB.Preload("Thumbnails").Where("message_id = ?", message.ID).Find(&message.Attachments).Error
+}
+
+// Get the attachments uploaded by the account, oldest first
+func (repo *gormAttachmentRepository) ListByUploader(ctx context.Context, uploaderID uint) ([]Attachment, error) {
+	var attachments []Attachment
+	err := repo.DB.WithContext(ctx).Where("uploader_id = ?", uploaderID).Order("id").Find(&attachments).Error
+	return attachments, err
+}
+
+// Delete the attachments uploaded by the account but never sent, along with their thumbnails.
+// Return the deleted attachments, so their files can be removed
+func (repo *gormAttachmentRepository) DeleteUnsent(ctx context.Context, uploaderID uint) ([]Attachment, error) {
+	var attachments []Attachment
+	err := repo.DB.WithContext(ctx).Where("uploader_id = ? AND message_id IS NULL", uploaderID).Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	return attachments, deleteAttachments(repo.DB.WithContext(ctx), attachments)
+}
+
+func (repo *gormAttachmentRepository) MarkFailed(ctx context.Context, id uint) error {
+	return repo.DB.WithContext(ctx).Model(&Attachment{}).Where("id = ?", id).Update("status", AttachmentFailed).Error
+}
+
+// Save the result of the image processing, replacing the thumbnails of previous attempts
+func (repo *gormAttachmentRepository) SaveProcessed(
+	ctx context.Context,
+	attachment *Attachment,
+	thumbnails []AttachmentThumbnail,
+) error {
+	return repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("attachment_id = ?", attachment.ID).Delete(&AttachmentThumbnail{}).Error; err != nil {
+			return err
+		}
+		if len(thumbnails) > 0 {
+			if err := tx.Create(&thumbnails).Error; err != nil {
+				return err
+			}
+		}
+
+		err := tx.Model(attachment).Select("size", "width", "height", "placeholder", "status").Updates(attachment).Error
+		if err != nil {
+			return err
+		}
+
+		attachment.Thumbnails = thumbnails
+		return nil
+	})
+}
+
+// Hard delete the attachments and their thumbnails
+func deleteAttachments(DB *gorm.DB, attachments []Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(attachments))
+	for _, attachment := range attachments {
+		ids = append(ids, attachment.ID)
+	}
+	if err := DB.Unscoped().Where("attachment_id IN ?", ids).Delete(&AttachmentThumbnail{}).Error; err != nil {
+		return err
+	}
+	return DB.Unscoped().Delete(&Attachment{}, ids).Error
+}
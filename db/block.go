@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Block repository backed by gorm, used by both Postgres and SQLite
+type gormBlockRepository struct {
+	DB *gorm.DB
+}
+
+// Block the account, blocking it again is a no-op
+func (repo *gormBlockRepository) Create(ctx context.Context, blockerID, blockedID uint) error {
+	return repo.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "blocker_id"}, {Name: "blocked_id"}},
+		DoNothing: true,
+	}).Create(&Block{BlockerID: blockerID, BlockedID: blockedID}).Error
+}
+
+// Unblock the account, return false if it was not blocked
+func (repo *gormBlockRepository) Delete(ctx context.Context, blockerID, blockedID uint) (bool, error) {
+	result := repo.DB.WithContext(ctx).Unscoped().
+		Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).
+		Delete(&Block{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Get the blocks of the blocker along with the blocked accounts, latest first
+func (repo *gormBlockRepository) ListByBlocker(ctx context.Context, blockerID uint) ([]Block, error) {
+	var blocks []Block
+	err := repo.DB.WithContext(ctx).
+		Preload("Blocked").
+		Where("blocker_id = ?", blockerID).
+		Order("created_at DESC, id DESC").
+		Find(&blocks).Error
+	return blocks, err
+}
+
+// Report whether either account blocked the other
+func (repo *gormBlockRepository) Between(ctx context.Context, accountID, otherID uint) (bool, error) {
+	var count int64
+	err := repo.DB.WithContext(ctx).Model(&Block{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)",
+			accountID, otherID, otherID, accountID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Delete the blocks made by the account and the ones made against it
+func (repo *gormBlockRepository) DeleteByAccount(ctx context.Context, accountID uint) error {
+	return repo.DB.WithContext(ctx).Unscoped().
+		Where("blocker_id = ? OR blocked_id = ?", accountID, accountID).
+		Delete(&Block{}).Error
+}
+
+func (repo *gormBlockRepository) ListBlockedIDs(ctx context.Context, blockerID uint) ([]uint, error) {
+	var ids []uint
+	err := repo.DB.WithContext(ctx).Model(&Block{}).
+		Where("blocker_id = ?", blockerID).
+		Pluck("blocked_id", &ids).Error
+	return ids, err
+}
+
+func (repo *gormBlockRepository) ListBlockerIDs(ctx context.Context, blockedID uint) ([]uint, error) {
+	var ids []uint
+	err := repo.DB.WithContext(ctx).Model(&Block{}).
+		Where("blocked_id = ?", blockedID).
+		Pluck("blocker_id", &ids).Error
+	return ids, err
+}
+
+// Mute repository backed by gorm, used by both Postgres and SQLite
+type gormMuteRepository struct {
+	DB *gorm.DB
+}
+
+// Mute the account, muting it again is a no-op
+func (repo *gormMuteRepository) Create(ctx context.Context, muterID, mutedID uint) error {
+	return repo.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "muter_id"}, {Name: "muted_id"}},
+		DoNothing: true,
+	}).Create(&Mute{MuterID: muterID, MutedID: mutedID}).Error
+}
+
+// Unmute the account, return false if it was not muted
+func (repo *gormMuteRepository) Delete(ctx context.Context, muterID, mutedID uint) (bool, error) {
+	result := repo.DB.WithContext(ctx).Unscoped().
+		Where("muter_id = ? AND muted_id = ?", muterID, mutedID).
+		Delete(&Mute{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Get the mutes of the muter along with the muted accounts, latest first
+func (repo *gormMuteRepository) ListByMuter(ctx context.Context, muterID uint) ([]Mute, error) {
+	var mutes []Mute
+	err := repo.DB.WithContext(ctx).
+		Preload("Muted").
+		Where("muter_id = ?", muterID).
+		Order("created_at DESC, id DESC").
+		Find(&mutes).Error
+	return mutes, err
+}
+
+// Delete the mutes made by the account and the ones made against it
+func (repo *gormMuteRepository) DeleteByAccount(ctx context.Context, accountID uint) error {
+	return repo.DB.WithContext(ctx).Unscoped().
+		Where("muter_id = ? OR muted_id = ?", accountID, accountID).
+		Delete(&Mute{}).Error
+}
+
+func (repo *gormMuteRepository) Exists(ctx context.Context, muterID, mutedID uint) (bool, error) {
+	var count int64
+	err := repo.DB.WithContext(ctx).Model(&Mute{}).
+		Where("muter_id = ? AND muted_id = ?", muterID, mutedID).
+		Count(&count).Error
+	return count > 0, err
+}
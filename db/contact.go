@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Contact repository backed by gorm, used by both Postgres and SQLite
+type gormContactRepository struct {
+	DB *gorm.DB
+}
+
+func (repo *gormContactRepository) Create(ctx context.Context, contact *Contact) error {
+	return repo.DB.WithContext(ctx).Create(contact).Error
+}
+
+// Get the contact along with both accounts
+func (repo *gormContactRepository) GetByID(ctx context.Context, id uint) (*Contact, error) {
+	var contact Contact
+	err := repo.DB.WithContext(ctx).Preload("Requester").Preload("Addressee").First(&contact, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// Get the contact or the pending request between the accounts, whoever sent it
+func (repo *gormContactRepository) Between(ctx context.Context, accountID, otherID uint) (*Contact, error) {
+	var contact Contact
+	err := repo.DB.WithContext(ctx).
+		Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)",
+			accountID, otherID, otherID, accountID).
+		First(&contact).Error
+	if err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+func (repo *gormContactRepository) AreContacts(ctx context.Context, accountID, otherID uint) (bool, error) {
+	var count int64
+	err := repo.DB.WithContext(ctx).Model(&Contact{}).
+		Where("status = ?", ContactAccepted).
+		Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)",
+			accountID, otherID, otherID, accountID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Accept the pending request, return ErrNotFound if there is no such pending request
+func (repo *gormContactRepository) Accept(ctx context.Context, contact *Contact) error {
+	now := time.Now()
+	result := repo.DB.WithContext(ctx).Model(&Contact{}).
+		Where("id = ? AND status = ?", contact.ID, ContactPending).
+		Updates(map[string]any{"status": ContactAccepted, "accepted_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	contact.Status = ContactAccepted
+	contact.AcceptedAt = &now
+	return nil
+}
+
+func (repo *gormContactRepository) Delete(ctx context.Context, id uint) error {
+	return repo.DB.WithContext(ctx).Unscoped().Delete(&Contact{}, id).Error
+}
+
+// Delete the contact or the pending request between the accounts, return false if there was none
+func (repo *gormContactRepository) DeleteBetween(ctx context.Context, accountID, otherID uint) (bool, error) {
+	result := repo.DB.WithContext(ctx).Unscoped().
+		Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)",
+			accountID, otherID, otherID, accountID).
+		Delete(&Contact{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Delete the contacts and the pending requests of the account, whoever sent them
+func (repo *gormContactRepository) DeleteByAccount(ctx context.Context, accountID uint) error {
+	return repo.DB.WithContext(ctx).Unscoped().
+		Where("requester_id = ? OR addressee_id = ?", accountID, accountID).
+		Delete(&Contact{}).Error
+}
+
+// Get the contacts of the account along with both accounts, latest first
+func (repo *gormContactRepository) ListContacts(ctx context.Context, accountID uint) ([]Contact, error) {
+	var contacts []Contact
+	err := repo.DB.WithContext(ctx).
+		Preload("Requester").
+		Preload("Addressee").
+		Where("status = ? AND (requester_id = ? OR addressee_id = ?)", ContactAccepted, accountID, accountID).
+		Order("accepted_at DESC, id DESC").
+		Find(&contacts).Error
+	return contacts, err
+}
+
+func (repo *gormContactRepository) ListContactIDs(ctx context.Context, accountID uint) ([]uint, error) {
+	var contacts []Contact
+	err := repo.DB.WithContext(ctx).
+		Select("requester_id", "addressee_id").
+		Where("status = ? AND (requester_id = ? OR addressee_id = ?)", ContactAccepted, accountID, accountID).
+		Find(&contacts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(contacts))
+	for _, contact := range contacts {
+		ids = append(ids, contact.OtherID(accountID))
+	}
+	return ids, nil
+}
+
+// Get the pending requests received by the account along with their requester, latest first
+func (repo *gormContactRepository) ListIncoming(ctx context.Context, addresseeID uint) ([]Contact, error) {
+	var contacts []Contact
+	err := repo.DB.WithContext(ctx).
+		Preload("Requester").
+		Where("status = ? AND addressee_id = ?", ContactPending, addresseeID).
+		Order("created_at DESC, id DESC").
+		Find(&contacts).Error
+	return contacts, err
+}
+
+// Get the pending requests sent by the account along with their addressee, latest first
+func (repo *gormContactRepository) ListOutgoing(ctx context.Context, requesterID uint) ([]Contact, error) {
+	var contacts []Contact
+	err := repo.DB.WithContext(ctx).
+		Preload("Addressee").
+		Where("status = ? AND requester_id = ?", ContactPending, requesterID).
+		Order("created_at DESC, id DESC").
+		Find(&contacts).Error
+	return contacts, err
+}
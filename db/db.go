@@ -1,28 +1,142 @@
 package db
 
 import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
 	"github.com/danglnh07/zola/util"
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/plugin/opentelemetry/tracing"
+)
+
+// Database dialects
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Prefix of the connection string selecting the SQLite backend, for example
+// sqlite:zola.db or sqlite::memory:
+const sqlitePrefix = "sqlite:"
+
+// Errors returned by the repositories
+var (
+	ErrNotFound   = gorm.ErrRecordNotFound
+	ErrDuplicated = gorm.ErrDuplicatedKey
 )
 
 type Queries struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Dialect string
+
+	Accounts                AccountRepository
+	Messages                MessageRepository
+	Attachments             AttachmentRepository
+	NotificationPreferences NotificationPreferenceRepository
+	PushSubscriptions       PushSubscriptionRepository
+	Blocks                  BlockRepository
+	Mutes                   MuteRepository
+	Contacts                ContactRepository
+	DataExports             DataExportRepository
+	Reports                 ReportRepository
+	ModerationActions       ModerationActionRepository
+	Outbox                  OutboxRepository
+	SlowModes               SlowModeRepository
 }
 
-func NewQueries(config *util.Config) (*Queries, error) {
+func NewQueries(config *util.Config, logger *slog.Logger) (*Queries, error) {
+	// Select the driver from the connection string, Postgres by default
+	dialect := Postgres
+	dialector := postgres.Open(config.DBConn)
+	if dsn, ok := strings.CutPrefix(config.DBConn, sqlitePrefix); ok {
+		dialect = SQLite
+		dialector = sqlite.Open(sqliteDSN(dsn))
+	}
+
 	// Connect to database
-	DB, err := gorm.Open(postgres.Open(config.DBConn), &gorm.Config{})
+	DB, err := gorm.Open(dialector, &gorm.Config{
+		// Translate driver errors into gorm errors, such as gorm.ErrDuplicatedKey
+		TranslateError: true,
+		// Log slow and failed queries with the request context, without the values which may
+		// hold message contents
+		Logger: gormlogger.NewSlogLogger(logger, gormlogger.Config{
+			SlowThreshold:             200 * time.Millisecond,
+			LogLevel:                  gormlogger.Warn,
+			IgnoreRecordNotFoundError: true,
+			ParameterizedQueries:      true,
+		}),
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	// SQLite only allows one writer at a time, a single connection avoids "database is locked"
+	// errors and is required to share an in-memory database
+	if dialect == SQLite {
+		sqlDB, err := DB.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	// Trace every query, without the values which may hold message contents
+	if err := DB.Use(tracing.NewPlugin(tracing.WithDBSystem(dialect), tracing.WithoutQueryVariables(), tracing.WithoutMetrics())); err != nil {
+		return nil, err
+	}
+
 	//Return the queries struct
+	return newQueries(DB, dialect), nil
+}
+
+// Build the queries struct with repositories bound to the DB handle (which can be a transaction)
+func newQueries(DB *gorm.DB, dialect string) *Queries {
 	return &Queries{
-		DB: DB,
-	}, nil
+		DB:      DB,
+		Dialect: dialect,
+
+		Accounts:                &gormAccountRepository{DB: DB},
+		Messages:                &gormMessageRepository{DB: DB, dialect: dialect},
+		Attachments:             &gormAttachmentRepository{DB: DB},
+		NotificationPreferences: &gormNotificationPreferenceRepository{DB: DB},
+		PushSubscriptions:       &gormPushSubscriptionRepository{DB: DB},
+		Blocks:                  &gormBlockRepository{DB: DB},
+		Mutes:                   &gormMuteRepository{DB: DB},
+		Contacts:                &gormContactRepository{DB: DB},
+		DataExports:             &gormDataExportRepository{DB: DB},
+		Reports:                 &gormReportRepository{DB: DB},
+		ModerationActions:       &gormModerationActionRepository{DB: DB},
+		Outbox:                  &gormOutboxRepository{DB: DB},
+		SlowModes:               &gormSlowModeRepository{DB: DB},
+	}
+}
+
+// Method to run fn in a transaction, the repositories of tx are bound to the transaction
+func (queries *Queries) Transaction(ctx context.Context, fn func(tx *Queries) error) error {
+	return queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(newQueries(tx, queries.Dialect))
+	})
+}
+
+// Method to close the connection pool
+func (queries *Queries) Close() error {
+	sqlDB, err := queries.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
 
-func (queries *Queries) AutoMigration() error {
-	return queries.DB.AutoMigrate(&Account{}, &Message{})
+// Enable foreign keys, which SQLite disables by default
+func sqliteDSN(dsn string) string {
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
 }
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/danglnh07/zola/service/logging"
+	"github.com/danglnh07/zola/util"
+)
+
+// Migrated in-memory SQLite database, a new one for every test
+func newTestQueries(t *testing.T) *Queries {
+	t.Helper()
+
+	queries, err := NewQueries(&util.Config{DBConn: "sqlite::memory:"}, logging.NewLogger(io.Discard, "text", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { queries.Close() })
+
+	migrator, err := NewMigrator(queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return queries
+}
+
+func createTestAccount(t *testing.T, queries *Queries, handle string) *Account {
+	t.Helper()
+
+	account := &Account{
+		Username:        handle,
+		Email:           handle + "@example.com",
+		OauthProvider:   "google",
+		OauthProviderID: handle,
+		Handle:          handle,
+	}
+	if err := queries.Accounts.Create(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+// The unique constraints are reported as ErrDuplicated, the handlers rely on it
+func TestErrDuplicated(t *testing.T) {
+	ctx := context.Background()
+	queries := newTestQueries(t)
+	alice := createTestAccount(t, queries, "alice")
+	bob := createTestAccount(t, queries, "bob")
+
+	clientID := "client-1"
+	if err := queries.Messages.Create(ctx, &Message{SenderID: alice.ID, ChatType: PublicChat, Content: "hi", ClientMessageID: &clientID}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		create func() error
+	}{
+		{
+			name: "account oauth provider ID",
+			create: func() error {
+				return queries.Accounts.Create(ctx, &Account{Username: "other", Email: "other@example.com", OauthProvider: "google", OauthProviderID: "alice"})
+			},
+		},
+		{
+			name: "account handle",
+			create: func() error {
+				return queries.Accounts.Create(ctx, &Account{Username: "other", Email: "other@example.com", OauthProvider: "google", OauthProviderID: "other", Handle: "bob"})
+			},
+		},
+		{
+			name: "profile handle",
+			create: func() error {
+				bob.Handle = "alice"
+				return queries.Accounts.UpdateProfile(ctx, bob)
+			},
+		},
+		{
+			name: "message client ID",
+			create: func() error {
+				return queries.Messages.Create(ctx, &Message{SenderID: alice.ID, ChatType: PublicChat, Content: "hi again", ClientMessageID: &clientID})
+			},
+		},
+		{
+			name: "contact request",
+			create: func() error {
+				if err := queries.Contacts.Create(ctx, &Contact{RequesterID: alice.ID, AddresseeID: bob.ID, Status: ContactPending}); err != nil {
+					return err
+				}
+				return queries.Contacts.Create(ctx, &Contact{RequesterID: alice.ID, AddresseeID: bob.ID, Status: ContactPending})
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.create(); !errors.Is(err, ErrDuplicated) {
+				t.Fatalf("got %v, want ErrDuplicated", err)
+			}
+		})
+	}
+
+	// The same client ID can be used by another sender
+	if err := queries.Messages.Create(ctx, &Message{SenderID: bob.ID, ChatType: PublicChat, Content: "hi", ClientMessageID: &clientID}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Data export repository backed by gorm, used by both Postgres and SQLite
+type gormDataExportRepository struct {
+	DB *gorm.DB
+}
+
+func (repo *gormDataExportRepository) Create(ctx context.Context, export *DataExport) error {
+	return repo.DB.WithContext(ctx).Create(export).Error
+}
+
+func (repo *gormDataExportRepository) GetByID(ctx context.Context, id uint) (*DataExport, error) {
+	var export DataExport
+	if err := repo.DB.WithContext(ctx).First(&export, id).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Get the ready export whose download token has this hash
+func (repo *gormDataExportRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*DataExport, error) {
+	var export DataExport
+	err := repo.DB.WithContext(ctx).Where("token_hash = ? AND status = ?", tokenHash, DataExportReady).First(&export).Error
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Get the exports of the account, latest first
+func (repo *gormDataExportRepository) ListByAccount(ctx context.Context, accountID uint) ([]DataExport, error) {
+	var exports []DataExport
+	err := repo.DB.WithContext(ctx).Where("account_id = ?", accountID).Order("id DESC").Find(&exports).Error
+	return exports, err
+}
+
+func (repo *gormDataExportRepository) HasPending(ctx context.Context, accountID uint) (bool, error) {
+	var count int64
+	err := repo.DB.WithContext(ctx).Model(&DataExport{}).
+		Where("account_id = ? AND status = ?", accountID, DataExportPending).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (repo *gormDataExportRepository) Save(ctx context.Context, export *DataExport) error {
+	return repo.DB.WithContext(ctx).Model(export).
+		Select("status", "path", "size", "token_hash", "expires_at").
+		Updates(export).Error
+}
+
+func (repo *gormDataExportRepository) DeleteByAccount(ctx context.Context, accountID uint) error {
+	return repo.DB.WithContext(ctx).Unscoped().Where("account_id = ?", accountID).Delete(&DataExport{}).Error
+}
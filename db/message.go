@@ -0,0 +1,279 @@
+package db
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Load the associated accounts even if they have been deleted, their messages are kept
+func unscoped(DB *gorm.DB) *gorm.DB {
+	return DB.Unscoped()
+}
+
+// Filters of a message search
+type MessageSearchParams struct {
+	RequesterID uint // Only messages visible by this account are returned
+	Query       string
+	ChatType    ChatType
+	With        uint // Only search in the private conversation between requester and this account
+	SenderID    uint
+	From        *time.Time
+	To          *time.Time
+	BeforeID    uint // Only return messages older than this one, the results are ordered from newest to oldest
+	Limit       int
+}
+
+type MessageSearchResult struct {
+	Message Message `json:"message"`
+	Snippet string  `json:"snippet"` // HTML-escaped content with matches wrapped in <mark></mark>
+}
+
+// Message repository backed by gorm. Everything is shared between Postgres and SQLite except
+// the search, which uses full-text search on Postgres
+type gormMessageRepository struct {
+	DB      *gorm.DB
+	dialect string
+}
+
+func (repo *gormMessageRepository) Create(ctx context.Context, message *Message) error {
+	return repo.DB.WithContext(ctx).Create(message).Error
+}
+
+// Get the message along with its sender
+func (repo *gormMessageRepository) GetByID(ctx context.Context, id uint) (*Message, error) {
+	var message Message
+	if err := repo.DB.WithContext(ctx).Preload("Sender", unscoped).First(&message, id).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// Find the message previously sent by the sender with this client message ID
+func (repo *gormMessageRepository) GetByClientID(ctx context.Context, senderID uint, clientMessageID string) (*Message, error) {
+	var message Message
+	result := repo.DB.WithContext(ctx).
+		Preload("Sender", unscoped).
+		Preload("Receiver", unscoped).
+		Preload("Attachments.Thumbnails").
+		Where("sender_id = ? AND client_message_id = ?", senderID, clientMessageID).
+		First(&message)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &message, nil
+}
+
+// Mark all private messages from sender to receiver as read, return the number of updated messages
+func (repo *gormMessageRepository) MarkConversationRead(ctx context.Context, senderID, receiverID uint) (int64, error) {
+	result := repo.DB.WithContext(ctx).Model(&Message{}).
+		Where("sender_id = ? AND receiver_id = ? AND chat_type = ? AND read_at IS NULL", senderID, receiverID, PrivateChat).
+		Update("read_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// Get the unread private messages of the receiver created after since, oldest first. The messages
+// of the senders blocked or muted by the receiver are left out, they must not be notified
+func (repo *gormMessageRepository) ListUnread(ctx context.Context, receiverID uint, since *time.Time) ([]Message, error) {
+	query := repo.DB.WithContext(ctx).
+		Preload("Sender", unscoped).
+		Where("receiver_id = ? AND chat_type = ? AND read_at IS NULL", receiverID, PrivateChat).
+		Where("sender_id NOT IN (SELECT blocked_id FROM blocks WHERE blocker_id = ?)", receiverID).
+		Where("sender_id NOT IN (SELECT muted_id FROM mutes WHERE muter_id = ?)", receiverID)
+	if since != nil {
+		query = query.Where("created_at > ?", *since)
+	}
+
+	var messages []Message
+	err := query.Order("created_at").Find(&messages).Error
+	return messages, err
+}
+
+// Get a page of the messages sent by the account and the private messages it received, ordered
+// by ID. The next page starts after the ID of the last message
+func (repo *gormMessageRepository) ListByAccount(ctx context.Context, accountID, afterID uint, limit int) ([]Message, error) {
+	var messages []Message
+	err := repo.DB.WithContext(ctx).
+		Preload("Attachments").
+		Where("id > ?", afterID).
+		Where("sender_id = ? OR (receiver_id = ? AND chat_type = ?)", accountID, accountID, PrivateChat).
+		Order("id").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// Soft delete the message, so it's hidden from every query. Return ErrNotFound if it does not exist
+func (repo *gormMessageRepository) Delete(ctx context.Context, id uint) error {
+	result := repo.DB.WithContext(ctx).Delete(&Message{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Hard delete the messages sent by the account along with their attachments. Return the deleted
+// attachments, so their files can be removed
+func (repo *gormMessageRepository) DeleteBySender(ctx context.Context, senderID uint) ([]Attachment, error) {
+	DB := repo.DB.WithContext(ctx)
+
+	var attachments []Attachment
+	err := DB.Where("message_id IN (?)", DB.Model(&Message{}).Unscoped().Select("id").Where("sender_id = ?", senderID)).
+		Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteAttachments(DB, attachments); err != nil {
+		return nil, err
+	}
+
+	return attachments, DB.Unscoped().Where("sender_id = ?", senderID).Delete(&Message{}).Error
+}
+
+func (repo *gormMessageRepository) Search(ctx context.Context, params MessageSearchParams) ([]MessageSearchResult, error) {
+	if repo.dialect == Postgres {
+		return repo.searchPostgres(ctx, params)
+	}
+	return repo.searchLike(ctx, params)
+}
+
+// Apply the filters shared by every search implementation
+func (repo *gormMessageRepository) searchFilters(query *gorm.DB, params MessageSearchParams) *gorm.DB {
+	// Requester can only see public messages and the private messages they sent or received,
+	// except the ones sent by the accounts they blocked
+	query = query.
+		Where("messages.deleted_at IS NULL").
+		Where("messages.chat_type = ? OR messages.sender_id = ? OR messages.receiver_id = ?",
+			PublicChat, params.RequesterID, params.RequesterID).
+		Where("messages.sender_id NOT IN (SELECT blocked_id FROM blocks WHERE blocker_id = ?)", params.RequesterID)
+
+	if params.ChatType != "" {
+		query = query.Where("messages.chat_type = ?", params.ChatType)
+	}
+	if params.With != 0 {
+		query = query.Where(
+			"messages.chat_type = ? AND "+
+				"((messages.sender_id = ? AND messages.receiver_id = ?) OR (messages.sender_id = ? AND messages.receiver_id = ?))",
+			PrivateChat, params.RequesterID, params.With, params.With, params.RequesterID,
+		)
+	}
+	if params.SenderID != 0 {
+		query = query.Where("messages.sender_id = ?", params.SenderID)
+	}
+	// SQLite compares the times as text, they're bound in the local time zone like gorm stores them
+	if params.From != nil {
+		query = query.Where("messages.created_at >= ?", params.From.Local())
+	}
+	if params.To != nil {
+		query = query.Where("messages.created_at < ?", params.To.Local())
+	}
+	// IDs follow the creation order, so the cursor doesn't depend on how the times are stored
+	if params.BeforeID != 0 {
+		query = query.Where("messages.id < ?", params.BeforeID)
+	}
+
+	return query.Order("messages.id DESC").Limit(params.Limit)
+}
+
+// Row scanned from the search query
+type messageSearchRow struct {
+	Message
+	Snippet string
+}
+
+// Search using the tsvector column and its GIN index
+func (repo *gormMessageRepository) searchPostgres(ctx context.Context, params MessageSearchParams) ([]MessageSearchResult, error) {
+	DB := repo.DB.WithContext(ctx)
+
+	query := repo.searchFilters(
+		DB.Table("messages, websearch_to_tsquery('simple', ?) AS query", params.Query).
+			Select("messages.*").
+			Where("messages.search_vector @@ query"),
+		params,
+	)
+
+	// Build the snippets only for the page being returned. The content is escaped before
+	// highlighting, so the snippet is safe to render as HTML
+	var rows []messageSearchRow
+	result := DB.
+		Table("(?) AS messages, websearch_to_tsquery('simple', ?) AS query", query, params.Query).
+		Select(`messages.*, ts_headline('simple',
+			replace(replace(replace(messages.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+			query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') AS snippet`).
+		Order("messages.id DESC").
+		Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	results := make([]MessageSearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, MessageSearchResult{Message: row.Message, Snippet: row.Snippet})
+	}
+	return results, nil
+}
+
+// Search with a LIKE scan, used on SQLite. Every term of the query must appear in the content
+func (repo *gormMessageRepository) searchLike(ctx context.Context, params MessageSearchParams) ([]MessageSearchResult, error) {
+	terms := strings.Fields(params.Query)
+	if len(terms) == 0 {
+		return []MessageSearchResult{}, nil
+	}
+
+	query := repo.DB.WithContext(ctx).Model(&Message{})
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	for _, term := range terms {
+		query = query.Where(`messages.content LIKE ? ESCAPE '\'`, "%"+escaper.Replace(term)+"%")
+	}
+
+	var messages []Message
+	if err := repo.searchFilters(query, params).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]MessageSearchResult, 0, len(messages))
+	for _, message := range messages {
+		results = append(results, MessageSearchResult{Message: message, Snippet: highlight(message.Content, terms)})
+	}
+	return results, nil
+}
+
+// Maximum number of characters kept around the first match in a snippet
+const snippetRadius = 80
+
+// Escape the content and wrap the terms in <mark></mark>, keeping only the text around the first match
+func highlight(content string, terms []string) string {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, regexp.QuoteMeta(html.EscapeString(term)))
+	}
+	pattern := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+
+	// Cut the content around the first match
+	runes := []rune(content)
+	start, end := 0, len(runes)
+	if loc := pattern.FindStringIndex(content); loc != nil {
+		first := len([]rune(content[:loc[0]]))
+		start = max(0, first-snippetRadius)
+		end = min(len(runes), first+snippetRadius)
+	} else {
+		end = min(len(runes), 2*snippetRadius)
+	}
+
+	snippet := html.EscapeString(string(runes[start:end]))
+	snippet = pattern.ReplaceAllString(snippet, "<mark>$0</mark>")
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(runes) {
+		snippet += "..."
+	}
+	return snippet
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func createTestMessage(t *testing.T, queries *Queries, message Message) *Message {
+	t.Helper()
+
+	if message.ChatType == "" {
+		message.ChatType = PublicChat
+		if message.ReceiverID != nil {
+			message.ChatType = PrivateChat
+		}
+	}
+	if err := queries.Messages.Create(context.Background(), &message); err != nil {
+		t.Fatal(err)
+	}
+	return &message
+}
+
+func messageIDs(results []MessageSearchResult) []uint {
+	ids := make([]uint, 0, len(results))
+	for _, result := range results {
+		ids = append(ids, result.Message.ID)
+	}
+	return ids
+}
+
+func TestSearchMessages(t *testing.T) {
+	ctx := context.Background()
+	queries := newTestQueries(t)
+	alice := createTestAccount(t, queries, "alice")
+	bob := createTestAccount(t, queries, "bob")
+	carol := createTestAccount(t, queries, "carol")
+
+	public := createTestMessage(t, queries, Message{SenderID: bob.ID, Content: "Lunch at noon?"})
+	toAlice := createTestMessage(t, queries, Message{SenderID: bob.ID, ReceiverID: &alice.ID, Content: "lunch <b>tomorrow</b>"})
+	createTestMessage(t, queries, Message{SenderID: bob.ID, ReceiverID: &carol.ID, Content: "lunch with carol"})
+	fromCarol := createTestMessage(t, queries, Message{SenderID: carol.ID, Content: "no lunch for me"})
+	deleted := createTestMessage(t, queries, Message{SenderID: bob.ID, Content: "deleted lunch"})
+	if err := queries.Messages.Delete(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	percent := createTestMessage(t, queries, Message{SenderID: bob.ID, Content: "100% lunch"})
+
+	search := func(params MessageSearchParams) []MessageSearchResult {
+		t.Helper()
+		params.RequesterID = alice.ID
+		params.Limit = 10
+		results, err := queries.Messages.Search(ctx, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	for _, tt := range []struct {
+		name   string
+		params MessageSearchParams
+		want   []uint
+	}{
+		{
+			// Private messages of other accounts and deleted messages are left out, latest first
+			name:   "visible messages",
+			params: MessageSearchParams{Query: "LUNCH"},
+			want:   []uint{percent.ID, fromCarol.ID, toAlice.ID, public.ID},
+		},
+		{
+			name:   "every term matches",
+			params: MessageSearchParams{Query: "lunch noon"},
+			want:   []uint{public.ID},
+		},
+		{
+			name:   "wildcards are escaped",
+			params: MessageSearchParams{Query: "0%"},
+			want:   []uint{percent.ID},
+		},
+		{
+			name:   "private conversation",
+			params: MessageSearchParams{Query: "lunch", With: bob.ID},
+			want:   []uint{toAlice.ID},
+		},
+		{
+			name:   "sender",
+			params: MessageSearchParams{Query: "lunch", SenderID: carol.ID},
+			want:   []uint{fromCarol.ID},
+		},
+		{
+			name:   "chat type",
+			params: MessageSearchParams{Query: "lunch", ChatType: PrivateChat},
+			want:   []uint{toAlice.ID},
+		},
+		{
+			name:   "empty query",
+			params: MessageSearchParams{Query: "  "},
+			want:   []uint{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageIDs(search(tt.params)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The snippet is escaped before the matches are highlighted
+	results := search(MessageSearchParams{Query: "tomorrow", With: bob.ID})
+	if len(results) != 1 || results[0].Snippet != "lunch &lt;b&gt;<mark>tomorrow</mark>&lt;/b&gt;" {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	// The messages of the accounts blocked by the requester are hidden
+	if err := queries.Blocks.Create(ctx, alice.ID, carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := messageIDs(search(MessageSearchParams{Query: "lunch"})); slices.Contains(got, fromCarol.ID) {
+		t.Errorf("got %v, the message of the blocked account is not hidden", got)
+	}
+}
+
+func TestSearchMessagesPagination(t *testing.T) {
+	ctx := context.Background()
+	queries := newTestQueries(t)
+	alice := createTestAccount(t, queries, "alice")
+
+	// Some messages share the same creation time, and the times are written in different time
+	// zones, which SQLite would compare as text
+	base := time.Now().Add(-time.Hour)
+	zones := []*time.Location{time.UTC, time.FixedZone("", 7*60*60), time.FixedZone("", -5*60*60)}
+	var want []uint
+	for i, offset := range []time.Duration{0, time.Second, time.Second, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		message := createTestMessage(t, queries, Message{SenderID: alice.ID, Content: "page"})
+		message.CreatedAt = base.Add(offset).In(zones[i%len(zones)])
+		if err := queries.DB.Model(message).Update("created_at", message.CreatedAt).Error; err != nil {
+			t.Fatal(err)
+		}
+		want = append([]uint{message.ID}, want...)
+	}
+	createTestMessage(t, queries, Message{SenderID: alice.ID, Content: "other"})
+
+	var got []uint
+	params := MessageSearchParams{RequesterID: alice.ID, Query: "page", Limit: 3}
+	for range len(want) {
+		results, err := queries.Messages.Search(ctx, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, messageIDs(results)...)
+		if len(results) < params.Limit {
+			break
+		}
+		last := results[len(results)-1].Message
+		params.BeforeID = last.ID
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
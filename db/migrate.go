@@ -0,0 +1,324 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Versioned migrations of every dialect, embedded in the binary
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Directory of the migrations, relative to the db package
+const MigrationsDir = "migrations"
+
+// Key of the Postgres advisory lock held while migrating, so concurrent replicas don't race
+const migrationLockKey = 20250907
+
+// Migration file names look like 0001_create_accounts.up.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Returned by Check when some migrations have not been applied yet
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Row of the schema_migrations table, one per applied migration
+type SchemaMigration struct {
+	Version   uint      `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+type MigrationStatus struct {
+	Version   uint
+	Name      string
+	AppliedAt *time.Time // Nil if the migration is pending
+	Missing   bool       // Applied, but unknown to this binary (the database is ahead)
+}
+
+type Migrator struct {
+	DB         *gorm.DB
+	dialect    string
+	migrations []Migration
+}
+
+// Constructor method for Migrator, using the embedded migrations of the database dialect
+func NewMigrator(queries *Queries) (*Migrator, error) {
+	migrations, err := LoadMigrations(queries.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		DB:         queries.DB,
+		dialect:    queries.Dialect,
+		migrations: migrations,
+	}, nil
+}
+
+// Load the embedded migrations of a dialect, sorted by version
+func LoadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join(MigrationsDir, dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %s: %w", dialect, err)
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		matches := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+
+		version, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[uint(version)]
+		if !ok {
+			migration = &Migration{Version: uint(version), Name: matches[2]}
+			byVersion[uint(version)] = migration
+		}
+		if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has several names: %s and %s", version, migration.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return int(a.Version) - int(b.Version) })
+
+	return migrations, nil
+}
+
+// Method to run fn on a single connection, holding the migration lock on Postgres.
+// SQLite only has one connection, so it doesn't need any lock
+func (migrator *Migrator) withLock(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return migrator.DB.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if migrator.dialect == Postgres {
+			// Session level lock, released when the connection is returned to the pool at the latest
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockKey).Error; err != nil {
+				return err
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockKey)
+		}
+
+		if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+			return err
+		}
+
+		return fn(conn)
+	})
+}
+
+// Get the applied migrations, sorted by version
+func (migrator *Migrator) applied(conn *gorm.DB) ([]SchemaMigration, error) {
+	var applied []SchemaMigration
+	err := conn.Order("version").Find(&applied).Error
+	return applied, err
+}
+
+// Method to apply all pending migrations, in order. Each migration runs in its own transaction
+func (migrator *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	err := migrator.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := migrator.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrator.migrations {
+			if slices.ContainsFunc(applied, func(row SchemaMigration) bool { return row.Version == migration.Version }) {
+				continue
+			}
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Up).Error; err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{
+					Version:   migration.Version,
+					Name:      migration.Name,
+					AppliedAt: time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Method to roll back the last steps applied migrations, newest first. A negative steps
+// rolls back every migration
+func (migrator *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+
+	err := migrator.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := migrator.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(applied) - 1; i >= 0 && (steps < 0 || len(done) < steps); i-- {
+			index := slices.IndexFunc(migrator.migrations, func(m Migration) bool { return m.Version == applied[i].Version })
+			if index < 0 {
+				return fmt.Errorf("migration %d_%s is not known by this binary", applied[i].Version, applied[i].Name)
+			}
+			migration := migrator.migrations[index]
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Down).Error; err != nil {
+					return err
+				}
+				return tx.Delete(&SchemaMigration{}, migration.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("failed to roll back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Method to get the status of every migration, either known by this binary or applied
+func (migrator *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var applied []SchemaMigration
+	if migrator.DB.Migrator().HasTable(&SchemaMigration{}) {
+		if err := migrator.DB.WithContext(ctx).Order("version").Find(&applied).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range migrator.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		index := slices.IndexFunc(applied, func(row SchemaMigration) bool { return row.Version == migration.Version })
+		if index >= 0 {
+			status.AppliedAt = &applied[index].AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, row := range applied {
+		if !slices.ContainsFunc(migrator.migrations, func(m Migration) bool { return m.Version == row.Version }) {
+			statuses = append(statuses, MigrationStatus{
+				Version:   row.Version,
+				Name:      row.Name,
+				AppliedAt: &row.AppliedAt,
+				Missing:   true,
+			})
+		}
+	}
+	slices.SortFunc(statuses, func(a, b MigrationStatus) int { return int(a.Version) - int(b.Version) })
+
+	return statuses, nil
+}
+
+// Method to check that every migration known by this binary has been applied. Migrations
+// applied by a newer binary are fine, so an older replica can keep running during a deployment
+func (migrator *Migrator) Check(ctx context.Context) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, fmt.Sprintf("%d_%s", status.Version, status.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w, pending migrations: %s", ErrSchemaBehind, strings.Join(pending, ", "))
+	}
+
+	return nil
+}
+
+// Create empty up and down migration files for every dialect in dir, which should be the
+// migrations directory of the source tree. Return the paths of the created files
+func CreateMigration(dir, name string) ([]string, error) {
+	name = strings.Trim(regexp.MustCompile(`\W+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, errors.New("migration name is empty")
+	}
+
+	// Next version after every existing migration, of any dialect
+	dialects := []string{Postgres, SQLite}
+	var version uint64
+	for _, dialect := range dialects {
+		entries, err := os.ReadDir(filepath.Join(dir, dialect))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if matches := migrationFileName.FindStringSubmatch(entry.Name()); matches != nil {
+				existing, _ := strconv.ParseUint(matches[1], 10, 64)
+				version = max(version, existing)
+			}
+		}
+	}
+	version++
+
+	var paths []string
+	for _, dialect := range dialects {
+		for _, direction := range []string{"up", "down"} {
+			file := filepath.Join(dir, dialect, fmt.Sprintf("%04d_%s.%s.sql", version, name, direction))
+			content := fmt.Sprintf("-- %s migration %04d_%s (%s)\n", strings.ToUpper(direction[:1])+direction[1:], version, name, dialect)
+			if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+				return paths, err
+			}
+			paths = append(paths, file)
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS outbox_events;
+DROP TABLE IF EXISTS push_subscriptions;
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS attachment_thumbnails;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS accounts;
@@ -0,0 +1,120 @@
+-- Baseline schema. Every statement is idempotent, so databases created by the former
+-- AutoMigrate adopt it without changes
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    username TEXT NOT NULL,
+    email TEXT NOT NULL,
+    oauth_provider TEXT NOT NULL,
+    oauth_provider_id TEXT NOT NULL,
+    token_version BIGINT,
+    CONSTRAINT uni_accounts_oauth_provider_id UNIQUE (oauth_provider_id)
+);
+CREATE INDEX IF NOT EXISTS idx_accounts_deleted_at ON accounts (deleted_at);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    sender_id BIGINT,
+    receiver_id BIGINT,
+    chat_type TEXT,
+    content TEXT,
+    read_at TIMESTAMPTZ,
+    client_message_id TEXT,
+    CONSTRAINT fk_messages_sender FOREIGN KEY (sender_id) REFERENCES accounts (id),
+    CONSTRAINT fk_messages_receiver FOREIGN KEY (receiver_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_messages_deleted_at ON messages (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_sender_client_message ON messages (sender_id, client_message_id);
+-- Full-text search on content. The tsvector column is generated by Postgres, so it always
+-- stay in sync with content without any trigger
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content, ''))) STORED;
+CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector);
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    uploader_id BIGINT NOT NULL,
+    message_id BIGINT,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size BIGINT,
+    path TEXT NOT NULL,
+    status TEXT NOT NULL,
+    width BIGINT,
+    height BIGINT,
+    placeholder TEXT,
+    CONSTRAINT fk_attachments_uploader FOREIGN KEY (uploader_id) REFERENCES accounts (id),
+    CONSTRAINT fk_messages_attachments FOREIGN KEY (message_id) REFERENCES messages (id)
+);
+CREATE INDEX IF NOT EXISTS idx_attachments_deleted_at ON attachments (deleted_at);
+
+CREATE TABLE IF NOT EXISTS attachment_thumbnails (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    attachment_id BIGINT NOT NULL,
+    size BIGINT NOT NULL,
+    width BIGINT,
+    height BIGINT,
+    path TEXT NOT NULL,
+    CONSTRAINT fk_attachments_thumbnails FOREIGN KEY (attachment_id) REFERENCES attachments (id)
+);
+CREATE INDEX IF NOT EXISTS idx_attachment_thumbnails_deleted_at ON attachment_thumbnails (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_attachment_thumbnail_size ON attachment_thumbnails (attachment_id, size);
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    account_id BIGINT NOT NULL,
+    email_enabled BOOLEAN NOT NULL DEFAULT true,
+    push_enabled BOOLEAN NOT NULL DEFAULT true,
+    digest_delay BIGINT,
+    quiet_hours_start TEXT,
+    quiet_hours_end TEXT,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    last_digest_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_notification_preferences_deleted_at ON notification_preferences (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_preferences_account_id ON notification_preferences (account_id);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    account_id BIGINT NOT NULL,
+    endpoint TEXT NOT NULL,
+    p256dh TEXT NOT NULL,
+    auth TEXT NOT NULL,
+    user_agent TEXT,
+    CONSTRAINT fk_push_subscriptions_account FOREIGN KEY (account_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_deleted_at ON push_subscriptions (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_account_id ON push_subscriptions (account_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_push_subscriptions_endpoint ON push_subscriptions (endpoint);
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    task_type TEXT NOT NULL,
+    payload BYTEA NOT NULL,
+    dispatched_at TIMESTAMPTZ,
+    attempts BIGINT NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_deleted_at ON outbox_events (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events (dispatched_at) WHERE dispatched_at IS NULL;
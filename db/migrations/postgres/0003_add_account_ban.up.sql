@@ -0,0 +1,3 @@
+-- Banned accounts can't sign in, their tokens are revoked when they're banned
+ALTER TABLE accounts ADD COLUMN banned_at TIMESTAMPTZ;
+ALTER TABLE accounts ADD COLUMN ban_reason TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN dm_privacy;
+DROP TABLE IF EXISTS contacts;
@@ -0,0 +1,20 @@
+-- A contact starts as a pending request from the requester, and becomes a contact of both
+-- accounts once the addressee accepts it
+CREATE TABLE IF NOT EXISTS contacts (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    requester_id BIGINT NOT NULL,
+    addressee_id BIGINT NOT NULL,
+    status TEXT NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    CONSTRAINT fk_contacts_requester FOREIGN KEY (requester_id) REFERENCES accounts (id),
+    CONSTRAINT fk_contacts_addressee FOREIGN KEY (addressee_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_contacts_deleted_at ON contacts (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_contacts_requester_addressee ON contacts (requester_id, addressee_id);
+CREATE INDEX IF NOT EXISTS idx_contacts_addressee_id ON contacts (addressee_id);
+
+-- Who can send private messages to the account, either everyone or only its contacts
+ALTER TABLE accounts ADD COLUMN dm_privacy TEXT NOT NULL DEFAULT 'everyone';
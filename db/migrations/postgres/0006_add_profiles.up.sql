@@ -0,0 +1,13 @@
+-- Profiles are edited by their owner, existing accounts get a handle derived from their ID
+ALTER TABLE accounts ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN handle TEXT NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN avatar_path TEXT NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE accounts ADD COLUMN email_visibility TEXT NOT NULL DEFAULT 'nobody';
+UPDATE accounts SET display_name = username, handle = 'user' || id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_handle ON accounts (handle);
+
+-- Prefix search of the user directory, handles are always lowercase
+CREATE INDEX IF NOT EXISTS idx_accounts_handle_prefix ON accounts (handle text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_accounts_display_name_prefix ON accounts (lower(display_name) text_pattern_ops);
@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN deletion_scheduled_at;
+DROP TABLE IF EXISTS data_exports;
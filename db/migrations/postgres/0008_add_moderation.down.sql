@@ -0,0 +1,3 @@
+ALTER TABLE accounts DROP COLUMN muted_until;
+DROP TABLE IF EXISTS moderation_actions;
+DROP TABLE IF EXISTS reports;
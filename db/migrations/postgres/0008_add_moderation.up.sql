@@ -0,0 +1,51 @@
+-- Reports of accounts and messages, reviewed by the moderators. The message is not a foreign key,
+-- its content is copied so the report outlives the message
+CREATE TABLE IF NOT EXISTS reports (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    reporter_id BIGINT NOT NULL,
+    account_id BIGINT NOT NULL,
+    message_id BIGINT,
+    message_content TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    action TEXT NOT NULL DEFAULT '',
+    resolved_by_id BIGINT,
+    resolved_at TIMESTAMPTZ,
+    CONSTRAINT fk_reports_reporter FOREIGN KEY (reporter_id) REFERENCES accounts (id),
+    CONSTRAINT fk_reports_account FOREIGN KEY (account_id) REFERENCES accounts (id),
+    CONSTRAINT fk_reports_resolved_by FOREIGN KEY (resolved_by_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_reports_deleted_at ON reports (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_reports_reporter_id ON reports (reporter_id);
+CREATE INDEX IF NOT EXISTS idx_reports_account_id ON reports (account_id);
+CREATE INDEX IF NOT EXISTS idx_reports_message_id ON reports (message_id);
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status);
+
+-- Audit trail of the moderation actions, the moderator is not set for the command line
+CREATE TABLE IF NOT EXISTS moderation_actions (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    moderator_id BIGINT,
+    account_id BIGINT NOT NULL,
+    report_id BIGINT,
+    message_id BIGINT,
+    action TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ,
+    CONSTRAINT fk_moderation_actions_moderator FOREIGN KEY (moderator_id) REFERENCES accounts (id),
+    CONSTRAINT fk_moderation_actions_account FOREIGN KEY (account_id) REFERENCES accounts (id),
+    CONSTRAINT fk_moderation_actions_report FOREIGN KEY (report_id) REFERENCES reports (id)
+);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_deleted_at ON moderation_actions (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_moderator_id ON moderation_actions (moderator_id);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_account_id ON moderation_actions (account_id);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_report_id ON moderation_actions (report_id);
+
+-- Muted accounts can't send messages until the mute is over
+ALTER TABLE accounts ADD COLUMN muted_until TIMESTAMPTZ;
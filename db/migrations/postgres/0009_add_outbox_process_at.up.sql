@@ -0,0 +1,3 @@
+-- Events of scheduled tasks, such as account deletions, are relayed right away but only
+-- processed at this time
+ALTER TABLE outbox_events ADD COLUMN process_at TIMESTAMPTZ;
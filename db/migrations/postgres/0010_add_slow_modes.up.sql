@@ -0,0 +1,13 @@
+-- Slow mode interval of a conversation set by a moderator, overriding the configured one
+CREATE TABLE IF NOT EXISTS slow_modes (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    conversation TEXT NOT NULL,
+    interval_seconds BIGINT NOT NULL,
+    set_by_id BIGINT,
+    CONSTRAINT fk_slow_modes_set_by FOREIGN KEY (set_by_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_slow_modes_deleted_at ON slow_modes (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_slow_modes_conversation ON slow_modes (conversation);
@@ -0,0 +1,115 @@
+-- Baseline schema. Every statement is idempotent, so databases created by the former
+-- AutoMigrate adopt it without changes
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    username TEXT NOT NULL,
+    email TEXT NOT NULL,
+    oauth_provider TEXT NOT NULL,
+    oauth_provider_id TEXT NOT NULL,
+    token_version INTEGER,
+    CONSTRAINT uni_accounts_oauth_provider_id UNIQUE (oauth_provider_id)
+);
+CREATE INDEX IF NOT EXISTS idx_accounts_deleted_at ON accounts (deleted_at);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    sender_id INTEGER,
+    receiver_id INTEGER,
+    chat_type TEXT,
+    content TEXT,
+    read_at DATETIME,
+    client_message_id TEXT,
+    CONSTRAINT fk_messages_sender FOREIGN KEY (sender_id) REFERENCES accounts (id),
+    CONSTRAINT fk_messages_receiver FOREIGN KEY (receiver_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_messages_deleted_at ON messages (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_sender_client_message ON messages (sender_id, client_message_id);
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    uploader_id INTEGER NOT NULL,
+    message_id INTEGER,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER,
+    path TEXT NOT NULL,
+    status TEXT NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    placeholder TEXT,
+    CONSTRAINT fk_attachments_uploader FOREIGN KEY (uploader_id) REFERENCES accounts (id),
+    CONSTRAINT fk_messages_attachments FOREIGN KEY (message_id) REFERENCES messages (id)
+);
+CREATE INDEX IF NOT EXISTS idx_attachments_deleted_at ON attachments (deleted_at);
+
+CREATE TABLE IF NOT EXISTS attachment_thumbnails (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    attachment_id INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    path TEXT NOT NULL,
+    CONSTRAINT fk_attachments_thumbnails FOREIGN KEY (attachment_id) REFERENCES attachments (id)
+);
+CREATE INDEX IF NOT EXISTS idx_attachment_thumbnails_deleted_at ON attachment_thumbnails (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_attachment_thumbnail_size ON attachment_thumbnails (attachment_id, size);
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    account_id INTEGER NOT NULL,
+    email_enabled NUMERIC NOT NULL DEFAULT true,
+    push_enabled NUMERIC NOT NULL DEFAULT true,
+    digest_delay INTEGER,
+    quiet_hours_start TEXT,
+    quiet_hours_end TEXT,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    last_digest_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_notification_preferences_deleted_at ON notification_preferences (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_preferences_account_id ON notification_preferences (account_id);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    account_id INTEGER NOT NULL,
+    endpoint TEXT NOT NULL,
+    p256dh TEXT NOT NULL,
+    auth TEXT NOT NULL,
+    user_agent TEXT,
+    CONSTRAINT fk_push_subscriptions_account FOREIGN KEY (account_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_deleted_at ON push_subscriptions (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_account_id ON push_subscriptions (account_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_push_subscriptions_endpoint ON push_subscriptions (endpoint);
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    task_type TEXT NOT NULL,
+    payload BLOB NOT NULL,
+    dispatched_at DATETIME,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_deleted_at ON outbox_events (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events (dispatched_at) WHERE dispatched_at IS NULL;
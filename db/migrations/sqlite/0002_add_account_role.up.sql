@@ -0,0 +1,2 @@
+-- Role of the account, admins can access the admin endpoints
+ALTER TABLE accounts ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN ban_reason;
+ALTER TABLE accounts DROP COLUMN banned_at;
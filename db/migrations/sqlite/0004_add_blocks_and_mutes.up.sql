@@ -0,0 +1,28 @@
+-- Blocked accounts can't send private messages to the blocker, and are hidden from them
+CREATE TABLE IF NOT EXISTS blocks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    blocker_id INTEGER NOT NULL,
+    blocked_id INTEGER NOT NULL,
+    CONSTRAINT fk_blocks_blocker FOREIGN KEY (blocker_id) REFERENCES accounts (id),
+    CONSTRAINT fk_blocks_blocked FOREIGN KEY (blocked_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_blocks_deleted_at ON blocks (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_blocks_blocker_blocked ON blocks (blocker_id, blocked_id);
+CREATE INDEX IF NOT EXISTS idx_blocks_blocked_id ON blocks (blocked_id);
+
+-- Muted accounts don't trigger notifications to the muter, their messages are still delivered
+CREATE TABLE IF NOT EXISTS mutes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    muter_id INTEGER NOT NULL,
+    muted_id INTEGER NOT NULL,
+    CONSTRAINT fk_mutes_muter FOREIGN KEY (muter_id) REFERENCES accounts (id),
+    CONSTRAINT fk_mutes_muted FOREIGN KEY (muted_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_mutes_deleted_at ON mutes (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mutes_muter_muted ON mutes (muter_id, muted_id);
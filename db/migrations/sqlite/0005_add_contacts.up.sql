@@ -0,0 +1,20 @@
+-- A contact starts as a pending request from the requester, and becomes a contact of both
+-- accounts once the addressee accepts it
+CREATE TABLE IF NOT EXISTS contacts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    requester_id INTEGER NOT NULL,
+    addressee_id INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    accepted_at DATETIME,
+    CONSTRAINT fk_contacts_requester FOREIGN KEY (requester_id) REFERENCES accounts (id),
+    CONSTRAINT fk_contacts_addressee FOREIGN KEY (addressee_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_contacts_deleted_at ON contacts (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_contacts_requester_addressee ON contacts (requester_id, addressee_id);
+CREATE INDEX IF NOT EXISTS idx_contacts_addressee_id ON contacts (addressee_id);
+
+-- Who can send private messages to the account, either everyone or only its contacts
+ALTER TABLE accounts ADD COLUMN dm_privacy TEXT NOT NULL DEFAULT 'everyone';
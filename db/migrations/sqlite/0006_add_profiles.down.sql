@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_accounts_handle;
+ALTER TABLE accounts DROP COLUMN email_visibility;
+ALTER TABLE accounts DROP COLUMN timezone;
+ALTER TABLE accounts DROP COLUMN avatar_path;
+ALTER TABLE accounts DROP COLUMN bio;
+ALTER TABLE accounts DROP COLUMN handle;
+ALTER TABLE accounts DROP COLUMN display_name;
@@ -0,0 +1,9 @@
+-- Profiles are edited by their owner, existing accounts get a handle derived from their ID
+ALTER TABLE accounts ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN handle TEXT NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN avatar_path TEXT NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE accounts ADD COLUMN email_visibility TEXT NOT NULL DEFAULT 'nobody';
+UPDATE accounts SET display_name = username, handle = 'user' || id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_handle ON accounts (handle);
@@ -0,0 +1,20 @@
+-- Archives of the personal data of an account, downloaded through an expiring link sent by email
+CREATE TABLE IF NOT EXISTS data_exports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    account_id INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    path TEXT NOT NULL DEFAULT '',
+    size INTEGER NOT NULL DEFAULT 0,
+    token_hash TEXT,
+    expires_at DATETIME,
+    CONSTRAINT fk_data_exports_account FOREIGN KEY (account_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_data_exports_deleted_at ON data_exports (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_data_exports_account_id ON data_exports (account_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_token_hash ON data_exports (token_hash);
+
+-- Accounts are deleted once the grace period is over, unless their owner cancels it before
+ALTER TABLE accounts ADD COLUMN deletion_scheduled_at DATETIME;
@@ -0,0 +1,13 @@
+-- Slow mode interval of a conversation set by a moderator, overriding the configured one
+CREATE TABLE IF NOT EXISTS slow_modes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    conversation TEXT NOT NULL,
+    interval_seconds INTEGER NOT NULL,
+    set_by_id INTEGER,
+    CONSTRAINT fk_slow_modes_set_by FOREIGN KEY (set_by_id) REFERENCES accounts (id)
+);
+CREATE INDEX IF NOT EXISTS idx_slow_modes_deleted_at ON slow_modes (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_slow_modes_conversation ON slow_modes (conversation);
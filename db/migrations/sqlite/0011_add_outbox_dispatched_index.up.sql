@@ -0,0 +1,2 @@
+-- Dispatched events are deleted once they're older than the retention period
+CREATE INDEX IF NOT EXISTS idx_outbox_events_dispatched ON outbox_events (dispatched_at) WHERE dispatched_at IS NOT NULL;
@@ -1,33 +1,291 @@
 package db
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type OauthProvider string
 
 type ChatType string
 
+type Role string
+
+type DMPrivacy string
+
+type ContactStatus string
+
+type EmailVisibility string
+
 const (
 	Google OauthProvider = "google"
 
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator" // Reviews the reports and takes the moderation actions
+	RoleAdmin     Role = "admin"
+
+	DMEveryone DMPrivacy = "everyone"
+	DMContacts DMPrivacy = "contacts" // Only the contacts of the account can send it private messages
+
+	ContactPending  ContactStatus = "pending"
+	ContactAccepted ContactStatus = "accepted"
+
+	EmailEveryone EmailVisibility = "everyone"
+	EmailContacts EmailVisibility = "contacts"
+	EmailNobody   EmailVisibility = "nobody"
+
 	PublicChat  ChatType = "public-chat"
 	PrivateChat ChatType = "private-chat"
 )
 
+// Account embedded in the messages sent to other clients. The email and the OAuth details are
+// never serialized, the API shows the email according to the email visibility of the account
 type Account struct {
 	gorm.Model
-	Username        string `json:"username" gorm:"not null"`
-	Email           string `json:"email" gorm:"not null"`
+	Username        string `json:"username" gorm:"not null"` // Name given by the OAuth provider
+	Email           string `json:"-" gorm:"not null"`
 	OauthProvider   string `json:"oauth_provider" gorm:"not null"`
-	OauthProviderID string `json:"oauth_provider_id" gorm:"unique;not null"`
-	TokenVersion    uint   `json:"token_version"`
+	OauthProviderID string `json:"-" gorm:"unique;not null"`
+	TokenVersion    uint   `json:"-"`
+	Role            Role   `json:"role" gorm:"not null;default:user"`
+
+	BannedAt  *time.Time `json:"banned_at"`
+	BanReason string     `json:"ban_reason" gorm:"not null;default:''"`
+
+	// Set by the moderators, the account can't send messages until this time
+	MutedUntil *time.Time `json:"-"`
+
+	DMPrivacy DMPrivacy `json:"dm_privacy" gorm:"not null;default:everyone"`
+
+	// Profile, edited by the owner of the account
+	DisplayName     string          `json:"display_name" gorm:"not null;default:''"`
+	Handle          string          `json:"handle" gorm:"uniqueIndex;not null"` // Lowercase, see ValidHandle
+	Bio             string          `json:"bio" gorm:"not null;default:''"`
+	AvatarPath      string          `json:"-" gorm:"not null;default:''"`
+	Timezone        string          `json:"timezone" gorm:"not null;default:UTC"`
+	EmailVisibility EmailVisibility `json:"-" gorm:"not null;default:nobody"`
+
+	// Set while the deletion requested by the owner is pending, the account is deleted at this time
+	DeletionScheduledAt *time.Time `json:"-"`
 }
 
 type Message struct {
 	gorm.Model
-	SenderID   uint     `json:"sender_id"`
-	Sender     Account  `json:"sender" gorm:"foreignKey:SenderID"`
-	ReceiverID *uint    `json:"receiver_id"`
-	Receiver   *Account `json:"receiver" gorm:"foreignKey:ReceiverID"`
-	ChatType   ChatType `json:"chat_type"`
-	Content    string   `json:"content"`
+	SenderID   uint       `json:"sender_id" gorm:"uniqueIndex:idx_messages_sender_client_message,priority:1"`
+	Sender     Account    `json:"sender" gorm:"foreignKey:SenderID"`
+	ReceiverID *uint      `json:"receiver_id"`
+	Receiver   *Account   `json:"receiver" gorm:"foreignKey:ReceiverID"`
+	ChatType   ChatType   `json:"chat_type"`
+	Content    string     `json:"content"`
+	ReadAt     *time.Time `json:"read_at"` // Only tracked for private messages
+
+	// ID generated by the client, so retries of the same message are only stored once
+	ClientMessageID *string `json:"client_message_id" gorm:"uniqueIndex:idx_messages_sender_client_message,priority:2"`
+
+	Attachments []Attachment `json:"attachments" gorm:"foreignKey:MessageID"`
+}
+
+type AttachmentStatus string
+
+const (
+	AttachmentPending AttachmentStatus = "pending"
+	AttachmentReady   AttachmentStatus = "ready"
+	AttachmentFailed  AttachmentStatus = "failed"
+)
+
+type Attachment struct {
+	gorm.Model
+	UploaderID  uint                  `json:"uploader_id" gorm:"not null"`
+	Uploader    Account               `json:"-" gorm:"foreignKey:UploaderID"`
+	MessageID   *uint                 `json:"message_id"`
+	Message     *Message              `json:"-" gorm:"foreignKey:MessageID"`
+	FileName    string                `json:"file_name" gorm:"not null"`
+	ContentType string                `json:"content_type" gorm:"not null"`
+	Size        int64                 `json:"size"`
+	Path        string                `json:"-" gorm:"not null"`
+	Status      AttachmentStatus      `json:"status" gorm:"not null"`
+	Width       int                   `json:"width"`
+	Height      int                   `json:"height"`
+	Placeholder string                `json:"placeholder"` // BlurHash of the image
+	Thumbnails  []AttachmentThumbnail `json:"thumbnails" gorm:"foreignKey:AttachmentID"`
+}
+
+type AttachmentThumbnail struct {
+	gorm.Model
+	AttachmentID uint   `json:"attachment_id" gorm:"uniqueIndex:idx_attachment_thumbnail_size;not null"`
+	Size         int    `json:"size" gorm:"uniqueIndex:idx_attachment_thumbnail_size;not null"` // Longest edge
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Path         string `json:"-" gorm:"not null"`
+}
+
+type NotificationPreference struct {
+	gorm.Model
+	AccountID       uint       `json:"account_id" gorm:"uniqueIndex;not null"`
+	EmailEnabled    bool       `json:"email_enabled" gorm:"not null;default:true"`
+	PushEnabled     bool       `json:"push_enabled" gorm:"not null;default:true"`
+	DigestDelay     int        `json:"digest_delay"`      // Minutes to wait before sending the digest, 0 means default
+	QuietHoursStart *string    `json:"quiet_hours_start"` // Local time, in HH:MM format
+	QuietHoursEnd   *string    `json:"quiet_hours_end"`   // Local time, in HH:MM format
+	Timezone        string     `json:"timezone" gorm:"not null;default:UTC"`
+	LastDigestAt    *time.Time `json:"-"`
+}
+
+type PushSubscription struct {
+	gorm.Model
+	AccountID uint    `json:"account_id" gorm:"index;not null"`
+	Account   Account `json:"-" gorm:"foreignKey:AccountID"`
+	Endpoint  string  `json:"endpoint" gorm:"uniqueIndex;not null"`
+	P256dh    string  `json:"-" gorm:"not null"`
+	Auth      string  `json:"-" gorm:"not null"`
+	UserAgent string  `json:"user_agent"` // Used to tell the devices apart
+}
+
+// The blocked account can't send private messages to the blocker, and the blocker doesn't
+// see it anymore: its presence, its public messages and its search results are hidden
+type Block struct {
+	gorm.Model
+	BlockerID uint    `json:"blocker_id" gorm:"uniqueIndex:idx_blocks_blocker_blocked,priority:1;not null"`
+	BlockedID uint    `json:"blocked_id" gorm:"uniqueIndex:idx_blocks_blocker_blocked,priority:2;index;not null"`
+	Blocked   Account `json:"-" gorm:"foreignKey:BlockedID"`
+}
+
+// The muted account doesn't trigger push notifications nor email digests for the muter,
+// its messages are still delivered
+type Mute struct {
+	gorm.Model
+	MuterID uint    `json:"muter_id" gorm:"uniqueIndex:idx_mutes_muter_muted,priority:1;not null"`
+	MutedID uint    `json:"muted_id" gorm:"uniqueIndex:idx_mutes_muter_muted,priority:2;not null"`
+	Muted   Account `json:"-" gorm:"foreignKey:MutedID"`
+}
+
+// Contact request from the requester to the addressee. Once accepted, both accounts are contacts
+// of each other. Declined and cancelled requests are deleted, so they can be sent again
+type Contact struct {
+	gorm.Model
+	RequesterID uint          `json:"requester_id" gorm:"uniqueIndex:idx_contacts_requester_addressee,priority:1;not null"`
+	Requester   Account       `json:"-" gorm:"foreignKey:RequesterID"`
+	AddresseeID uint          `json:"addressee_id" gorm:"uniqueIndex:idx_contacts_requester_addressee,priority:2;index;not null"`
+	Addressee   Account       `json:"-" gorm:"foreignKey:AddresseeID"`
+	Status      ContactStatus `json:"status" gorm:"not null"`
+	AcceptedAt  *time.Time    `json:"accepted_at"`
+}
+
+// Get the ID of the other account of the contact
+func (contact *Contact) OtherID(accountID uint) uint {
+	if contact.RequesterID == accountID {
+		return contact.AddresseeID
+	}
+	return contact.RequesterID
+}
+
+// Get the other account of the contact, both accounts must be loaded
+func (contact *Contact) Other(accountID uint) *Account {
+	if contact.RequesterID == accountID {
+		return &contact.Addressee
+	}
+	return &contact.Requester
+}
+
+type DataExportStatus string
+
+const (
+	DataExportPending DataExportStatus = "pending"
+	DataExportReady   DataExportStatus = "ready"
+	DataExportFailed  DataExportStatus = "failed"
+	DataExportExpired DataExportStatus = "expired" // The archive has been removed
+)
+
+// Archive of the personal data of an account. Only the hash of the download token is stored,
+// the token itself is sent by email once the archive is ready
+type DataExport struct {
+	gorm.Model
+	AccountID uint             `json:"account_id" gorm:"index;not null"`
+	Status    DataExportStatus `json:"status" gorm:"not null"`
+	Path      string           `json:"-" gorm:"not null;default:''"`
+	Size      int64            `json:"size" gorm:"not null;default:0"`
+	TokenHash *string          `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt *time.Time       `json:"expires_at"` // The download link stops working at this time
+}
+
+type ReportReason string
+
+type ReportStatus string
+
+const (
+	ReportSpam       ReportReason = "spam"
+	ReportHarassment ReportReason = "harassment"
+	ReportHate       ReportReason = "hate"
+	ReportViolence   ReportReason = "violence"
+	ReportSexual     ReportReason = "sexual"
+	ReportOther      ReportReason = "other"
+
+	ReportOpen      ReportStatus = "open"
+	ReportResolved  ReportStatus = "resolved"  // An action was taken
+	ReportDismissed ReportStatus = "dismissed" // No action was needed
+)
+
+// Report of an account, or of one of its messages, waiting in the moderation queue. The content
+// of the message is copied, so the report keeps its evidence once the message is deleted
+type Report struct {
+	gorm.Model
+	ReporterID     uint                 `json:"reporter_id" gorm:"index;not null"`
+	Reporter       *Account             `json:"reporter,omitempty" gorm:"foreignKey:ReporterID"`
+	AccountID      uint                 `json:"account_id" gorm:"index;not null"` // Reported account, the sender of the message
+	Account        *Account             `json:"account,omitempty" gorm:"foreignKey:AccountID"`
+	MessageID      *uint                `json:"message_id" gorm:"index"`
+	MessageContent string               `json:"message_content" gorm:"not null;default:''"`
+	Reason         ReportReason         `json:"reason" gorm:"not null"`
+	Details        string               `json:"details" gorm:"not null;default:''"`
+	Status         ReportStatus         `json:"status" gorm:"index;not null"`
+	Action         ModerationActionType `json:"action" gorm:"not null;default:''"` // Action which closed the report
+	ResolvedByID   *uint                `json:"resolved_by_id"`
+	ResolvedAt     *time.Time           `json:"resolved_at"`
+}
+
+type ModerationActionType string
+
+const (
+	ModerationDismiss       ModerationActionType = "dismiss"
+	ModerationDeleteMessage ModerationActionType = "delete_message"
+	ModerationWarn          ModerationActionType = "warn"
+	ModerationMute          ModerationActionType = "mute"
+	ModerationUnmute        ModerationActionType = "unmute"
+	ModerationBan           ModerationActionType = "ban"
+	ModerationUnban         ModerationActionType = "unban"
+)
+
+// Entry of the moderation audit trail, one per action taken on an account or a report. Entries
+// are never updated nor deleted
+type ModerationAction struct {
+	gorm.Model
+	ModeratorID *uint                `json:"moderator_id" gorm:"index"` // Not set for the actions taken from the command line
+	AccountID   uint                 `json:"account_id" gorm:"index;not null"`
+	ReportID    *uint                `json:"report_id" gorm:"index"`
+	MessageID   *uint                `json:"message_id"`
+	Action      ModerationActionType `json:"action" gorm:"not null"`
+	Reason      string               `json:"reason" gorm:"not null;default:''"`
+	ExpiresAt   *time.Time           `json:"expires_at"` // End of the mute
+}
+
+// Task written in the same transaction as the data it's about, then relayed to the task queue,
+// so a task is never lost when the queue is unavailable
+type OutboxEvent struct {
+	gorm.Model
+	TaskType     string     `json:"task_type" gorm:"not null"`
+	Payload      []byte     `json:"payload" gorm:"not null"`
+	ProcessAt    *time.Time `json:"process_at"` // The task is processed at this time, right away if not set
+	DispatchedAt *time.Time `json:"dispatched_at" gorm:"index:idx_outbox_events_pending,where:dispatched_at IS NULL;index:idx_outbox_events_dispatched,where:dispatched_at IS NOT NULL"`
+	Attempts     int        `json:"attempts" gorm:"not null;default:0"`
+	LastError    string     `json:"last_error"`
+}
+
+// Slow mode interval set by a moderator on a conversation, it overrides the interval of the
+// config for its chat type. The conversation is PublicConversation or a PrivateConversation key
+type SlowMode struct {
+	gorm.Model
+	Conversation    string `json:"conversation" gorm:"uniqueIndex;not null"`
+	IntervalSeconds int    `json:"interval_seconds" gorm:"not null"` // 0 disables slow mode
+	SetByID         *uint  `json:"set_by_id"`                        // Moderator who set it
 }
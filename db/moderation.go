@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Filter of the report list, the zero value matches every report
+type ReportFilter struct {
+	Status    ReportStatus
+	AccountID uint // Reported account
+	Limit     int
+	Offset    int
+}
+
+// Filter of the moderation audit trail, the zero value matches every action
+type ModerationActionFilter struct {
+	AccountID   uint
+	ModeratorID uint
+	Limit       int
+	Offset      int
+}
+
+// Report repository backed by gorm, used by both Postgres and SQLite
+type gormReportRepository struct {
+	DB *gorm.DB
+}
+
+func (repo *gormReportRepository) Create(ctx context.Context, report *Report) error {
+	return repo.DB.WithContext(ctx).Create(report).Error
+}
+
+// Get the report along with the reporter and the reported account, deleted accounts included
+func (repo *gormReportRepository) GetByID(ctx context.Context, id uint) (*Report, error) {
+	var report Report
+	err := repo.DB.WithContext(ctx).
+		Preload("Reporter", unscoped).
+		Preload("Account", unscoped).
+		First(&report, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Report whether the reporter already has an open report of the account, or of the message if set
+func (repo *gormReportRepository) HasOpen(ctx context.Context, reporterID, accountID uint, messageID *uint) (bool, error) {
+	query := repo.DB.WithContext(ctx).Model(&Report{}).
+		Where("reporter_id = ? AND account_id = ? AND status = ?", reporterID, accountID, ReportOpen)
+	if messageID != nil {
+		query = query.Where("message_id = ?", *messageID)
+	} else {
+		query = query.Where("message_id IS NULL")
+	}
+
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+// Get the reports matching the filter. Open reports are listed oldest first, so the queue is
+// reviewed in order, the closed ones latest first
+func (repo *gormReportRepository) List(ctx context.Context, filter ReportFilter) ([]Report, error) {
+	query := repo.DB.WithContext(ctx).
+		Preload("Reporter", unscoped).
+		Preload("Account", unscoped)
+	if filter.Status == ReportOpen {
+		query = query.Order("id")
+	} else {
+		query = query.Order("id DESC")
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.AccountID != 0 {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	var reports []Report
+	err := query.Find(&reports).Error
+	return reports, err
+}
+
+// Close the report with the action taken by the moderator, return ErrNotFound if it's not open
+// anymore, such as when another moderator closed it first
+func (repo *gormReportRepository) Resolve(ctx context.Context, report *Report) error {
+	now := time.Now()
+	result := repo.DB.WithContext(ctx).Model(&Report{}).
+		Where("id = ? AND status = ?", report.ID, ReportOpen).
+		Updates(map[string]any{
+			"status":         report.Status,
+			"action":         report.Action,
+			"resolved_by_id": report.ResolvedByID,
+			"resolved_at":    now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	report.ResolvedAt = &now
+	return nil
+}
+
+// Resolve the other open reports of the message, once it's deleted. Return the number of reports
+func (repo *gormReportRepository) ResolveByMessage(ctx context.Context, messageID, moderatorID uint) (int64, error) {
+	return repo.resolveOpen(ctx, "message_id", messageID, ModerationDeleteMessage, moderatorID)
+}
+
+// Resolve the other open reports of the account, once it's banned. Return the number of reports
+func (repo *gormReportRepository) ResolveByAccount(ctx context.Context, accountID, moderatorID uint) (int64, error) {
+	return repo.resolveOpen(ctx, "account_id", accountID, ModerationBan, moderatorID)
+}
+
+// Resolve the open reports whose column matches the value
+func (repo *gormReportRepository) resolveOpen(
+	ctx context.Context,
+	column string,
+	value uint,
+	action ModerationActionType,
+	moderatorID uint,
+) (int64, error) {
+	result := repo.DB.WithContext(ctx).Model(&Report{}).
+		Where(column+" = ? AND status = ?", value, ReportOpen).
+		Updates(map[string]any{
+			"status":         ReportResolved,
+			"action":         action,
+			"resolved_by_id": moderatorID,
+			"resolved_at":    time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// Moderation action repository backed by gorm, used by both Postgres and SQLite
+type gormModerationActionRepository struct {
+	DB *gorm.DB
+}
+
+func (repo *gormModerationActionRepository) Create(ctx context.Context, action *ModerationAction) error {
+	return repo.DB.WithContext(ctx).Create(action).Error
+}
+
+func (repo *gormModerationActionRepository) GetByID(ctx context.Context, id uint) (*ModerationAction, error) {
+	var action ModerationAction
+	if err := repo.DB.WithContext(ctx).First(&action, id).Error; err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// Get the actions matching the filter, latest first
+func (repo *gormModerationActionRepository) List(ctx context.Context, filter ModerationActionFilter) ([]ModerationAction, error) {
+	query := repo.DB.WithContext(ctx).Order("id DESC")
+	if filter.AccountID != 0 {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if filter.ModeratorID != 0 {
+		query = query.Where("moderator_id = ?", filter.ModeratorID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	var actions []ModerationAction
+	err := query.Find(&actions).Error
+	return actions, err
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Default notification preference, used for accounts that never changed their settings
+func DefaultNotificationPreference(accountID uint) NotificationPreference {
+	return NotificationPreference{
+		AccountID:    accountID,
+		EmailEnabled: true,
+		PushEnabled:  true,
+		Timezone:     "UTC",
+	}
+}
+
+// Parse a clock time in HH:MM format, return the number of minutes since midnight
+func ParseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid clock time %q, expect HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Get the location of the account's timezone, fallback to UTC if invalid
+func (pref *NotificationPreference) Location() *time.Location {
+	loc, err := time.LoadLocation(pref.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Get the earliest time from t where a notification can be delivered, that is t itself
+// or the end of the quiet hours if t falls inside them
+func (pref *NotificationPreference) NextDeliveryTime(t time.Time) time.Time {
+	if pref.QuietHoursStart == nil || pref.QuietHoursEnd == nil {
+		return t
+	}
+
+	start, err := ParseClock(*pref.QuietHoursStart)
+	if err != nil {
+		return t
+	}
+	end, err := ParseClock(*pref.QuietHoursEnd)
+	if err != nil || start == end {
+		return t
+	}
+
+	local := t.In(pref.Location())
+	now := local.Hour()*60 + local.Minute()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+
+	// Quiet hours may wrap around midnight, for example 22:00 to 07:00
+	var inQuietHours bool
+	if start < end {
+		inQuietHours = now >= start && now < end
+	} else {
+		inQuietHours = now >= start || now < end
+	}
+	if !inQuietHours {
+		return t
+	}
+
+	deliverAt := midnight.Add(time.Duration(end) * time.Minute)
+	if !deliverAt.After(local) {
+		deliverAt = deliverAt.AddDate(0, 0, 1)
+	}
+	return deliverAt
+}
+
+// Notification preference repository backed by gorm, used by both Postgres and SQLite
+type gormNotificationPreferenceRepository struct {
+	DB *gorm.DB
+}
+
+func (repo *gormNotificationPreferenceRepository) Get(ctx context.Context, accountID uint) (*NotificationPreference, error) {
+	pref := DefaultNotificationPreference(accountID)
+	result := repo.DB.WithContext(ctx).Where("account_id = ?", accountID).Attrs(pref).FirstOrInit(&pref)
+	return &pref, result.Error
+}
+
+// The row is created with the defaults first, since gorm skips false booleans on create
+// and the database default would be used instead
+func (repo *gormNotificationPreferenceRepository) GetOrCreate(ctx context.Context, accountID uint) (*NotificationPreference, error) {
+	pref := DefaultNotificationPreference(accountID)
+	result := repo.DB.WithContext(ctx).Where("account_id = ?", accountID).Attrs(pref).FirstOrCreate(&pref)
+	return &pref, result.Error
+}
+
+func (repo *gormNotificationPreferenceRepository) Save(ctx context.Context, pref *NotificationPreference) error {
+	return repo.DB.WithContext(ctx).Save(pref).Error
+}
+
+func (repo *gormNotificationPreferenceRepository) Delete(ctx context.Context, accountID uint) error {
+	return repo.DB.WithContext(ctx).Unscoped().Where("account_id = ?", accountID).Delete(&NotificationPreference{}).Error
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Outbox repository backed by gorm, used by both Postgres and SQLite
+type gormOutboxRepository struct {
+	DB *gorm.DB
+}
+
+func (repo *gormOutboxRepository) Create(ctx context.Context, event *OutboxEvent) error {
+	return repo.DB.WithContext(ctx).Create(event).Error
+}
+
+// Events are claimed with FOR UPDATE SKIP LOCKED, so several relays can run at the same time
+// on Postgres. SQLite has no row lock (the clause is ignored), but it only has one writer anyway
+func (repo *gormOutboxRepository) Relay(
+	ctx context.Context,
+	limit int,
+	dispatch func(event *OutboxEvent) error,
+) (int, error) {
+	relayed := 0
+
+	err := repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var events []OutboxEvent
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("dispatched_at IS NULL").
+			Order("id").
+			Limit(limit).
+			Find(&events)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		for _, event := range events {
+			if err := dispatch(&event); err != nil {
+				// Record the failure and stop, the queue is likely unavailable for the rest too
+				return tx.Model(&event).Updates(map[string]any{
+					"attempts":   gorm.Expr("attempts + 1"),
+					"last_error": err.Error(),
+				}).Error
+			}
+
+			result := tx.Model(&event).Updates(map[string]any{
+				"dispatched_at": time.Now(),
+				"attempts":      gorm.Expr("attempts + 1"),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			relayed++
+		}
+
+		return nil
+	})
+
+	return relayed, err
+}
+
+func (repo *gormOutboxRepository) CountPending(ctx context.Context) (int64, error) {
+	var count int64
+	err := repo.DB.WithContext(ctx).Model(&OutboxEvent{}).Where("dispatched_at IS NULL").Count(&count).Error
+	return count, err
+}
+
+func (repo *gormOutboxRepository) DeleteDispatched(ctx context.Context, before time.Time, limit int) (int64, error) {
+	// SQLite compares the times as text, the time is bound in the local time zone like gorm stores them
+	oldest := repo.DB.Model(&OutboxEvent{}).
+		Select("id").
+		Where("dispatched_at IS NOT NULL AND dispatched_at < ?", before.Local()).
+		Order("id").
+		Limit(limit)
+
+	result := repo.DB.WithContext(ctx).Unscoped().Where("id IN (?)", oldest).Delete(&OutboxEvent{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	ctx := context.Background()
+	queries := newTestQueries(t)
+
+	var events []*OutboxEvent
+	for range 5 {
+		event := &OutboxEvent{TaskType: "test", Payload: []byte("{}")}
+		if err := queries.Outbox.Create(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+
+	relay := func(limit int, fail uint) ([]uint, int) {
+		t.Helper()
+		var claimed []uint
+		relayed, err := queries.Outbox.Relay(ctx, limit, func(event *OutboxEvent) error {
+			claimed = append(claimed, event.ID)
+			if event.ID == fail {
+				return errors.New("queue unavailable")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claimed, relayed
+	}
+	pending := func() int64 {
+		t.Helper()
+		count, err := queries.Outbox.CountPending(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// Events are claimed in order, up to the limit
+	claimed, relayed := relay(2, 0)
+	if want := []uint{events[0].ID, events[1].ID}; !slices.Equal(claimed, want) || relayed != 2 {
+		t.Fatalf("claimed %v and relayed %d, want %v and 2", claimed, relayed, want)
+	}
+	if count := pending(); count != 3 {
+		t.Fatalf("got %d pending events, want 3", count)
+	}
+
+	// The batch stops at the first failure, which is recorded on the event
+	claimed, relayed = relay(10, events[3].ID)
+	if want := []uint{events[2].ID, events[3].ID}; !slices.Equal(claimed, want) || relayed != 1 {
+		t.Fatalf("claimed %v and relayed %d, want %v and 1", claimed, relayed, want)
+	}
+	var failed OutboxEvent
+	if err := queries.DB.First(&failed, events[3].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if failed.DispatchedAt != nil || failed.Attempts != 1 || failed.LastError != "queue unavailable" {
+		t.Fatalf("unexpected failed event %+v", failed)
+	}
+
+	// The failed event is claimed again, the dispatched ones are not
+	claimed, relayed = relay(10, 0)
+	if want := []uint{events[3].ID, events[4].ID}; !slices.Equal(claimed, want) || relayed != 2 {
+		t.Fatalf("claimed %v and relayed %d, want %v and 2", claimed, relayed, want)
+	}
+	if count := pending(); count != 0 {
+		t.Fatalf("got %d pending events, want 0", count)
+	}
+	if err := queries.DB.First(&failed, events[3].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if failed.DispatchedAt == nil || failed.Attempts != 2 {
+		t.Fatalf("unexpected dispatched event %+v", failed)
+	}
+}
+
+func TestOutboxDeleteDispatched(t *testing.T) {
+	ctx := context.Background()
+	queries := newTestQueries(t)
+
+	var events []*OutboxEvent
+	for range 4 {
+		event := &OutboxEvent{TaskType: "test", Payload: []byte("{}")}
+		if err := queries.Outbox.Create(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if _, err := queries.Outbox.Relay(ctx, 3, func(event *OutboxEvent) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two events were dispatched long ago, one recently and the last one is still pending
+	old := time.Now().Add(-30 * 24 * time.Hour).UTC()
+	if err := queries.DB.Model(&OutboxEvent{}).Where("id IN ?", []uint{events[0].ID, events[1].ID}).
+		Update("dispatched_at", old).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(-7 * 24 * time.Hour)
+	for _, want := range []int64{1, 1, 0} {
+		deleted, err := queries.Outbox.DeleteDispatched(ctx, before, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != want {
+			t.Fatalf("deleted %d events, want %d", deleted, want)
+		}
+	}
+
+	var left []uint
+	if err := queries.DB.Unscoped().Model(&OutboxEvent{}).Order("id").Pluck("id", &left).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint{events[2].ID, events[3].ID}; !slices.Equal(left, want) {
+		t.Fatalf("got events %v left, want %v", left, want)
+	}
+}
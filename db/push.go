@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Push subscription repository backed by gorm, used by both Postgres and SQLite
+type gormPushSubscriptionRepository struct {
+	DB *gorm.DB
+}
+
+// Create the subscription, or take over the existing one with the same endpoint. The same browser
+// may subscribe again (new keys) or another account may log in on it, so the endpoint always
+// belong to the latest subscriber
+func (repo *gormPushSubscriptionRepository) Upsert(ctx context.Context, subscription *PushSubscription) error {
+	return repo.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "endpoint"}},
+		DoUpdates: clause.AssignmentColumns([]string{"account_id", "p256dh", "auth", "user_agent", "updated_at"}),
+	}).Create(subscription).Error
+}
+
+func (repo *gormPushSubscriptionRepository) GetByID(ctx context.Context, id uint) (*PushSubscription, error) {
+	var subscription PushSubscription
+	if err := repo.DB.WithContext(ctx).First(&subscription, id).Error; err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (repo *gormPushSubscriptionRepository) ListByAccount(ctx context.Context, accountID uint) ([]PushSubscription, error) {
+	var subscriptions []PushSubscription
+	err := repo.DB.WithContext(ctx).Where("account_id = ?", accountID).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func (repo *gormPushSubscriptionRepository) Delete(ctx context.Context, id uint) error {
+	return repo.DB.WithContext(ctx).Unscoped().Delete(&PushSubscription{}, id).Error
+}
+
+// Delete the subscription if it belongs to the account, return false if nothing was deleted
+func (repo *gormPushSubscriptionRepository) DeleteForAccount(ctx context.Context, id, accountID uint) (bool, error) {
+	result := repo.DB.WithContext(ctx).Unscoped().
+		Where("id = ? AND account_id = ?", id, accountID).
+		Delete(&PushSubscription{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (repo *gormPushSubscriptionRepository) DeleteByAccount(ctx context.Context, accountID uint) error {
+	return repo.DB.WithContext(ctx).Unscoped().Where("account_id = ?", accountID).Delete(&PushSubscription{}).Error
+}
+
+func (repo *gormPushSubscriptionRepository) DeleteAll(ctx context.Context) (int64, error) {
+	result := repo.DB.WithContext(ctx).Unscoped().Where("1 = 1").Delete(&PushSubscription{})
+	return result.RowsAffected, result.Error
+}
+
+func (repo *gormPushSubscriptionRepository) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := repo.DB.WithContext(ctx).Model(&PushSubscription{}).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Account repository interface
+type AccountRepository interface {
+	GetByID(ctx context.Context, id uint) (*Account, error)
+	ListByIDs(ctx context.Context, ids []uint) ([]Account, error)
+	GetByOAuth(ctx context.Context, provider OauthProvider, providerID string) (*Account, error)
+	Create(ctx context.Context, account *Account) error
+	List(ctx context.Context, filter AccountFilter) ([]Account, error)
+	// The methods below return ErrNotFound if the account does not exist. Banning and revoking
+	// the tokens bump the token version, so the tokens issued before stop working
+	Ban(ctx context.Context, id uint, reason string) error
+	Unban(ctx context.Context, id uint) error
+	RevokeTokens(ctx context.Context, id uint) error
+	SetRole(ctx context.Context, id uint, role Role) error
+	SetDMPrivacy(ctx context.Context, id uint, privacy DMPrivacy) error
+	SetMutedUntil(ctx context.Context, id uint, until *time.Time) error
+	Search(ctx context.Context, params DirectorySearchParams) ([]Account, error)
+	UpdateProfile(ctx context.Context, account *Account) error
+	SetAvatar(ctx context.Context, id uint, path string) error
+	ScheduleDeletion(ctx context.Context, id uint, at *time.Time) error
+	// Get the IDs of the accounts whose deletion is scheduled at or before the given time
+	ListDueDeletions(ctx context.Context, before time.Time, limit int) ([]uint, error)
+	// Anonymize and soft delete the account, its tokens are revoked
+	Erase(ctx context.Context, id uint) error
+}
+
+// Message repository interface. Conversations are not stored on their own, a conversation is
+// either the public chat or the private messages between two accounts
+type MessageRepository interface {
+	Create(ctx context.Context, message *Message) error
+	GetByID(ctx context.Context, id uint) (*Message, error)
+	GetByClientID(ctx context.Context, senderID uint, clientMessageID string) (*Message, error)
+	MarkConversationRead(ctx context.Context, senderID, receiverID uint) (int64, error)
+	ListUnread(ctx context.Context, receiverID uint, since *time.Time) ([]Message, error)
+	Search(ctx context.Context, params MessageSearchParams) ([]MessageSearchResult, error)
+	ListByAccount(ctx context.Context, accountID, afterID uint, limit int) ([]Message, error)
+	Delete(ctx context.Context, id uint) error
+	DeleteBySender(ctx context.Context, senderID uint) ([]Attachment, error)
+}
+
+// Attachment repository interface
+type AttachmentRepository interface {
+	Create(ctx context.Context, attachment *Attachment) error
+	GetByID(ctx context.Context, id uint) (*Attachment, error)
+	UpdatePath(ctx context.Context, attachment *Attachment) error
+	AttachToMessage(ctx context.Context, message *Message, attachmentIDs []uint) error
+	MarkFailed(ctx context.Context, id uint) error
+	SaveProcessed(ctx context.Context, attachment *Attachment, thumbnails []AttachmentThumbnail) error
+	ListByUploader(ctx context.Context, uploaderID uint) ([]Attachment, error)
+	DeleteUnsent(ctx context.Context, uploaderID uint) ([]Attachment, error)
+}
+
+// Notification preference repository interface
+type NotificationPreferenceRepository interface {
+	// Get the preference of the account, or the default one if the account never set it
+	Get(ctx context.Context, accountID uint) (*NotificationPreference, error)
+	// Same as Get, but the default preference is stored if it does not exist yet
+	GetOrCreate(ctx context.Context, accountID uint) (*NotificationPreference, error)
+	Save(ctx context.Context, pref *NotificationPreference) error
+	Delete(ctx context.Context, accountID uint) error
+}
+
+// Push subscription repository interface
+type PushSubscriptionRepository interface {
+	Upsert(ctx context.Context, subscription *PushSubscription) error
+	GetByID(ctx context.Context, id uint) (*PushSubscription, error)
+	ListByAccount(ctx context.Context, accountID uint) ([]PushSubscription, error)
+	Delete(ctx context.Context, id uint) error
+	DeleteForAccount(ctx context.Context, id, accountID uint) (bool, error)
+	DeleteByAccount(ctx context.Context, accountID uint) error
+	// Delete every subscription, return the number of deleted subscriptions
+	DeleteAll(ctx context.Context) (int64, error)
+	Count(ctx context.Context) (int64, error)
+}
+
+// Block repository interface. Blocks are hard deleted, so the raw queries filtering on them
+// don't need to check deleted_at
+type BlockRepository interface {
+	Create(ctx context.Context, blockerID, blockedID uint) error
+	Delete(ctx context.Context, blockerID, blockedID uint) (bool, error)
+	ListByBlocker(ctx context.Context, blockerID uint) ([]Block, error)
+	Between(ctx context.Context, accountID, otherID uint) (bool, error)
+	// IDs of the accounts blocked by the blocker
+	ListBlockedIDs(ctx context.Context, blockerID uint) ([]uint, error)
+	// IDs of the accounts which blocked the account
+	ListBlockerIDs(ctx context.Context, blockedID uint) ([]uint, error)
+	DeleteByAccount(ctx context.Context, accountID uint) error
+}
+
+// Mute repository interface
+type MuteRepository interface {
+	Create(ctx context.Context, muterID, mutedID uint) error
+	Delete(ctx context.Context, muterID, mutedID uint) (bool, error)
+	ListByMuter(ctx context.Context, muterID uint) ([]Mute, error)
+	Exists(ctx context.Context, muterID, mutedID uint) (bool, error)
+	DeleteByAccount(ctx context.Context, accountID uint) error
+}
+
+// Contact repository interface. A contact is either a pending request or an accepted one, and
+// there is at most one of them between two accounts
+type ContactRepository interface {
+	Create(ctx context.Context, contact *Contact) error
+	GetByID(ctx context.Context, id uint) (*Contact, error)
+	Between(ctx context.Context, accountID, otherID uint) (*Contact, error)
+	AreContacts(ctx context.Context, accountID, otherID uint) (bool, error)
+	Accept(ctx context.Context, contact *Contact) error
+	Delete(ctx context.Context, id uint) error
+	DeleteBetween(ctx context.Context, accountID, otherID uint) (bool, error)
+	ListContacts(ctx context.Context, accountID uint) ([]Contact, error)
+	// IDs of the contacts of the account
+	ListContactIDs(ctx context.Context, accountID uint) ([]uint, error)
+	ListIncoming(ctx context.Context, addresseeID uint) ([]Contact, error)
+	ListOutgoing(ctx context.Context, requesterID uint) ([]Contact, error)
+	DeleteByAccount(ctx context.Context, accountID uint) error
+}
+
+// Data export repository interface
+type DataExportRepository interface {
+	Create(ctx context.Context, export *DataExport) error
+	GetByID(ctx context.Context, id uint) (*DataExport, error)
+	GetByTokenHash(ctx context.Context, tokenHash string) (*DataExport, error)
+	ListByAccount(ctx context.Context, accountID uint) ([]DataExport, error)
+	// Report whether an export of the account is still being built
+	HasPending(ctx context.Context, accountID uint) (bool, error)
+	Save(ctx context.Context, export *DataExport) error
+	DeleteByAccount(ctx context.Context, accountID uint) error
+}
+
+// Report repository interface
+type ReportRepository interface {
+	Create(ctx context.Context, report *Report) error
+	GetByID(ctx context.Context, id uint) (*Report, error)
+	HasOpen(ctx context.Context, reporterID, accountID uint, messageID *uint) (bool, error)
+	List(ctx context.Context, filter ReportFilter) ([]Report, error)
+	Resolve(ctx context.Context, report *Report) error
+	ResolveByMessage(ctx context.Context, messageID, moderatorID uint) (int64, error)
+	ResolveByAccount(ctx context.Context, accountID, moderatorID uint) (int64, error)
+}
+
+// Moderation action repository interface, the audit trail is append only
+type ModerationActionRepository interface {
+	Create(ctx context.Context, action *ModerationAction) error
+	GetByID(ctx context.Context, id uint) (*ModerationAction, error)
+	List(ctx context.Context, filter ModerationActionFilter) ([]ModerationAction, error)
+}
+
+// Outbox repository interface
+type OutboxRepository interface {
+	Create(ctx context.Context, event *OutboxEvent) error
+	// Claim up to limit pending events and call dispatch for each of them, in order. Events that
+	// dispatch successfully are marked as dispatched; the batch stops at the first failure.
+	// Return the number of dispatched events
+	Relay(ctx context.Context, limit int, dispatch func(event *OutboxEvent) error) (int, error)
+	// Count the events not dispatched yet
+	CountPending(ctx context.Context) (int64, error)
+	// Delete up to limit events dispatched before the given time, return the number deleted
+	DeleteDispatched(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// Slow mode repository interface, the slow modes set by the moderators by conversation
+type SlowModeRepository interface {
+	Get(ctx context.Context, conversation string) (*SlowMode, error)
+	Set(ctx context.Context, slowMode *SlowMode) error
+	Delete(ctx context.Context, conversation string) (bool, error)
+}
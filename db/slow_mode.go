@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Conversation key of the public chat
+const PublicConversation = "public"
+
+// Conversation key of the private messages between two accounts, the same for both of them
+func PrivateConversation(accountID, otherID uint) string {
+	return fmt.Sprintf("private:%d:%d", min(accountID, otherID), max(accountID, otherID))
+}
+
+// Slow mode repository backed by gorm, used by both Postgres and SQLite
+type gormSlowModeRepository struct {
+	DB *gorm.DB
+}
+
+func (repo *gormSlowModeRepository) Get(ctx context.Context, conversation string) (*SlowMode, error) {
+	var slowMode SlowMode
+	if err := repo.DB.WithContext(ctx).Where("conversation = ?", conversation).First(&slowMode).Error; err != nil {
+		return nil, err
+	}
+	return &slowMode, nil
+}
+
+// Set the slow mode of the conversation, replacing the one set before
+func (repo *gormSlowModeRepository) Set(ctx context.Context, slowMode *SlowMode) error {
+	return repo.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "conversation"}},
+		DoUpdates: clause.AssignmentColumns([]string{"interval_seconds", "set_by_id", "updated_at"}),
+	}).Create(slowMode).Error
+}
+
+// Remove the slow mode of the conversation, return false if none was set
+func (repo *gormSlowModeRepository) Delete(ctx context.Context, conversation string) (bool, error) {
+	result := repo.DB.WithContext(ctx).Unscoped().Where("conversation = ?", conversation).Delete(&SlowMode{})
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/danglnh07/zola/service/webpush"
+	"github.com/danglnh07/zola/util"
+)
+
+const keysUsage = `Usage: zola keys rotate <key>
+
+Keys:
+  vapid   Generate a new VAPID key pair for Web Push in the storage directory. The push
+          subscriptions are deleted since they're bound to the previous key, the clients
+          subscribe again with the new one. Restart the servers afterward
+  secret  Print a new secret_key to put in the config. Every token is revoked once the
+          servers are restarted with it`
+
+// Size of the generated secret keys, in bytes before encoding
+const secretKeySize = 48
+
+// Run the keys subcommand
+func RunKeys(args []string, config *util.Config, logger *slog.Logger) error {
+	if len(args) != 2 || args[0] != "rotate" {
+		return usageError(keysUsage)
+	}
+
+	switch args[1] {
+	case "vapid":
+		return rotateVAPIDKeys(config, logger)
+	case "secret":
+		secret := make([]byte, secretKeySize)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		fmt.Println(base64.RawURLEncoding.EncodeToString(secret))
+		return nil
+	default:
+		return usageError(keysUsage)
+	}
+}
+
+// Replace the VAPID keys stored in the storage directory, and delete the push subscriptions
+// made with the previous keys
+func rotateVAPIDKeys(config *util.Config, logger *slog.Logger) error {
+	// The keys of the config take precedence over the stored ones
+	if config.VAPIDPrivateKey != "" {
+		return errors.New("the VAPID keys are set by vapid_private_key, replace it in the config instead")
+	}
+
+	queries, _, err := connectDatabase(config, logger)
+	if err != nil {
+		return err
+	}
+	defer queries.Close()
+
+	keys, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		return err
+	}
+	if err := keys.Save(vapidKeysPath(config)); err != nil {
+		return fmt.Errorf("failed to save VAPID keys: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	deleted, err := queries.PushSubscriptions.DeleteAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete push subscriptions: %w", err)
+	}
+
+	logger.Info("VAPID keys rotated, restart the servers to use them",
+		"public_key", keys.PublicKey, "deleted_subscriptions", deleted)
+	return nil
+}
@@ -1,70 +1,87 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 
-	"github.com/danglnh07/zola/api"
-	"github.com/danglnh07/zola/db"
-	"github.com/danglnh07/zola/service/pubsub"
-	"github.com/danglnh07/zola/service/worker"
+	"github.com/danglnh07/zola/service/logging"
 	"github.com/danglnh07/zola/util"
-	"github.com/hibiken/asynq"
 )
 
-func main() {
-	// Initialize logger
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+const usage = `Usage: zola [config flags] [command]
+
+Commands:
+  serve      Run the API server, the default command. Run a single replica, the WebSocket
+             clients must be connected to the process delivering their messages
+  worker     Process the background tasks apart from the API server, with the redis task
+             backend. Any number of workers can run next to the serve process
+  migrate    Apply or roll back the database migrations
+  user       List and manage the accounts
+  keys       Rotate the keys of the server
+  broadcast  Send an announcement to every connected client
+  config     Print the effective config
+
+Run a command without arguments to get its usage`
 
-	// Load config from .env
-	config := util.LoadConfig(".env")
+// Error returned by the commands called with invalid arguments, it holds the usage of the command
+type usageError string
+
+func (err usageError) Error() string {
+	return string(err)
+}
 
-	// Connect to database
-	queries, err := db.NewQueries(config)
+func main() {
+	// Load config from defaults, config file, env and flags
+	config, args, err := util.LoadConfig(os.Args[1:])
 	if err != nil {
-		logger.Error("Failed to connect to database", "error", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Invalid config:\n%v\n", err)
+		os.Exit(2)
 	}
 
-	// Run auto migration
-	if err = queries.AutoMigration(); err != nil {
-		logger.Error("Failed to run auto migration", "error", err)
-		os.Exit(1)
+	// Initialize logger, its level is set from the config and can be reloaded
+	logLevel := new(slog.LevelVar)
+	logLevel.UnmarshalText([]byte(config.LogLevel))
+	logger := logging.NewLogger(os.Stdout, config.LogFormat, logLevel)
+	slog.SetDefault(logger)
+	for _, warning := range config.Warnings() {
+		logger.Warn("Config value corrected", "warning", warning)
 	}
 
-	// Create the hub
-	hub := pubsub.NewHub()
-	logger.Info("", "Main hub", fmt.Sprintf("%p", hub))
-
-	// Connect to Redis
-	redisOpt := asynq.RedisClientOpt{
-		Addr: config.RedisAddr,
+	// Run the server if no command is given
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
 	}
-	distributor := worker.NewRedisTaskDistributor(redisOpt, logger)
 
-	// Run the task processor in goroutine (since the asynq.Start will block the main thread)
-	go StartBackgroundProcessor(redisOpt, queries, hub, logger)
-
-	// Create and start server
-	server := api.NewServer(queries, config, hub, distributor, logger)
-	if err = server.Start(); err != nil {
-		logger.Error("Failed to run the server or server shutdown unexpectedly", "error", err)
+	switch command {
+	case "serve":
+		err = RunServe(config, logger, logLevel)
+	case "worker":
+		err = RunWorker(config, logger, logLevel)
+	case "migrate":
+		err = RunMigrate(args, config, logger)
+	case "user":
+		err = RunUser(args, config, logger)
+	case "keys":
+		err = RunKeys(args, config, logger)
+	case "broadcast":
+		err = RunBroadcast(args, config, logger)
+	case "config":
+		// Print the effective config
+		fmt.Print(config.Dump())
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s\n", command, usage)
+		os.Exit(2)
+	}
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(os.Stderr, usageErr)
+		os.Exit(2)
+	}
+	if err != nil {
+		logger.Error("Command failed", "command", command, "error", err)
 		os.Exit(1)
 	}
 }
-
-func StartBackgroundProcessor(
-	redisOpts asynq.RedisClientOpt,
-	queries *db.Queries,
-	hub *pubsub.Hub,
-	logger *slog.Logger,
-) error {
-	logger.Info("", "Start background process hub", fmt.Sprintf("%p", hub))
-
-	// Create the processor
-	processor := worker.NewRedisTaskProcessor(redisOpts, queries, hub, logger)
-
-	// Start process tasks
-	return processor.Start()
-}
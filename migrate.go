@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/util"
+)
+
+const migrateUsage = `Usage: zola migrate <command>
+
+Commands:
+  up             Apply all pending migrations
+  down [n|all]   Roll back the last n applied migrations, 1 by default
+  status         Show the applied and pending migrations
+  create <name>  Create empty up and down migrations for every dialect in db/migrations`
+
+// Run the migrate subcommand
+func RunMigrate(args []string, config *util.Config, logger *slog.Logger) error {
+	if len(args) == 0 {
+		return usageError(migrateUsage)
+	}
+
+	// Creating a migration only writes files into the source tree
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return usageError(migrateUsage)
+		}
+		paths, err := db.CreateMigration(filepath.Join("db", db.MigrationsDir), args[1])
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fmt.Println("Created", path)
+		}
+		return nil
+	}
+
+	queries, err := db.NewQueries(config, logger)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	migrator, err := db.NewMigrator(queries)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			logger.Info("Applied migration", "version", migration.Version, "name", migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			logger.Info("Database schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if args[1] == "all" {
+				steps = -1
+			} else if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return usageError(migrateUsage)
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, migration := range rolledBack {
+			logger.Info("Rolled back migration", "version", migration.Version, "name", migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tSTATUS")
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied at " + status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Missing {
+				state += " (unknown to this binary)"
+			}
+			fmt.Fprintf(writer, "%04d\t%s\t%s\n", status.Version, status.Name, state)
+		}
+		return writer.Flush()
+	default:
+		return usageError(migrateUsage)
+	}
+}
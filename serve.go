@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/danglnh07/zola/api"
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/health"
+	"github.com/danglnh07/zola/service/mail"
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/ratelimit"
+	"github.com/danglnh07/zola/service/tracing"
+	"github.com/danglnh07/zola/service/webpush"
+	"github.com/danglnh07/zola/service/worker"
+	"github.com/danglnh07/zola/util"
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Run the serve command: the API server, the WebSocket hub, the outbox relay and the task
+// processor. It blocks until a shutdown signal is received
+func RunServe(config *util.Config, logger *slog.Logger, logLevel *slog.LevelVar) error {
+	logger.LogAttrs(context.Background(), slog.LevelInfo, "Effective config", config.Redacted()...)
+
+	runtimeStore := newRuntimeStore(config, logger, logLevel)
+
+	// Set up tracing before anything creates spans
+	shutdownTracing, err := tracing.Setup(context.Background(), config)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
+	queries, migrator, err := connectDatabase(config, logger)
+	if err != nil {
+		return err
+	}
+
+	// Load the VAPID keys for Web Push
+	pusher, err := newPusher(config)
+	if err != nil {
+		return err
+	}
+
+	// Create the hub
+	hub := pubsub.NewHub()
+
+	// Register the metrics computed on scrape
+	if err = registerMetrics(queries, hub, logger); err != nil {
+		return fmt.Errorf("failed to register metrics: %w", err)
+	}
+
+	// The clients are connected to this process, so it always processes the realtime queue. The
+	// default queue is left to the worker processes if the embedded worker is disabled.
+	// The realtime tasks are delivered through the hub of the process taking them, so only a
+	// single serve replica is supported: with several, a task taken by another replica finds
+	// the receiver offline and notifies them by push or email instead. Scale out with workers
+	queues := map[string]int{worker.QueueRealtime: 1}
+	if config.EmbeddedWorker {
+		queues = map[string]int{worker.QueueRealtime: 6, worker.QueueDefault: 3}
+	}
+	backend, err := newTaskBackend(config, queues, queries, hub, pusher, runtimeStore, logger)
+	if err != nil {
+		return err
+	}
+	metrics.RegisterQueueStats(backend.queueStats, logger)
+
+	// Create the rate limit store of the configured backend
+	var rateLimits ratelimit.Store
+	var redisClient *redis.Client
+	switch config.RateLimitBackend {
+	case util.RateLimitBackendRedis:
+		redisClient = redis.NewClient(&redis.Options{Addr: config.RedisAddr})
+		rateLimits = ratelimit.NewRedisStore(redisClient)
+	default:
+		rateLimits = ratelimit.NewMemoryStore(config.RateLimitCacheSize)
+	}
+
+	// Dependencies checked by the readiness probe
+	checker := health.NewChecker()
+	checker.Register("database", func(ctx context.Context) error {
+		sqlDB, err := queries.DB.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	})
+	checker.Register("migrations", migrator.Check)
+	checker.Register("task_processor", func(ctx context.Context) error {
+		return backend.processor.Ping()
+	})
+	if redisClient != nil {
+		checker.Register("redis", func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		})
+	}
+
+	// Cancelled on SIGINT or SIGTERM, which starts the graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Reload the runtime config on SIGHUP or when the config files change
+	go runtimeStore.Watch(ctx)
+
+	// Start the task processor, it runs in background
+	if err = backend.processor.Start(); err != nil {
+		return fmt.Errorf("failed to start the task processor: %w", err)
+	}
+
+	// Relay the outbox events into the task queue
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	var relayDone sync.WaitGroup
+	relayDone.Add(1)
+	go func() {
+		defer relayDone.Done()
+		worker.NewOutboxRelay(queries, backend.distributor, logger).Run(relayCtx)
+	}()
+
+	// Create and start server
+	server := api.NewServer(queries, runtimeStore, hub, backend.distributor, pusher, rateLimits, checker, backend.queueStats, logger)
+	serverErr := make(chan error, 2)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	// Start the admin server, on its own listener
+	var adminServer *api.AdminServer
+	if config.AdminListenAddr != "" {
+		adminServer = api.NewAdminServer(config, logger)
+		go func() {
+			serverErr <- adminServer.Start()
+		}()
+	}
+
+	// Wait for a signal, or for the server to fail
+	var errs []error
+	select {
+	case <-ctx.Done():
+		logger.Info("Shutdown signal received, shutting down")
+	case err = <-serverErr:
+		errs = append(errs, fmt.Errorf("server shut down unexpectedly: %w", err))
+	}
+	stop()
+
+	// Fail the readiness probe first, and give the load balancer some time to stop routing
+	// new traffic here before closing the listener
+	checker.SetDraining()
+	if config.ShutdownDelay > 0 {
+		logger.Info("Draining before shutdown", "delay", config.ShutdownDelay)
+		time.Sleep(config.ShutdownDelay)
+	}
+
+	// Shut down in the reverse order of the data flow: stop taking requests, stop relaying,
+	// drain the tasks while clients are still connected so they get their messages, then
+	// disconnect the clients and close the database
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shut down the server gracefully: %w", err))
+	}
+	if adminServer != nil {
+		if err = adminServer.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down the admin server gracefully: %w", err))
+		}
+	}
+	cancel()
+
+	stopRelay()
+	relayDone.Wait()
+
+	backend.processor.Shutdown()
+
+	hub.Shutdown(config.MaxReconnectDelay)
+
+	if redisClient != nil {
+		redisClient.Close()
+	}
+	backend.Close()
+
+	if err = queries.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close the database: %w", err))
+	}
+
+	// Flush the spans of the shutdown too
+	tracingCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	if err = shutdownTracing(tracingCtx); err != nil {
+		logger.Error("Failed to flush the traces", "error", err)
+	}
+	cancel()
+
+	logger.Info("Shutdown complete")
+	return errors.Join(errs...)
+}
+
+// Create the store holding the config which can be reloaded at runtime, the log level follows it
+func newRuntimeStore(config *util.Config, logger *slog.Logger, logLevel *slog.LevelVar) *util.RuntimeStore {
+	runtimeStore := util.NewRuntimeStore(config, func() (*util.Config, error) {
+		config, _, err := util.LoadConfig(os.Args[1:])
+		return config, err
+	}, logger)
+	runtimeStore.Subscribe(func(config *util.Config) {
+		logLevel.UnmarshalText([]byte(config.LogLevel))
+	})
+	return runtimeStore
+}
+
+// Connect to the database, and refuse to run against an outdated schema. Migrations are
+// applied with `zola migrate up`
+func connectDatabase(config *util.Config, logger *slog.Logger) (*db.Queries, *db.Migrator, error) {
+	queries, err := db.NewQueries(config, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	migrator, err := db.NewMigrator(queries)
+	if err != nil {
+		queries.Close()
+		return nil, nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	if err = migrator.Check(context.Background()); err != nil {
+		queries.Close()
+		return nil, nil, fmt.Errorf("database schema is not up to date, run the migrate up command first: %w", err)
+	}
+
+	return queries, migrator, nil
+}
+
+// Create the Web Push client, with the VAPID keys of the config or of the storage directory
+func newPusher(config *util.Config) (*webpush.Client, error) {
+	vapidKeys, err := webpush.LoadVAPIDKeys(config.VAPIDPrivateKey, vapidKeysPath(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load VAPID keys: %w", err)
+	}
+	return webpush.NewClient(vapidKeys, "mailto:"+config.Email), nil
+}
+
+// Path of the VAPID keys generated by the server, used if they're not set in the config
+func vapidKeysPath(config *util.Config) string {
+	return filepath.Join(config.StorageDir, "vapid.json")
+}
+
+// Task distributor and processor of the configured backend
+type taskBackend struct {
+	distributor worker.TaskDistributor
+	processor   worker.TaskProcessor
+	inspector   *asynq.Inspector
+	queueStats  func() ([]metrics.QueueStat, error)
+}
+
+// Create the task distributor and processor of the configured backend. The Redis processor only
+// processes the given queues, the in-memory one processes every task since it can't be shared
+func newTaskBackend(
+	config *util.Config,
+	queues map[string]int,
+	queries *db.Queries,
+	hub *pubsub.Hub,
+	pusher *webpush.Client,
+	runtimeStore *util.RuntimeStore,
+	logger *slog.Logger,
+) (*taskBackend, error) {
+	backend := &taskBackend{}
+	mailer := mail.NewSMTPMailer(config)
+	switch config.TaskBackend {
+	case util.TaskBackendRedis:
+		redisOpt := asynq.RedisClientOpt{
+			Addr: config.RedisAddr,
+		}
+		backend.distributor = worker.NewRedisTaskDistributor(redisOpt, logger)
+		backend.processor = worker.NewRedisTaskProcessor(
+			redisOpt, queues, queries, hub, backend.distributor, mailer, pusher, runtimeStore, logger,
+		)
+		backend.inspector = asynq.NewInspector(redisOpt)
+		backend.queueStats = worker.RedisQueueStats(backend.inspector)
+	case util.TaskBackendMemory:
+		broker := worker.NewInMemoryBroker(config.MemoryQueueSize)
+		backend.distributor = worker.NewInMemoryTaskDistributor(broker, logger)
+		backend.processor = worker.NewInMemoryTaskProcessor(
+			broker, config.WorkerConcurrency, queries, hub, backend.distributor, mailer, pusher, runtimeStore, logger,
+		)
+		backend.queueStats = broker.QueueStats
+	default:
+		return nil, fmt.Errorf("unknown task backend %q", config.TaskBackend)
+	}
+	return backend, nil
+}
+
+// Method to release the connections of the backend, once the processor is shut down
+func (backend *taskBackend) Close() {
+	if backend.inspector != nil {
+		backend.inspector.Close()
+	}
+}
+
+// Register the metrics owned by the hub and the database, they're computed on every scrape
+func registerMetrics(queries *db.Queries, hub *pubsub.Hub, logger *slog.Logger) error {
+	sqlDB, err := queries.DB.DB()
+	if err != nil {
+		return err
+	}
+	metrics.RegisterDBStats(sqlDB, queries.Dialect)
+
+	metrics.RegisterGaugeFunc("websocket_clients", "Number of open WebSocket connections, an account has one per tab or device.", func() float64 {
+		return float64(hub.Count())
+	})
+
+	// Count a table with a timeout, so a slow database doesn't block the scrape
+	count := func(name string, fn func(ctx context.Context) (int64, error)) func() float64 {
+		return func() float64 {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			count, err := fn(ctx)
+			if err != nil {
+				logger.Error("Failed to collect metric", "metric", name, "error", err)
+			}
+			return float64(count)
+		}
+	}
+	metrics.RegisterGaugeFunc("push_devices", "Number of devices subscribed to Web Push.",
+		count("push_devices", queries.PushSubscriptions.Count))
+	metrics.RegisterGaugeFunc("outbox_pending_events", "Number of outbox events not dispatched to the task queue yet.",
+		count("outbox_pending_events", queries.Outbox.CountPending))
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/danglnh07/zola/api"
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/logging"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/danglnh07/zola/util"
+	"github.com/gorilla/websocket"
+)
+
+// Get a free local address for a listener
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// Poll the URL until it answers 200, or fail the test
+func waitReady(t *testing.T, url string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		res, err := http.Get(url)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%s is not ready", url)
+}
+
+// Get the body of the admin /metrics endpoint
+func scrape(t *testing.T, adminAddr string) string {
+	t.Helper()
+
+	res, err := http.Get("http://" + adminAddr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics: status %d", res.StatusCode)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// Run the serve command with the in-memory backends, send a message through the API to a
+// WebSocket client, check the documented metrics are exposed on the admin listener, and that
+// the clients are disconnected on shutdown
+func TestServeMetrics(t *testing.T) {
+	dir := t.TempDir()
+	listenAddr, adminAddr := freeAddr(t), freeAddr(t)
+	t.Setenv("DB_CONN", "sqlite:"+filepath.Join(dir, "zola.db"))
+	t.Setenv("SECRET_KEY", strings.Repeat("s", 40))
+	t.Setenv("TASK_BACKEND", util.TaskBackendMemory)
+	t.Setenv("STORAGE_DIR", filepath.Join(dir, "storage"))
+	t.Setenv("LISTEN_ADDR", listenAddr)
+	t.Setenv("ADMIN_LISTEN_ADDR", adminAddr)
+	t.Setenv("BASE_URL", "http://"+listenAddr)
+
+	config, _, err := util.LoadConfig([]string{"-env-file", filepath.Join(dir, ".env")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logging.NewLogger(io.Discard, "text", nil)
+
+	// Migrate the database and create the account, before the server checks the schema
+	queries, err := db.NewQueries(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queries.Close()
+	migrator, err := db.NewMigrator(queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	account := db.Account{
+		Username:        "alice",
+		Email:           "alice@example.com",
+		OauthProvider:   "google",
+		OauthProviderID: "alice",
+		Handle:          "alice",
+	}
+	if err = queries.Accounts.Create(context.Background(), &account); err != nil {
+		t.Fatal(err)
+	}
+	token, err := security.NewJWTService(config).CreateToken(account.ID, security.AccessToken, int(account.TokenVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- RunServe(config, logger, new(slog.LevelVar))
+	}()
+	waitReady(t, "http://"+listenAddr+"/readyz")
+	waitReady(t, "http://"+adminAddr+"/metrics")
+
+	// Connect two WebSocket clients of the same account, such as two tabs, and wait for both
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	conns := make([]*websocket.Conn, 2)
+	for i := range conns {
+		conns[i], _, err = websocket.DefaultDialer.Dial("ws://"+listenAddr+"/ws/messages", header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conns[i].Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(scrape(t, adminAddr), "zola_websocket_clients 2") {
+		if time.Now().After(deadline) {
+			t.Fatal("WebSocket clients not counted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Send a public message, it goes through the outbox and the task queue to the hub
+	body, _ := json.Marshal(api.SendMessageRequest{SenderID: account.ID, Content: "hello"})
+	req, _ := http.NewRequest(http.MethodPost, "http://"+listenAddr+"/api/messages", bytes.NewReader(body))
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/messages: status %d", res.StatusCode)
+	}
+
+	// A route with an ID in the path
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/api/users/%d", listenAddr, account.ID), nil)
+	req.Header = header.Clone()
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(frame), "hello") {
+			t.Fatalf("unexpected frame %s", frame)
+		}
+	}
+
+	// The delivery is counted once the task returns, so poll for it
+	var metrics string
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		metrics = scrape(t, adminAddr)
+		if strings.Contains(metrics, `zola_task_duration_seconds_count{result="success",task_type="send-message"} 1`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("send-message task not recorded:\n%s", metrics)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	for _, want := range []string{
+		`zola_http_requests_total{method="POST",route="/api/messages",status="201"} 1`,
+		`zola_http_request_duration_seconds_count{method="POST",route="/api/messages",status="201"} 1`,
+		`zola_websocket_clients 2`,
+		`zola_push_devices 0`,
+		`zola_outbox_pending_events 0`,
+		`zola_task_queue_tasks{queue="default",state="pending"}`,
+		`zola_task_queue_tasks{queue="realtime",state="pending"}`,
+		`zola_task_queue_tasks{queue="realtime",state="scheduled"}`,
+		`zola_messages_delivered_total{chat_type="public-chat",result="sent"} 1`,
+		`zola_http_requests_total{method="GET",route="/api/users/:id",status="200"} 1`,
+		`go_sql_open_connections{db_name="sqlite"}`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metric %s not found", want)
+		}
+	}
+	// The IDs in the path must not create new series
+	if strings.Contains(metrics, fmt.Sprintf(`route="/api/users/%d"`, account.ID)) {
+		t.Error("route label holds the path instead of the route template")
+	}
+
+	// Shut down like on SIGTERM, every connection of the account is told to reconnect
+	if err = syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	for i, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		var event pubsub.Event
+		if err = conn.ReadJSON(&event); err != nil || event.Type != pubsub.ServerRestart {
+			t.Fatalf("connection %d: got event %+v and error %v, want the restart event", i, event, err)
+		}
+		if _, _, err = conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+			t.Fatalf("connection %d: got %v, want the service restart close frame", i, err)
+		}
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
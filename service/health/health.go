@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Time given to every check, so a hanging dependency fails the check instead of the probe
+const checkTimeout = 2 * time.Second
+
+// Check of a dependency, return an error if the dependency is not usable
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Result of a check
+type Result struct {
+	Name     string `json:"name"`
+	Healthy  bool   `json:"healthy"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Checker holds the checks of the dependencies needed to serve traffic, and whether the
+// server is draining before shutdown
+type Checker struct {
+	mutex    sync.RWMutex
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+// Constructor method for Checker
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Method to register a check, checks are run in registration order
+func (checker *Checker) Register(name string, check Check) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	checker.checks = append(checker.checks, namedCheck{name: name, check: check})
+}
+
+// Method to mark the server as draining, it is not ready anymore so no new traffic is routed to it
+func (checker *Checker) SetDraining() {
+	checker.draining.Store(true)
+}
+
+// Method to check if the server is draining
+func (checker *Checker) Draining() bool {
+	return checker.draining.Load()
+}
+
+// Method to run every check concurrently, return the results and whether all of them passed
+func (checker *Checker) Run(ctx context.Context) ([]Result, bool) {
+	checker.mutex.RLock()
+	checks := checker.checks
+	checker.mutex.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.check(checkCtx)
+			results[i] = Result{
+				Name:     check.name,
+				Healthy:  err == nil,
+				Duration: time.Since(start).String(),
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	healthy := true
+	for _, result := range results {
+		healthy = healthy && result.Healthy
+	}
+	return results, healthy
+}
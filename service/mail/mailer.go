@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/danglnh07/zola/util"
+)
+
+// Mailer interface
+type Mailer interface {
+	SendEmail(to, subject, body string) error
+}
+
+// SMTP mailer, send plain text email through the SMTP server in config
+type SMTPMailer struct {
+	config *util.Config
+}
+
+// Constructor method for SMTP mailer
+func NewSMTPMailer(config *util.Config) Mailer {
+	return &SMTPMailer{
+		config: config,
+	}
+}
+
+func (mailer *SMTPMailer) SendEmail(to, subject, body string) error {
+	auth := smtp.PlainAuth("", mailer.config.Email, mailer.config.AppPassword, mailer.config.SMTPHost)
+	addr := fmt.Sprintf("%s:%s", mailer.config.SMTPHost, mailer.config.SMTPPort)
+
+	// Build the message with the minimum headers, the body is sent as plain text
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: Zola <%s>\r\n", mailer.config.Email)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(addr, auth, mailer.config.Email, []string{to}, []byte(msg.String()))
+}
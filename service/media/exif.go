@@ -0,0 +1,138 @@
+package media
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// Read the EXIF orientation tag (0x0112) of a JPEG file. Return 1 (normal) if the
+// file has no EXIF data or the data is malformed.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	// Walk the JPEG segments until we find the APP1 (EXIF) segment
+	offset := 2
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			return 1
+		}
+		marker := data[offset+1]
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if length < 2 || offset+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[offset+4 : offset+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+
+		// Start of scan, no more metadata after this
+		if marker == 0xDA {
+			return 1
+		}
+		offset += 2 + length
+	}
+
+	return 1
+}
+
+// Find the orientation tag inside the first IFD of a TIFF header
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := range entries {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+
+	return 1
+}
+
+// Transform the pixels so the image displays upright without the EXIF orientation tag
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	src := toRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+
+	// Orientation 5 to 8 swap the width and height
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	// Copy the pixels between the buffers directly, At and Set are too slow for large images
+	for y := range height {
+		row := src.Pix[y*src.Stride : y*src.Stride+width*4]
+		for x := range width {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirror horizontal
+				dx, dy = width-1-x, y
+			case 3: // Rotate 180
+				dx, dy = width-1-x, height-1-y
+			case 4: // Mirror vertical
+				dx, dy = x, height-1-y
+			case 5: // Mirror horizontal and rotate 270 CW
+				dx, dy = y, x
+			case 6: // Rotate 90 CW
+				dx, dy = height-1-y, x
+			case 7: // Mirror horizontal and rotate 90 CW
+				dx, dy = height-1-y, width-1-x
+			case 8: // Rotate 270 CW
+				dx, dy = y, width-1-x
+			}
+			offset := dy*dst.Stride + dx*4
+			copy(dst.Pix[offset:offset+4], row[x*4:x*4+4])
+		}
+	}
+
+	return dst
+}
+
+// Convert the image to RGBA with its origin at (0, 0), the conversion from the decoded JPEG
+// formats has a fast path in the draw package
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,163 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// Thumbnail sizes (longest edge, in pixels) generated for every image attachment
+var ThumbnailSizes = []int{64, 256, 1024}
+
+// Edge of the avatars in pixels, avatars are square
+const AvatarSize = 256
+
+// Image formats supported for processing, keyed by MIME type
+var supportedFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// Check if the content type is an image format we can process
+func IsSupportedImage(contentType string) bool {
+	_, ok := supportedFormats[contentType]
+	return ok
+}
+
+// Decoded image along with its source format
+type Image struct {
+	Image  image.Image
+	Format string
+}
+
+// Returned by Decode when the image has more pixels than allowed
+var ErrImageTooLarge = errors.New("image is too large")
+
+// Decode the raw image data. For JPEG, the EXIF orientation is applied to the pixels,
+// so the image still displays correctly once the metadata is stripped.
+// The dimensions are read from the header first, images with more than maxPixels pixels are
+// rejected with ErrImageTooLarge before any pixel is allocated.
+func Decode(data []byte, maxPixels int64) (*Image, error) {
+	if err := CheckPixels(bytes.NewReader(data), maxPixels); err != nil {
+		return nil, err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	return &Image{Image: img, Format: format}, nil
+}
+
+// Read the dimensions from the header of the image, return ErrImageTooLarge if it has more
+// than maxPixels pixels. The pixels themselves are not decoded
+func CheckPixels(r io.Reader, maxPixels int64) error {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxPixels {
+		return fmt.Errorf("%w: %dx%d pixels", ErrImageTooLarge, config.Width, config.Height)
+	}
+	return nil
+}
+
+// Width of the image in pixels
+func (img *Image) Width() int {
+	return img.Image.Bounds().Dx()
+}
+
+// Height of the image in pixels
+func (img *Image) Height() int {
+	return img.Image.Bounds().Dy()
+}
+
+// Extension of the file used when the image is re-encoded
+func (img *Image) Extension() string {
+	if img.Format == "jpeg" {
+		return "jpg"
+	}
+	return img.Format
+}
+
+// Re-encode the image in its original format. The standard encoders never write
+// metadata, so this is how EXIF (GPS location, camera info, ...) get stripped.
+func (img *Image) Encode(w io.Writer) error {
+	return encode(w, img.Image, img.Format)
+}
+
+// Scale the image down so that its longest edge equal to size, keeping the aspect ratio.
+// Images already smaller than size are returned as is.
+func (img *Image) Thumbnail(size int) *Image {
+	width, height := img.Width(), img.Height()
+	if width <= size && height <= size {
+		return img
+	}
+
+	if width >= height {
+		height = max(1, height*size/width)
+		width = size
+	} else {
+		width = max(1, width*size/height)
+		height = size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img.Image, img.Image.Bounds(), draw.Over, nil)
+
+	// GIF thumbnails are written as PNG, since we only keep the first frame anyway
+	format := img.Format
+	if format == "gif" {
+		format = "png"
+	}
+
+	return &Image{Image: dst, Format: format}
+}
+
+// Crop the center square of the image and scale it down to size, used for avatars. Images already
+// smaller than size keep the edge of their square.
+func (img *Image) Avatar(size int) *Image {
+	width, height := img.Width(), img.Height()
+	side := min(width, height)
+	bounds := img.Image.Bounds()
+	x := bounds.Min.X + (width-side)/2
+	y := bounds.Min.Y + (height-side)/2
+
+	size = min(size, side)
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img.Image, image.Rect(x, y, x+side, y+side), draw.Over, nil)
+
+	// Animated avatars are not supported, GIF are written as PNG like the thumbnails
+	format := img.Format
+	if format == "gif" {
+		format = "png"
+	}
+
+	return &Image{Image: dst, Format: format}
+}
+
+func encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("unsupported image format: %s", format)
+	}
+}
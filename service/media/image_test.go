@@ -0,0 +1,93 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// Build a PNG holding only a header, which declares the given dimensions
+func pngHeader(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte("\x89PNG\r\n\x1a\n"))
+
+	chunk := make([]byte, 17)
+	copy(chunk, "IHDR")
+	binary.BigEndian.PutUint32(chunk[4:], width)
+	binary.BigEndian.PutUint32(chunk[8:], height)
+	chunk[12] = 8 // Bit depth
+	chunk[13] = 6 // RGBA
+
+	binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestDecodeRejectsTooManyPixels(t *testing.T) {
+	_, err := Decode(pngHeader(50000, 50000), 40_000_000)
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("expected ErrImageTooLarge, got %v", err)
+	}
+}
+
+func TestDecodeAcceptsImageWithinLimit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Decode(buf.Bytes(), 200)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if img.Width() != 20 || img.Height() != 10 {
+		t.Fatalf("got %dx%d, want 20x10", img.Width(), img.Height())
+	}
+
+	if _, err := Decode(buf.Bytes(), 199); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("expected ErrImageTooLarge, got %v", err)
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// 3x2 image where every pixel is unique, with a non-zero origin
+	src := image.NewRGBA(image.Rect(5, 5, 8, 7))
+	for y := range 2 {
+		for x := range 3 {
+			src.Set(5+x, 5+y, color.RGBA{R: uint8(x), G: uint8(y), A: 255})
+		}
+	}
+
+	// Position of the source pixel (x, y) once transformed, for a 3x2 image
+	tests := map[int]func(x, y int) (int, int){
+		2: func(x, y int) (int, int) { return 2 - x, y },
+		3: func(x, y int) (int, int) { return 2 - x, 1 - y },
+		4: func(x, y int) (int, int) { return x, 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return 1 - y, x },
+		7: func(x, y int) (int, int) { return 1 - y, 2 - x },
+		8: func(x, y int) (int, int) { return y, 2 - x },
+	}
+	for orientation, position := range tests {
+		dst := applyOrientation(src, orientation)
+		for y := range 2 {
+			for x := range 3 {
+				dx, dy := position(x, y)
+				want := color.RGBA{R: uint8(x), G: uint8(y), A: 255}
+				if got := dst.At(dx, dy); got != want {
+					t.Errorf("orientation %d: pixel (%d, %d) moved to (%d, %d) is %v, want %v", orientation, x, y, dx, dy, got, want)
+				}
+			}
+		}
+	}
+
+	if got := applyOrientation(src, 1); got != image.Image(src) {
+		t.Error("orientation 1 should return the image as is")
+	}
+}
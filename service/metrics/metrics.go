@@ -0,0 +1,140 @@
+// Package metrics holds the Prometheus metrics of the server, exposed on /metrics of the
+// admin listener. Every metric is prefixed with zola_:
+//
+//	zola_http_requests_total{method, route, status}                counter
+//	zola_http_request_duration_seconds{method, route, status}      histogram
+//	zola_websocket_clients                                         gauge, open WebSocket connections, one per tab or device
+//	zola_push_devices                                              gauge, devices subscribed to Web Push
+//	zola_task_queue_tasks{queue, state}                            gauge, tasks waiting in the task queue
+//	zola_outbox_pending_events                                     gauge, outbox events not dispatched yet
+//	zola_messages_delivered_total{chat_type, result}               counter, result is sent, failed or offline
+//	zola_task_duration_seconds{task_type, result}                  histogram, result is success or failure
+//	zola_task_retries_total{task_type}                             counter, attempts that are retries
+//	zola_rate_limit_rejections_total{policy}                       counter
+//
+// The database pool stats (go_sql_*{db_name}), and the Go runtime and process metrics
+// (go_*, process_*) are exposed as well
+package metrics
+
+import (
+	"database/sql"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "zola"
+
+// Registry of every metric of the server, the default registry is not used so libraries
+// can't add metrics behind our back
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests handled, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests, by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	MessagesDelivered = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_delivered_total",
+		Help:      "Number of message deliveries to recipients, by chat type and result (sent, failed or offline).",
+	}, []string{"chat_type", "result"})
+
+	TaskDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_duration_seconds",
+		Help:      "Processing time of the background tasks, by task type and result (success or failure).",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"task_type", "result"})
+
+	TaskRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_retries_total",
+		Help:      "Number of task attempts that were retries of a failed attempt, by task type.",
+	}, []string{"task_type"})
+
+	RateLimitRejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Number of requests, WebSocket frames and messages rejected by a rate limit, by policy.",
+	}, []string{"policy"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Register a gauge computed on every scrape, used for values owned by other packages such as
+// the number of connected clients
+func RegisterGaugeFunc(name, help string, fn func() float64) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// Register the stats of the database connection pool, as go_sql_* metrics
+func RegisterDBStats(db *sql.DB, dbName string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, dbName))
+}
+
+// Number of tasks of a queue in a given state, such as pending or retry
+type QueueStat struct {
+	Queue string `json:"queue"`
+	State string `json:"state"`
+	Tasks int    `json:"tasks"`
+}
+
+// Collector of the task queue depths, fetched on every scrape
+type queueCollector struct {
+	desc   *prometheus.Desc
+	stats  func() ([]QueueStat, error)
+	logger *slog.Logger
+}
+
+// Register the depths of the task queues, returned by stats on every scrape
+func RegisterQueueStats(stats func() ([]QueueStat, error), logger *slog.Logger) {
+	Registry.MustRegister(&queueCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "task_queue", "tasks"),
+			"Number of tasks in the task queue, by queue and state.",
+			[]string{"queue", "state"}, nil,
+		),
+		stats:  stats,
+		logger: logger,
+	})
+}
+
+func (collector *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.desc
+}
+
+func (collector *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := collector.stats()
+	if err != nil {
+		collector.logger.Error("failed to collect task queue stats", "error", err)
+		ch <- prometheus.NewInvalidMetric(collector.desc, err)
+		return
+	}
+
+	for _, stat := range stats {
+		ch <- prometheus.MustNewConstMetric(collector.desc, prometheus.GaugeValue, float64(stat.Tasks), stat.Queue, stat.State)
+	}
+}
@@ -1,13 +1,20 @@
 package pubsub
 
 import (
+	"sync"
+	"time"
+
 	"github.com/gorilla/websocket"
 )
 
+// Time allowed to write a control frame to the client
+const writeWait = 5 * time.Second
+
 // Client struct, which holds the account ID and their web socket connection
 type Client struct {
 	AccountID uint
 	conn      *websocket.Conn
+	mutex     sync.Mutex // WebSocket connections support only one concurrent writer
 }
 
 // Constructor method for Client struct
@@ -20,5 +27,22 @@ func NewClient(accountID uint, conn *websocket.Conn) *Client {
 
 // Method to write a message back to client using WebSocket connection
 func (client *Client) WriteMessage(message any) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
 	return client.conn.WriteJSON(message)
 }
+
+// Method to send a close frame to the client, then close the connection
+func (client *Client) Close(code int, reason string) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	err := client.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(writeWait),
+	)
+	client.conn.Close()
+	return err
+}
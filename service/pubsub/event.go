@@ -0,0 +1,82 @@
+package pubsub
+
+import "time"
+
+// Event types pushed to clients
+const (
+	AttachmentReady = "attachment.ready"
+	ServerRestart   = "server.restart"
+	Error           = "error"
+	Announcement    = "announcement"
+	AccountBanned   = "account.banned"
+	SessionRevoked  = "session.revoked"
+	AccountDeleted  = "account.deleted"
+	AccountWarned   = "account.warned"
+	AccountMuted    = "account.muted"
+	MessageDeleted  = "message.deleted"
+
+	ContactRequestReceived = "contact.request_received"
+	ContactRequestAccepted = "contact.request_accepted"
+)
+
+// Error codes of the error event
+const (
+	ErrorRateLimited = "rate_limited"
+)
+
+// Event struct, a typed notification pushed to clients through their WebSocket connection
+type Event struct {
+	Type    string `json:"type"`
+	Payload any    `json:"payload"`
+}
+
+// Payload of the server restart event, sent right before the connection is closed.
+// Clients should wait ReconnectIn milliseconds before reconnecting, so they don't all
+// reconnect at the same time
+type ServerRestartPayload struct {
+	ReconnectIn int64 `json:"reconnect_in"`
+}
+
+// Payload of the error event, sent when a frame from the client is rejected. RetryAfter is
+// in milliseconds, and only set when retrying later would succeed
+type ErrorPayload struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int64  `json:"retry_after,omitempty"`
+}
+
+// Payload of the announcement event, a system message sent by the operators to every client
+type AnnouncementPayload struct {
+	Message string    `json:"message"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Payload of the account banned event, sent right before the connection is closed
+type AccountBannedPayload struct {
+	Reason string `json:"reason"`
+}
+
+// Payload of the account warned event, a warning sent by a moderator
+type AccountWarnedPayload struct {
+	Reason string `json:"reason"`
+}
+
+// Payload of the account muted event, the account can't send messages until the mute is over
+type AccountMutedPayload struct {
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// Payload of the message deleted event, sent to the clients which may show the message
+type MessageDeletedPayload struct {
+	MessageID uint `json:"message_id"`
+}
+
+// Payload of the contact request events, sent to the addressee when a request is received and
+// to the requester when it's accepted. The account is the other side of the request
+type ContactRequestPayload struct {
+	RequestID   uint   `json:"request_id"`
+	AccountID   uint   `json:"account_id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+}
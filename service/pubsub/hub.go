@@ -1,31 +1,51 @@
 package pubsub
 
 import (
+	"errors"
+	"math/rand/v2"
 	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
-// Hub struct, used to track the presence of online users
+// Returned when subscribing after the hub has been shut down
+var ErrHubClosed = errors.New("hub is closed")
+
+// Hub struct, used to track the presence of online users. An account has a client for each
+// of its connections, such as several tabs or devices
 type Hub struct {
 	mutex   *sync.RWMutex
-	Clients map[uint]*Client
+	Clients map[uint]map[*Client]struct{}
+	closed  bool
 }
 
 // Constructor method of Hub
 func NewHub() *Hub {
 	return &Hub{
 		mutex:   &sync.RWMutex{},
-		Clients: make(map[uint]*Client),
+		Clients: make(map[uint]map[*Client]struct{}),
 	}
 }
 
 // Method to subscribe (join) into the chat server
-func (hub *Hub) Subscribe(client *Client) {
+func (hub *Hub) Subscribe(client *Client) error {
 	// Lock to prevent race condition
 	hub.mutex.Lock()
 	defer hub.mutex.Unlock()
 
-	// Add client into the Clients map
-	hub.Clients[client.AccountID] = client
+	if hub.closed {
+		return ErrHubClosed
+	}
+
+	// Add client into the clients of its account
+	clients, ok := hub.Clients[client.AccountID]
+	if !ok {
+		clients = make(map[*Client]struct{})
+		hub.Clients[client.AccountID] = clients
+	}
+	clients[client] = struct{}{}
+	return nil
 }
 
 // Method to unsubscribe the client out of the chat server
@@ -34,9 +54,113 @@ func (hub *Hub) Unsubscribe(client *Client) {
 	hub.mutex.Lock()
 	defer hub.mutex.Unlock()
 
-	// Remove the client out of Clients map
-	delete(hub.Clients, client.AccountID)
+	// Remove the client out of Clients map, the other connections of the account stay
+	clients := hub.Clients[client.AccountID]
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(hub.Clients, client.AccountID)
+	}
 
 	// Close the WebSocket connection
 	client.conn.Close()
 }
+
+// Method to write a message to every connection of an account. Return false if the account is
+// currently offline, and an error only if the message reached none of its connections
+func (hub *Hub) SendTo(accountID uint, message any) (bool, error) {
+	clients := hub.accountClients(accountID)
+	if len(clients) == 0 {
+		return false, nil
+	}
+
+	var errs []error
+	for _, client := range clients {
+		if err := client.WriteMessage(message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(clients) {
+		return true, errors.Join(errs...)
+	}
+
+	return true, nil
+}
+
+// Get a copy of the clients of an account, so they can be written to without holding the lock
+func (hub *Hub) accountClients(accountID uint) []*Client {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	clients := make([]*Client, 0, len(hub.Clients[accountID]))
+	for client := range hub.Clients[accountID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Method to get the account IDs of all online clients
+func (hub *Hub) OnlineAccountIDs() []uint {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	ids := make([]uint, 0, len(hub.Clients))
+	for accountID := range hub.Clients {
+		ids = append(ids, accountID)
+	}
+
+	return ids
+}
+
+// Method to get the number of connected clients, an account counts once per connection
+func (hub *Hub) Count() int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	count := 0
+	for _, clients := range hub.Clients {
+		count += len(clients)
+	}
+	return count
+}
+
+// Method to check if an account currently has an open connection
+func (hub *Hub) IsOnline(accountID uint) bool {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	_, ok := hub.Clients[accountID]
+	return ok
+}
+
+// Method to close every connection of an account, if it is online. The read loop of each
+// connection fails once it is closed, which unsubscribes the client
+func (hub *Hub) Disconnect(accountID uint, code int, reason string) {
+	for _, client := range hub.accountClients(accountID) {
+		client.Close(code, reason)
+	}
+}
+
+// Method to disconnect every client, new clients are rejected afterward. Each client is told
+// to reconnect after a random delay up to maxReconnectDelay, to spread the reconnections
+func (hub *Hub) Shutdown(maxReconnectDelay time.Duration) {
+	hub.mutex.Lock()
+	hub.closed = true
+	var clients []*Client
+	for _, accountClients := range hub.Clients {
+		for client := range accountClients {
+			clients = append(clients, client)
+		}
+	}
+	hub.mutex.Unlock()
+
+	for _, client := range clients {
+		delay := time.Duration(rand.Int64N(int64(maxReconnectDelay) + 1))
+		client.WriteMessage(Event{
+			Type:    ServerRestart,
+			Payload: ServerRestartPayload{ReconnectIn: delay.Milliseconds()},
+		})
+
+		// The read loop of the connection fails once it is closed, which unsubscribes the client
+		client.Close(websocket.CloseServiceRestart, "server is restarting")
+	}
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// In-memory store, buckets are only shared by the requests of the same replica. The least
+// recently used buckets are evicted once capacity is reached, an evicted bucket is full again
+type MemoryStore struct {
+	mutex    sync.Mutex
+	capacity int
+	buckets  map[string]*list.Element
+	lru      *list.List // Front is the most recently used
+}
+
+type memoryBucket struct {
+	key        string
+	tokens     float64
+	lastRefill time.Time
+}
+
+// Constructor method for MemoryStore, capacity is the maximum number of buckets kept
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: max(1, capacity),
+		buckets:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (store *MemoryStore) Allow(ctx context.Context, key string, policy Policy) (Result, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key = policy.Name + ":" + key
+	now := time.Now()
+
+	var bucket *memoryBucket
+	if element, ok := store.buckets[key]; ok {
+		store.lru.MoveToFront(element)
+		bucket = element.Value.(*memoryBucket)
+	} else {
+		bucket = &memoryBucket{key: key, tokens: float64(policy.Limit), lastRefill: now}
+		store.buckets[key] = store.lru.PushFront(bucket)
+		if store.lru.Len() > store.capacity {
+			oldest := store.lru.Back()
+			store.lru.Remove(oldest)
+			delete(store.buckets, oldest.Value.(*memoryBucket).key)
+		}
+	}
+
+	// Refill, the policy may have changed since the last request so the bucket is capped
+	elapsed := now.Sub(bucket.lastRefill)
+	bucket.tokens = min(float64(policy.Limit), bucket.tokens+float64(elapsed)/float64(policy.Interval))
+	bucket.lastRefill = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+
+	return newResult(allowed, bucket.tokens, policy), nil
+}
+
+// Number of buckets currently kept
+func (store *MemoryStore) Len() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.lru.Len()
+}
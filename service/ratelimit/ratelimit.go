@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Token bucket policy: the bucket holds up to Limit tokens and gets a token back every
+// Interval. Every request takes a token, and is rejected when the bucket is empty
+type Policy struct {
+	Name     string // Policies have their own buckets, even for the same key
+	Limit    int
+	Interval time.Duration
+}
+
+// Method to get the time needed to refill an empty bucket
+func (policy Policy) Window() time.Duration {
+	return time.Duration(policy.Limit) * policy.Interval
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration // Until the bucket is full again
+	RetryAfter time.Duration // Until the next request is allowed, zero if allowed
+}
+
+// Store of the buckets, keyed by the caller (account or IP)
+type Store interface {
+	Allow(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+// Build the result from the tokens left in the bucket, shared by every store
+func newResult(allowed bool, tokens float64, policy Policy) Result {
+	result := Result{
+		Allowed:    allowed,
+		Limit:      policy.Limit,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: time.Duration((float64(policy.Limit) - tokens) * float64(policy.Interval)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * float64(policy.Interval))
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Refill and take a token atomically. The Redis clock is used, so replicas with skewed
+// clocks still share the same buckets. The bucket expires once it would be full again
+var allowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = limit
+	ts = now
+end
+
+tokens = math.min(limit, tokens + math.max(0, now - ts) / interval)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.6f', tokens), 'ts', string.format('%.0f', now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((limit - tokens) * interval / 1000) + 1000)
+
+return {allowed, string.format('%.6f', tokens)}
+`)
+
+// Redis store, buckets are shared by every replica
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// Constructor method for RedisStore
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: "zola:ratelimit:",
+	}
+}
+
+func (store *RedisStore) Allow(ctx context.Context, key string, policy Policy) (Result, error) {
+	reply, err := allowScript.Run(
+		ctx,
+		store.client,
+		[]string{store.prefix + policy.Name + ":" + key},
+		policy.Limit,
+		policy.Interval.Microseconds(),
+	).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	if len(reply) != 2 {
+		return Result{}, fmt.Errorf("unexpected rate limit script reply: %v", reply)
+	}
+	allowed, _ := reply[0].(int64)
+	raw, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("unexpected rate limit script reply: %v", reply)
+	}
+
+	return newResult(allowed == 1, tokens, policy), nil
+}
@@ -1,6 +1,10 @@
 package security
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"time"
 
@@ -107,3 +111,21 @@ func (service *JWTService) VerifyToken(signedToken string) (*CustomClaims, error
 
 	return claims, nil
 }
+
+// Generate a random token for a link sent by email, along with its hash. Only the hash should
+// be stored, so the link can't be rebuilt from the database
+func NewLinkToken() (token string, hash string, err error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(data)
+	return token, HashLinkToken(token), nil
+}
+
+// Hash a token generated by NewLinkToken
+func HashLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/danglnh07/zola/util"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the service and of the tracer of the spans created by this module
+const (
+	ServiceName = "zola"
+	tracerName  = "github.com/danglnh07/zola"
+)
+
+// Get the tracer used for the spans created by this module
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Set up the global tracer provider and propagator from the config. The propagator is always
+// set, so the trace context of the incoming requests is carried even if tracing is disabled.
+// The returned function flushes the pending spans and stops the exporter
+func Setup(ctx context.Context, config *util.Config) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.TracingExporter {
+	case util.TracingExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(config.OTLPEndpoint))
+	case util.TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		// The default tracer provider is a no-op
+		return func(ctx context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s trace exporter: %w", config.TracingExporter, err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(rootSampler{sdktrace.TraceIDRatioBased(config.TraceSampleRatio)})),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Sampler of the spans without parent. Queries run outside of a request or a task, such as the
+// outbox relay polling, would start a new trace every time, so client spans are never sampled
+// as root. Other spans are sampled by the wrapped sampler
+type rootSampler struct {
+	sdktrace.Sampler
+}
+
+func (sampler rootSampler) ShouldSample(params sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if params.Kind == trace.SpanKindClient {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.Drop,
+			Tracestate: trace.SpanContextFromContext(params.ParentContext).TraceState(),
+		}
+	}
+	return sampler.Sampler.ShouldSample(params)
+}
+
+func (sampler rootSampler) Description() string {
+	return "RootSampler{" + sampler.Sampler.Description() + "}"
+}
@@ -0,0 +1,129 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Returned when the push service reports that the subscription no longer exists (404 or 410),
+// the subscription should be removed
+var ErrSubscriptionExpired = errors.New("push subscription expired")
+
+// Push subscription, as returned by PushManager.subscribe() in the browser
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// Web Push client, encrypt and send notifications to push services
+type Client struct {
+	keys       *VAPIDKeys
+	subject    string // Contact of the application server, a mailto: or https: URL
+	httpClient *http.Client
+}
+
+// Constructor method for Client
+func NewClient(keys *VAPIDKeys, subject string) *Client {
+	return &Client{
+		keys:       keys,
+		subject:    subject,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Public key to be used as applicationServerKey when the browser subscribes
+func (client *Client) PublicKey() string {
+	return client.keys.PublicKey
+}
+
+// Encrypt the payload and post it to the subscription endpoint. TTL is how long the push
+// service should keep the message if the device is offline.
+func (client *Client) Send(ctx context.Context, sub Subscription, payload []byte, ttl time.Duration) error {
+	body, err := Encrypt(payload, sub.P256dh, sub.Auth)
+	if err != nil {
+		return err
+	}
+
+	authorization, err := client.vapidAuthorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	req.Header.Set("Urgency", "normal")
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrSubscriptionExpired
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	default:
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push service responded with status %d: %s", resp.StatusCode, detail)
+	}
+}
+
+// Build the VAPID Authorization header (RFC 8292) for the push service hosting the endpoint
+func (client *Client) vapidAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid subscription endpoint: %w", err)
+	}
+
+	// Use map claims so audience is encoded as a string, some push services reject an array
+	claims := jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": client.subject,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(client.keys.signingKey)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("vapid t=%s, k=%s", token, client.keys.PublicKey), nil
+}
+
+// Validate the subscription sent by the browser before storing it
+func (sub Subscription) Validate() error {
+	u, err := url.Parse(sub.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("endpoint must be an absolute https URL")
+	}
+
+	p256dh, err := decodeKey(sub.P256dh)
+	if err != nil || len(p256dh) != 65 {
+		return fmt.Errorf("p256dh must be an uncompressed P-256 public key")
+	}
+
+	auth, err := decodeKey(sub.Auth)
+	if err != nil || len(auth) != 16 {
+		return fmt.Errorf("auth must be a 16 bytes secret")
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Browser side of a subscription, holding the keys needed to decrypt the notifications
+type testUserAgent struct {
+	private *ecdh.PrivateKey
+	auth    []byte
+}
+
+func newTestUserAgent(t *testing.T) *testUserAgent {
+	t.Helper()
+
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatal(err)
+	}
+	return &testUserAgent{private: private, auth: auth}
+}
+
+func (ua *testUserAgent) subscription(endpoint string) Subscription {
+	return Subscription{
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(ua.private.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(ua.auth),
+	}
+}
+
+// Decrypt an aes128gcm body (RFC 8188) with the keys of the subscription (RFC 8291), as the
+// browser does
+func (ua *testUserAgent) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+
+	// Header: salt (16) || record size (4) || key id length (1) || key id
+	if len(body) < 21 {
+		t.Fatalf("body too short: %d bytes", len(body))
+	}
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != recordSize {
+		t.Fatalf("record size %d, want %d", rs, recordSize)
+	}
+	idLen := int(body[20])
+	if idLen != 65 || len(body) < 21+idLen {
+		t.Fatalf("invalid key id length %d", idLen)
+	}
+	asPublicRaw := body[21 : 21+idLen]
+	ciphertext := body[21+idLen:]
+
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdhSecret, err := ua.private.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), ua.private.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, asPublicRaw...)
+	ikm, err := hkdfRead(ecdhSecret, ua.auth, keyInfo, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cek, err := hkdfRead(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := hkdfRead(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %v", err)
+	}
+
+	// The last record ends with the 0x02 delimiter, followed by the padding
+	plaintext = bytes.TrimRight(plaintext, "\x00")
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 0x02 {
+		t.Fatal("missing last record delimiter")
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+// Request received by the fake push service
+type pushRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// Fake push service answering every request with status
+func newPushService(t *testing.T, status int) (*httptest.Server, <-chan pushRequest) {
+	t.Helper()
+
+	requests := make(chan pushRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests <- pushRequest{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	keys, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient(keys, "mailto:admin@example.com")
+}
+
+func TestSend(t *testing.T) {
+	client := newTestClient(t)
+	server, requests := newPushService(t, http.StatusCreated)
+	ua := newTestUserAgent(t)
+
+	payload := []byte(`{"type":"message","body":"hello"}`)
+	if err := client.Send(context.Background(), ua.subscription(server.URL+"/push/abc"), payload, 90*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+
+	if got := req.header.Get("TTL"); got != "90" {
+		t.Errorf("TTL %q, want 90", got)
+	}
+	if got := req.header.Get("Urgency"); got != "normal" {
+		t.Errorf("Urgency %q, want normal", got)
+	}
+	if got := req.header.Get("Content-Encoding"); got != "aes128gcm" {
+		t.Errorf("Content-Encoding %q, want aes128gcm", got)
+	}
+
+	// Authorization: vapid t=<JWT>, k=<public key>
+	authorization, ok := strings.CutPrefix(req.header.Get("Authorization"), "vapid ")
+	if !ok {
+		t.Fatalf("Authorization %q is not a vapid header", req.header.Get("Authorization"))
+	}
+	var token, key string
+	for _, param := range strings.Split(authorization, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "t":
+			token = value
+		case "k":
+			key = value
+		}
+	}
+	if key != client.PublicKey() {
+		t.Errorf("k %q, want the VAPID public key %q", key, client.PublicKey())
+	}
+
+	// The JWT is signed by the VAPID key, for the origin of the push service
+	rawKey, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(rawKey) != 65 {
+		t.Fatalf("invalid k %q", key)
+	}
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(rawKey[1:33]),
+		Y:     new(big.Int).SetBytes(rawKey[33:]),
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return publicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		t.Fatalf("invalid VAPID token: %v", err)
+	}
+	if claims["aud"] != server.URL {
+		t.Errorf("aud %v, want %s", claims["aud"], server.URL)
+	}
+	if claims["sub"] != "mailto:admin@example.com" {
+		t.Errorf("sub %v, want mailto:admin@example.com", claims["sub"])
+	}
+	exp, _ := claims.GetExpirationTime()
+	if exp.After(time.Now().Add(24 * time.Hour)) {
+		t.Errorf("exp %v is more than 24 hours away", exp)
+	}
+
+	if got := ua.decrypt(t, req.body); !bytes.Equal(got, payload) {
+		t.Errorf("decrypted %q, want %q", got, payload)
+	}
+}
+
+func TestSendStatus(t *testing.T) {
+	for _, tt := range []struct {
+		status  int
+		expired bool
+		failed  bool
+	}{
+		{status: http.StatusCreated},
+		{status: http.StatusNotFound, expired: true, failed: true},
+		{status: http.StatusGone, expired: true, failed: true},
+		{status: http.StatusTooManyRequests, failed: true},
+		{status: http.StatusInternalServerError, failed: true},
+	} {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			client := newTestClient(t)
+			server, _ := newPushService(t, tt.status)
+			ua := newTestUserAgent(t)
+
+			err := client.Send(context.Background(), ua.subscription(server.URL), []byte("hello"), time.Hour)
+			if got := errors.Is(err, ErrSubscriptionExpired); got != tt.expired {
+				t.Errorf("expired %v, want %v (error %v)", got, tt.expired, err)
+			}
+			if got := err != nil; got != tt.failed {
+				t.Errorf("failed %v, want %v (error %v)", got, tt.failed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Record size of the aes128gcm content coding. The whole payload is sent in a single record
+const recordSize = 4096
+
+// Maximum size of the plaintext that fits in one record: record size minus the
+// padding delimiter (1 byte) and the AEAD tag (16 bytes)
+const MaxPayloadSize = recordSize - 1 - 16
+
+// Encrypt the payload for a subscription following RFC 8291 (Message Encryption for Web Push),
+// using the aes128gcm content coding of RFC 8188. The result is the request body, header included.
+func Encrypt(payload []byte, p256dh, auth string) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("payload too large: %d bytes, maximum is %d", len(payload), MaxPayloadSize)
+	}
+
+	// Keys of the user agent, sent in the subscription
+	uaPublicRaw, err := decodeKey(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	authSecret, err := decodeKey(auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth secret: %w", err)
+	}
+
+	// Ephemeral key of the application server, a new one for every message
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	// IKM = HKDF(auth_secret, ecdh_secret, "WebPush: info" || 0x00 || ua_public || as_public, 32)
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicRaw...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdfRead(ecdhSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	// Content encryption key and nonce, derived from the IKM and the salt
+	cek, err := hkdfRead(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdfRead(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Single (and last) record: plaintext followed by the 0x02 delimiter
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+
+	// Header: salt (16) || record size (4) || key id length (1) || key id (as_public)
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(recordSize))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	body.Write(ciphertext)
+
+	return body.Bytes(), nil
+}
+
+func hkdfRead(secret, salt, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Browsers send the keys in base64url, but some libraries use the standard alphabet or padding
+func decodeKey(key string) ([]byte, error) {
+	for _, encoding := range []*base64.Encoding{
+		base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding,
+	} {
+		if raw, err := encoding.DecodeString(key); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, fmt.Errorf("key is not base64 encoded")
+}
@@ -0,0 +1,108 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+// VAPID key pair (RFC 8292), used to identify the application server to push services
+type VAPIDKeys struct {
+	PublicKey  string `json:"public_key"`  // Uncompressed P-256 point, base64url encoded
+	PrivateKey string `json:"private_key"` // P-256 scalar, base64url encoded
+
+	signingKey *ecdsa.PrivateKey
+}
+
+// Generate a new VAPID key pair
+func GenerateVAPIDKeys() (*VAPIDKeys, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseVAPIDKeys(base64.RawURLEncoding.EncodeToString(key.Bytes()))
+}
+
+// Parse the VAPID key pair from its base64url encoded private key
+func ParseVAPIDKeys(privateKey string) (*VAPIDKeys, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+
+	// Convert to ECDSA key for signing the JWT, the public key is 0x04 || X || Y
+	public := key.PublicKey().Bytes()
+	signingKey := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(public[1:33]),
+			Y:     new(big.Int).SetBytes(public[33:]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}
+
+	return &VAPIDKeys{
+		PublicKey:  base64.RawURLEncoding.EncodeToString(public),
+		PrivateKey: privateKey,
+		signingKey: signingKey,
+	}, nil
+}
+
+// Load the VAPID keys from config. If not configured, load the keys stored in path, or generate
+// and store a new pair there, so the keys stay the same across restarts (rotating the keys
+// invalidates every existing subscription).
+func LoadVAPIDKeys(privateKey, path string) (*VAPIDKeys, error) {
+	if privateKey != "" {
+		return ParseVAPIDKeys(privateKey)
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var stored VAPIDKeys
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("failed to parse VAPID keys file: %w", err)
+		}
+		return ParseVAPIDKeys(stored.PrivateKey)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	keys, err := GenerateVAPIDKeys()
+	if err != nil {
+		return nil, err
+	}
+	if err := keys.Save(path); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Save the key pair into a file only readable by the current user
+func (keys *VAPIDKeys) Save(path string) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
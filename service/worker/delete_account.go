@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/util"
+	"github.com/hibiken/asynq"
+)
+
+const DeleteAccount = "delete-account"
+
+// Payload of the delete account task
+type DeleteAccountPayload struct {
+	AccountID uint `json:"account_id"`
+}
+
+func (distributor *QueueTaskDistributor) DistributeTaskDeleteAccount(
+	ctx context.Context,
+	payload DeleteAccountPayload,
+	opts ...asynq.Option,
+) (err error) {
+	// Marshal payload
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return distributor.DistributeTask(ctx, DeleteAccount, data, opts...)
+}
+
+// Delete the account once its grace period is over. Its relations, devices, exports and unsent
+// attachments are deleted, and the account is anonymized. The messages it sent are either kept,
+// shown as sent by a deleted user, or purged, depending on the config
+func (processor *baseTaskProcessor) ProcessTaskDeleteAccount(ctx context.Context, task *asynq.Task) (err error) {
+	processor.logger.InfoContext(ctx, "Start processing task", "task name", DeleteAccount)
+
+	// Unmarshal payload
+	var payload DeleteAccountPayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	account, err := processor.queries.Accounts.GetByID(ctx, payload.AccountID)
+	if errors.Is(err, db.ErrNotFound) {
+		// Already deleted by a previous attempt
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The owner cancelled the deletion, or cancelled then requested it again later, in which
+	// case another task is scheduled
+	if account.DeletionScheduledAt == nil || account.DeletionScheduledAt.After(time.Now()) {
+		processor.logger.InfoContext(ctx, "Account deletion is not due, skip it", "account_id", account.ID)
+		return nil
+	}
+
+	var attachments []db.Attachment
+	err = processor.queries.Transaction(ctx, func(tx *db.Queries) error {
+		if err := tx.Contacts.DeleteByAccount(ctx, account.ID); err != nil {
+			return err
+		}
+		if err := tx.Blocks.DeleteByAccount(ctx, account.ID); err != nil {
+			return err
+		}
+		if err := tx.Mutes.DeleteByAccount(ctx, account.ID); err != nil {
+			return err
+		}
+		if err := tx.PushSubscriptions.DeleteByAccount(ctx, account.ID); err != nil {
+			return err
+		}
+		if err := tx.NotificationPreferences.Delete(ctx, account.ID); err != nil {
+			return err
+		}
+		if err := tx.DataExports.DeleteByAccount(ctx, account.ID); err != nil {
+			return err
+		}
+
+		unsent, err := tx.Attachments.DeleteUnsent(ctx, account.ID)
+		if err != nil {
+			return err
+		}
+		attachments = unsent
+
+		if processor.config.DeletedAccountMessages == util.DeletedMessagesPurge {
+			sent, err := tx.Messages.DeleteBySender(ctx, account.ID)
+			if err != nil {
+				return err
+			}
+			attachments = append(attachments, sent...)
+		}
+
+		if err := tx.Accounts.Erase(ctx, account.ID); err != nil {
+			return err
+		}
+
+		// Erasing revokes the tokens, the open connections are closed by the server
+		event, err := NewOutboxEvent(ctx, DeliverEvent, DeliverEventPayload{
+			AccountIDs:  []uint{account.ID},
+			Event:       pubsub.Event{Type: pubsub.AccountDeleted},
+			CloseReason: "account deleted",
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Outbox.Create(ctx, event)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The database no longer points to the files, a failure only leaves orphan files behind
+	id := strconv.FormatUint(uint64(account.ID), 10)
+	paths := []string{
+		filepath.Join(processor.config.StorageDir, "avatars", id),
+		filepath.Join(processor.config.StorageDir, "exports", id),
+	}
+	for _, attachment := range attachments {
+		if attachment.Path != "" {
+			paths = append(paths, filepath.Dir(attachment.Path))
+		}
+	}
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			processor.logger.WarnContext(ctx, "Failed to remove file of deleted account", "path", path, "error", err)
+		}
+	}
+
+	processor.logger.InfoContext(ctx, "Account deleted", "account_id", account.ID, "messages", processor.config.DeletedAccountMessages)
+
+	return nil
+}
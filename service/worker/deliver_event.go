@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/gorilla/websocket"
+	"github.com/hibiken/asynq"
+)
+
+const DeliverEvent = "deliver-event"
+
+// Payload of the deliver event task, used by the code which is not connected to the WebSocket
+// clients (worker processes, admin commands) to push an event to them
+type DeliverEventPayload struct {
+	AccountIDs []uint       `json:"account_ids"` // Recipients, every connected client if empty
+	Event      pubsub.Event `json:"event"`
+	// If set, the connections of the recipients are closed with this reason after the event
+	CloseReason string `json:"close_reason,omitempty"`
+}
+
+func (distributor *QueueTaskDistributor) DistributeTaskDeliverEvent(
+	ctx context.Context,
+	payload DeliverEventPayload,
+	opts ...asynq.Option,
+) (err error) {
+	// Marshal payload
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return distributor.DistributeTask(ctx, DeliverEvent, data, opts...)
+}
+
+func (processor *baseTaskProcessor) ProcessTaskDeliverEvent(ctx context.Context, task *asynq.Task) (err error) {
+	// Unmarshal payload
+	var payload DeliverEventPayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	recipients := payload.AccountIDs
+	if len(recipients) == 0 {
+		recipients = processor.hub.OnlineAccountIDs()
+	}
+
+	// Offline recipients are skipped, the event is only meaningful to the connected clients
+	delivered := 0
+	for _, accountID := range recipients {
+		online, err := processor.hub.SendTo(accountID, payload.Event)
+		if err != nil {
+			processor.logger.ErrorContext(ctx, "Failed to deliver event", "event", payload.Event.Type, "account_id", accountID, "error", err)
+		}
+		if online && err == nil {
+			delivered++
+		}
+		if online && payload.CloseReason != "" {
+			processor.hub.Disconnect(accountID, websocket.ClosePolicyViolation, payload.CloseReason)
+		}
+	}
+
+	processor.logger.InfoContext(ctx, "Event delivered", "event", payload.Event.Type, "recipients", len(recipients), "delivered", delivered)
+
+	return nil
+}
@@ -5,25 +5,90 @@ import (
 	"log/slog"
 
 	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/tracing"
 	"github.com/hibiken/asynq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Task distributor interface
 type TaskDistributor interface {
+	DistributeTask(ctx context.Context, taskType string, payload []byte, opts ...asynq.Option) (err error)
 	DistributeTaskSendMessage(ctx context.Context, payload db.Message, opts ...asynq.Option) (err error)
+	DistributeTaskProcessImage(ctx context.Context, payload ProcessImagePayload, opts ...asynq.Option) (err error)
+	DistributeTaskSendEmailDigest(ctx context.Context, payload EmailDigestPayload, opts ...asynq.Option) (err error)
+	DistributeTaskSendPushNotification(ctx context.Context, payload PushNotificationPayload, opts ...asynq.Option) (err error)
+	DistributeTaskDeliverEvent(ctx context.Context, payload DeliverEventPayload, opts ...asynq.Option) (err error)
+	DistributeTaskExpireDataExport(ctx context.Context, payload DataExportPayload, opts ...asynq.Option) (err error)
+	DistributeTaskDeleteAccount(ctx context.Context, payload DeleteAccountPayload, opts ...asynq.Option) (err error)
+	DistributeTaskSendModerationNotice(ctx context.Context, payload ModerationNoticePayload, opts ...asynq.Option) (err error)
 }
 
-// Redis task distributor
-type RedisTaskDistributor struct {
-	client *asynq.Client
+// Queue where the tasks are sent to, implemented by asynq.Client (Redis) and InMemoryBroker
+type TaskQueue interface {
+	EnqueueContext(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error)
+}
+
+// Task distributor, send tasks to a task queue
+type QueueTaskDistributor struct {
+	queue  TaskQueue
 	logger *slog.Logger
 }
 
 // Constructor method for Redis task distributor
 func NewRedisTaskDistributor(redisOpt asynq.RedisClientOpt, logger *slog.Logger) TaskDistributor {
 	client := asynq.NewClient(redisOpt)
-	return &RedisTaskDistributor{
-		client: client,
+	return &QueueTaskDistributor{
+		queue:  client,
 		logger: logger,
 	}
 }
+
+// Constructor method for in-memory task distributor, tasks are processed by the
+// InMemoryTaskProcessor sharing the same broker
+func NewInMemoryTaskDistributor(broker *InMemoryBroker, logger *slog.Logger) TaskDistributor {
+	return &QueueTaskDistributor{
+		queue:  broker,
+		logger: logger,
+	}
+}
+
+// Method to enqueue a task with an already encoded payload. The payload may carry the trace
+// context of its creator (outbox events), otherwise the trace context of ctx is added to it
+func (distributor *QueueTaskDistributor) DistributeTask(
+	ctx context.Context,
+	taskType string,
+	payload []byte,
+	opts ...asynq.Option,
+) (err error) {
+	ctx, payload = unwrapPayload(ctx, payload)
+	ctx, span := tracing.Tracer().Start(ctx, "enqueue "+taskType,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "asynq"),
+			attribute.String("messaging.operation", "publish"),
+			attribute.String("zola.task.type", taskType),
+		),
+	)
+	defer func() { endSpan(span, err) }()
+
+	// Create new task, continuing the trace of the enqueue span
+	data, err := wrapPayload(ctx, payload)
+	if err != nil {
+		return err
+	}
+	task := asynq.NewTask(taskType, data)
+
+	// Send task to the queue of its type, unless the caller picked one
+	opts = append([]asynq.Option{asynq.Queue(queueOf(taskType))}, opts...)
+	info, err := distributor.queue.EnqueueContext(ctx, task, opts...)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.String("messaging.destination.name", info.Queue), attribute.String("messaging.message.id", info.ID))
+
+	// Log task info
+	distributor.logger.InfoContext(ctx, "Task info", "task_name", taskType, "queue", info.Queue, "max_retry", info.MaxRetry)
+
+	return nil
+}
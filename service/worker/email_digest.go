@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/util"
+	"github.com/hibiken/asynq"
+)
+
+const SendEmailDigest = "send-email-digest"
+
+// Maximum number of characters of a message shown in the digest
+const digestPreviewLength = 100
+
+// Payload of the send email digest task
+type EmailDigestPayload struct {
+	AccountID uint `json:"account_id"`
+}
+
+func (distributor *QueueTaskDistributor) DistributeTaskSendEmailDigest(
+	ctx context.Context,
+	payload EmailDigestPayload,
+	opts ...asynq.Option,
+) (err error) {
+	// Marshal payload
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return distributor.DistributeTask(ctx, SendEmailDigest, data, opts...)
+}
+
+// Schedule an email digest for an offline account. The messages received in the same window,
+// as long as the digest delay, are batched into the same email since the task ID holds the
+// window start. A digest stuck in the queue, such as an archived one, only blocks its own window
+func (processor *baseTaskProcessor) scheduleEmailDigest(ctx context.Context, accountID uint) error {
+	if !processor.runtime.Get().FeatureEnabled(util.FeatureEmailDigest) {
+		return nil
+	}
+
+	pref, err := processor.queries.NotificationPreferences.Get(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	if !pref.EmailEnabled {
+		return nil
+	}
+
+	delay := processor.config.DigestDelay
+	if pref.DigestDelay > 0 {
+		delay = time.Duration(pref.DigestDelay) * time.Minute
+	}
+	now := time.Now()
+	processAt := pref.NextDeliveryTime(now.Add(delay))
+
+	err = processor.distributor.DistributeTaskSendEmailDigest(
+		ctx,
+		EmailDigestPayload{AccountID: accountID},
+		asynq.ProcessAt(processAt),
+		asynq.TaskID(fmt.Sprintf("%s:%d:%d", SendEmailDigest, accountID, now.Truncate(delay).Unix())),
+	)
+	if errors.Is(err, asynq.ErrTaskIDConflict) {
+		// A digest is already scheduled for this window, it will include this message
+		return nil
+	}
+
+	return err
+}
+
+func (processor *baseTaskProcessor) ProcessTaskSendEmailDigest(ctx context.Context, task *asynq.Task) (err error) {
+	processor.logger.InfoContext(ctx, "Start processing task", "task name", SendEmailDigest)
+
+	// Unmarshal payload
+	var payload EmailDigestPayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	// The feature may have been turned off after the digest was scheduled
+	if !processor.runtime.Get().FeatureEnabled(util.FeatureEmailDigest) {
+		return nil
+	}
+
+	// User came back online, they will see the messages in the app
+	if processor.hub.IsOnline(payload.AccountID) {
+		processor.logger.InfoContext(ctx, "Account is online, skip email digest", "account_id", payload.AccountID)
+		return nil
+	}
+
+	pref, err := processor.queries.NotificationPreferences.Get(ctx, payload.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if !pref.EmailEnabled {
+		return nil
+	}
+
+	// Quiet hours may have been changed after the digest was scheduled
+	now := time.Now()
+	if deliverAt := pref.NextDeliveryTime(now); deliverAt.After(now) {
+		err := processor.distributor.DistributeTaskSendEmailDigest(
+			ctx,
+			payload,
+			asynq.ProcessAt(deliverAt),
+			asynq.TaskID(fmt.Sprintf("%s:%d:%d", SendEmailDigest, payload.AccountID, deliverAt.Unix())),
+		)
+		if errors.Is(err, asynq.ErrTaskIDConflict) {
+			// The digest of the window starting then is already scheduled, it will include these messages
+			return nil
+		}
+		return err
+	}
+
+	account, err := processor.queries.Accounts.GetByID(ctx, payload.AccountID)
+	if err != nil {
+		return err
+	}
+
+	// Get unread private messages that were not included in previous digests
+	messages, err := processor.queries.Messages.ListUnread(ctx, payload.AccountID, pref.LastDigestAt)
+	if err != nil {
+		return err
+	}
+
+	// User already read everything
+	if len(messages) == 0 {
+		processor.logger.InfoContext(ctx, "No unread messages, skip email digest", "account_id", payload.AccountID)
+		return nil
+	}
+
+	subject, body := processor.buildEmailDigest(account, messages)
+	if err := processor.mailer.SendEmail(account.Email, subject, body); err != nil {
+		return err
+	}
+
+	// Remember where this digest stopped, so next digest won't repeat the same messages
+	pref.LastDigestAt = &messages[len(messages)-1].CreatedAt
+	if err := processor.queries.NotificationPreferences.Save(ctx, pref); err != nil {
+		return err
+	}
+
+	processor.logger.InfoContext(ctx, "Task completed successfully", "task name", SendEmailDigest)
+
+	return nil
+}
+
+// Build the subject and the plain text body of the digest, messages are grouped by sender
+func (processor *baseTaskProcessor) buildEmailDigest(account *db.Account, messages []db.Message) (string, string) {
+	var senders []uint
+	grouped := make(map[uint][]db.Message)
+	for _, message := range messages {
+		if _, ok := grouped[message.SenderID]; !ok {
+			senders = append(senders, message.SenderID)
+		}
+		grouped[message.SenderID] = append(grouped[message.SenderID], message)
+	}
+
+	subject := fmt.Sprintf("You have %d unread messages on Zola", len(messages))
+	if len(messages) == 1 {
+		subject = "You have 1 unread message on Zola"
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\n", account.DisplayName)
+	fmt.Fprintf(&body, "While you were away, you received %d messages from %d people.\n", len(messages), len(senders))
+	for _, senderID := range senders {
+		received := grouped[senderID]
+		fmt.Fprintf(&body, "\n%s (%d):\n", received[0].Sender.DisplayName, len(received))
+		for _, message := range received {
+			fmt.Fprintf(&body, "  - %s\n", preview(message.Content))
+		}
+	}
+	fmt.Fprintf(&body, "\nOpen Zola to reply: %s\n", processor.config.BaseURL)
+	body.WriteString("\nYou can change your notification settings in the app.\n")
+
+	return subject, body.String()
+}
+
+// Shorten the message content so it fits in the digest
+func preview(content string) string {
+	runes := []rune(strings.Join(strings.Fields(content), " "))
+	if len(runes) <= digestPreviewLength {
+		return string(runes)
+	}
+	return string(runes[:digestPreviewLength]) + "..."
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/danglnh07/zola/service/logging"
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/util"
+	"github.com/hibiken/asynq"
+)
+
+func TestScheduleEmailDigest(t *testing.T) {
+	ctx := context.Background()
+	queries := newTestQueries(t)
+	account := createTestAccount(t, queries, "bob")
+
+	config := &util.Config{Features: []string{util.FeatureEmailDigest}, DigestDelay: time.Hour}
+	logger := logging.NewLogger(io.Discard, "text", nil)
+	broker := NewInMemoryBroker(10)
+	defer broker.Close()
+	processor := &baseTaskProcessor{
+		queries:     queries,
+		hub:         pubsub.NewHub(),
+		distributor: NewInMemoryTaskDistributor(broker, logger),
+		runtime:     util.NewRuntimeStore(config, nil, logger),
+		config:      config,
+		logger:      logger,
+	}
+
+	// A digest of an earlier window which never completed, such as an archived one
+	stuck := fmt.Sprintf("%s:%d", SendEmailDigest, account.ID)
+	if _, err := broker.EnqueueContext(ctx, asynq.NewTask(SendEmailDigest, nil), asynq.TaskID(stuck), asynq.ProcessIn(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The messages of the same window share the digest
+	before := time.Now().Truncate(config.DigestDelay)
+	for range 3 {
+		if err := processor.scheduleEmailDigest(ctx, account.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after := time.Now().Truncate(config.DigestDelay)
+
+	stats, err := broker.QueueStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (metrics.QueueStat{Queue: QueueRealtime, State: "scheduled", Tasks: 1}); !slices.Contains(stats, want) {
+		t.Fatalf("got %v, want a single scheduled digest", stats)
+	}
+
+	// The task ID holds the window start, so scheduling the digest of the window again conflicts
+	conflicts := 0
+	for _, window := range []time.Time{before, after} {
+		taskID := fmt.Sprintf("%s:%d:%d", SendEmailDigest, account.ID, window.Unix())
+		_, err := broker.EnqueueContext(ctx, asynq.NewTask(SendEmailDigest, nil), asynq.TaskID(taskID), asynq.ProcessIn(time.Hour))
+		if errors.Is(err, asynq.ErrTaskIDConflict) {
+			conflicts++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conflicts == 0 {
+		t.Fatal("digest task ID doesn't hold the window start")
+	}
+}
@@ -0,0 +1,446 @@
+package worker
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/security"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	ExportData       = "export-data"
+	ExpireDataExport = "expire-data-export"
+)
+
+// Number of messages loaded at once while building the archive
+const exportPageSize = 500
+
+// Payload of the export data and expire data export tasks
+type DataExportPayload struct {
+	ExportID uint `json:"export_id"`
+}
+
+func (distributor *QueueTaskDistributor) DistributeTaskExpireDataExport(
+	ctx context.Context,
+	payload DataExportPayload,
+	opts ...asynq.Option,
+) (err error) {
+	// Marshal payload
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return distributor.DistributeTask(ctx, ExpireDataExport, data, opts...)
+}
+
+// Build the archive of the export, then email its download link to the account
+func (processor *baseTaskProcessor) ProcessTaskExportData(ctx context.Context, task *asynq.Task) (err error) {
+	processor.logger.InfoContext(ctx, "Start processing task", "task name", ExportData)
+
+	// Unmarshal payload
+	var payload DataExportPayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	// The export is deleted along with its account
+	export, err := processor.queries.DataExports.GetByID(ctx, payload.ExportID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if export.Status == db.DataExportFailed || export.Status == db.DataExportExpired {
+		return nil
+	}
+
+	if err := processor.exportData(ctx, export); err != nil {
+		// Only mark the export as failed if this is the last attempt
+		if isLastAttempt(ctx) {
+			processor.removeDataExport(ctx, export)
+			export.Status = db.DataExportFailed
+			if err := processor.queries.DataExports.Save(ctx, export); err != nil {
+				processor.logger.ErrorContext(ctx, "Failed to mark data export as failed", "export_id", export.ID, "error", err)
+			}
+		}
+		return err
+	}
+
+	processor.logger.InfoContext(ctx, "Task completed successfully", "task name", ExportData)
+
+	return nil
+}
+
+// Build the archive unless a previous attempt already did, then send a new download link. The
+// link of a previous attempt is replaced, since it may not have been sent
+func (processor *baseTaskProcessor) exportData(ctx context.Context, export *db.DataExport) error {
+	account, err := processor.queries.Accounts.GetByID(ctx, export.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if export.Status == db.DataExportPending {
+		if err := processor.buildDataExport(ctx, account, export); err != nil {
+			return err
+		}
+	}
+
+	token, hash, err := security.NewLinkToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(processor.config.ExportLinkExpiration)
+	export.Status = db.DataExportReady
+	export.TokenHash = &hash
+	export.ExpiresAt = &expiresAt
+	if err := processor.queries.DataExports.Save(ctx, export); err != nil {
+		return err
+	}
+
+	// Remove the archive once the link expires
+	err = processor.distributor.DistributeTaskExpireDataExport(
+		ctx,
+		DataExportPayload{ExportID: export.ID},
+		asynq.ProcessAt(expiresAt),
+		asynq.TaskID(fmt.Sprintf("%s:%d", ExpireDataExport, export.ID)),
+	)
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Hi %s,\n\nThe archive of your Zola data is ready. Download it from the link below:\n\n%s/api/exports/download?token=%s\n\n"+
+			"The link expires on %s, the archive is removed after that.\n\n"+
+			"If you didn't ask for it, someone may have access to your account: sign out of every device from the app.\n",
+		account.DisplayName, processor.config.BaseURL, token, expiresAt.UTC().Format("January 2, 2006 at 15:04 UTC"),
+	)
+	return processor.mailer.SendEmail(account.Email, "Your Zola data export is ready", body)
+}
+
+// Account data written to profile.json, with the fields hidden from the other accounts
+type exportProfile struct {
+	ID              uint                      `json:"id"`
+	Username        string                    `json:"username"`
+	DisplayName     string                    `json:"display_name"`
+	Handle          string                    `json:"handle"`
+	Email           string                    `json:"email"`
+	Bio             string                    `json:"bio"`
+	Timezone        string                    `json:"timezone"`
+	OauthProvider   string                    `json:"oauth_provider"`
+	OauthProviderID string                    `json:"oauth_provider_id"`
+	Role            db.Role                   `json:"role"`
+	EmailVisibility db.EmailVisibility        `json:"email_visibility"`
+	DMPrivacy       db.DMPrivacy              `json:"dm_privacy"`
+	BannedAt        *time.Time                `json:"banned_at,omitempty"`
+	BanReason       string                    `json:"ban_reason,omitempty"`
+	CreatedAt       time.Time                 `json:"created_at"`
+	Notifications   db.NotificationPreference `json:"notifications"`
+}
+
+// Sessions written to sessions.json. Tokens are not stored, they are all revoked at once by
+// bumping the token version, so the devices registered for push notifications are listed instead
+type exportSessions struct {
+	TokenVersion uint                  `json:"token_version"`
+	Devices      []db.PushSubscription `json:"devices"`
+}
+
+// Contacts and other relations written to relations.json, as account IDs
+type exportRelations struct {
+	Contacts         []db.Contact `json:"contacts"`
+	IncomingRequests []db.Contact `json:"incoming_requests"`
+	OutgoingRequests []db.Contact `json:"outgoing_requests"`
+	Blocked          []db.Block   `json:"blocked"`
+	Muted            []db.Mute    `json:"muted"`
+}
+
+// Message written to messages.json
+type exportMessage struct {
+	ID            uint        `json:"id"`
+	CreatedAt     time.Time   `json:"created_at"`
+	ChatType      db.ChatType `json:"chat_type"`
+	SenderID      uint        `json:"sender_id"`
+	ReceiverID    *uint       `json:"receiver_id,omitempty"`
+	Content       string      `json:"content"`
+	ReadAt        *time.Time  `json:"read_at,omitempty"`
+	AttachmentIDs []uint      `json:"attachment_ids,omitempty"`
+}
+
+// Write the archive of the personal data of the account, as JSON files along with the files of
+// its attachments. The archive is written to a temporary file first, so a failed attempt never
+// leaves a partial archive behind
+func (processor *baseTaskProcessor) buildDataExport(ctx context.Context, account *db.Account, export *db.DataExport) (err error) {
+	dir := filepath.Join(processor.config.StorageDir, "exports", strconv.FormatUint(uint64(account.ID), 10))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d.zip", export.ID))
+	file, err := os.CreateTemp(dir, "*.zip.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+
+	archive := zip.NewWriter(file)
+	if err := processor.writeDataExport(ctx, archive, account); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+
+	export.Path = path
+	export.Size = info.Size()
+	return nil
+}
+
+// Write every file of the archive
+func (processor *baseTaskProcessor) writeDataExport(ctx context.Context, archive *zip.Writer, account *db.Account) error {
+	queries := processor.queries
+
+	pref, err := queries.NotificationPreferences.Get(ctx, account.ID)
+	if err != nil {
+		return err
+	}
+	err = writeJSON(archive, "profile.json", exportProfile{
+		ID:              account.ID,
+		Username:        account.Username,
+		DisplayName:     account.DisplayName,
+		Handle:          account.Handle,
+		Email:           account.Email,
+		Bio:             account.Bio,
+		Timezone:        account.Timezone,
+		OauthProvider:   account.OauthProvider,
+		OauthProviderID: account.OauthProviderID,
+		Role:            account.Role,
+		EmailVisibility: account.EmailVisibility,
+		DMPrivacy:       account.DMPrivacy,
+		BannedAt:        account.BannedAt,
+		BanReason:       account.BanReason,
+		CreatedAt:       account.CreatedAt,
+		Notifications:   *pref,
+	})
+	if err != nil {
+		return err
+	}
+	if account.AvatarPath != "" {
+		if err := copyFile(archive, "avatar"+filepath.Ext(account.AvatarPath), account.AvatarPath); err != nil {
+			return err
+		}
+	}
+
+	devices, err := queries.PushSubscriptions.ListByAccount(ctx, account.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "sessions.json", exportSessions{TokenVersion: account.TokenVersion, Devices: devices}); err != nil {
+		return err
+	}
+
+	var relations exportRelations
+	if relations.Contacts, err = queries.Contacts.ListContacts(ctx, account.ID); err != nil {
+		return err
+	}
+	if relations.IncomingRequests, err = queries.Contacts.ListIncoming(ctx, account.ID); err != nil {
+		return err
+	}
+	if relations.OutgoingRequests, err = queries.Contacts.ListOutgoing(ctx, account.ID); err != nil {
+		return err
+	}
+	if relations.Blocked, err = queries.Blocks.ListByBlocker(ctx, account.ID); err != nil {
+		return err
+	}
+	if relations.Muted, err = queries.Mutes.ListByMuter(ctx, account.ID); err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "relations.json", relations); err != nil {
+		return err
+	}
+
+	if err := processor.writeExportMessages(ctx, archive, account.ID); err != nil {
+		return err
+	}
+
+	// Only the files uploaded by the account are included, the ones it received belong to their sender
+	attachments, err := queries.Attachments.ListByUploader(ctx, account.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "attachments.json", attachments); err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		if attachment.Path == "" {
+			continue
+		}
+		name := fmt.Sprintf("attachments/%d/%s", attachment.ID, filepath.Base(attachment.FileName))
+		if err := copyFile(archive, name, attachment.Path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write messages.json one page at a time, so the messages are never all loaded at once
+func (processor *baseTaskProcessor) writeExportMessages(ctx context.Context, archive *zip.Writer, accountID uint) error {
+	w, err := archive.Create("messages.json")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	var afterID uint
+	separator := "\n"
+	for {
+		messages, err := processor.queries.Messages.ListByAccount(ctx, accountID, afterID, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			exported := exportMessage{
+				ID:         message.ID,
+				CreatedAt:  message.CreatedAt,
+				ChatType:   message.ChatType,
+				SenderID:   message.SenderID,
+				ReceiverID: message.ReceiverID,
+				Content:    message.Content,
+				ReadAt:     message.ReadAt,
+			}
+			for _, attachment := range message.Attachments {
+				exported.AttachmentIDs = append(exported.AttachmentIDs, attachment.ID)
+			}
+
+			data, err := json.Marshal(exported)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, separator+"  "+string(data)); err != nil {
+				return err
+			}
+			separator = ",\n"
+		}
+
+		if len(messages) < exportPageSize {
+			break
+		}
+		afterID = messages[len(messages)-1].ID
+	}
+
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
+
+// Write the value as an indented JSON file of the archive
+func writeJSON(archive *zip.Writer, name string, value any) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// Copy a stored file into the archive, files removed from the storage are skipped
+func copyFile(archive *zip.Writer, name, path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// Remove the archive once its download link has expired
+func (processor *baseTaskProcessor) ProcessTaskExpireDataExport(ctx context.Context, task *asynq.Task) (err error) {
+	// Unmarshal payload
+	var payload DataExportPayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	export, err := processor.queries.DataExports.GetByID(ctx, payload.ExportID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if export.Status != db.DataExportReady {
+		return nil
+	}
+
+	// The link was replaced by a retry of the export after this task was scheduled
+	if export.ExpiresAt != nil && export.ExpiresAt.After(time.Now()) {
+		return processor.distributor.DistributeTaskExpireDataExport(
+			ctx,
+			payload,
+			asynq.ProcessAt(*export.ExpiresAt),
+			asynq.TaskID(fmt.Sprintf("%s:%d:%d", ExpireDataExport, export.ID, export.ExpiresAt.Unix())),
+		)
+	}
+
+	processor.removeDataExport(ctx, export)
+	export.Status = db.DataExportExpired
+	export.Path = ""
+	export.TokenHash = nil
+	if err := processor.queries.DataExports.Save(ctx, export); err != nil {
+		return err
+	}
+
+	processor.logger.InfoContext(ctx, "Data export expired", "export_id", export.ID, "account_id", export.AccountID)
+
+	return nil
+}
+
+// Remove the archive of the export, a failure only leaves an orphan file behind
+func (processor *baseTaskProcessor) removeDataExport(ctx context.Context, export *db.DataExport) {
+	if export.Path == "" {
+		return
+	}
+	if err := os.Remove(export.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		processor.logger.WarnContext(ctx, "Failed to remove data export", "path", export.Path, "error", err)
+	}
+}
@@ -0,0 +1,23 @@
+package worker
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Adapter writing the logs of the asynq server to our logger, instead of its own standard logger
+type asynqLogger struct {
+	logger *slog.Logger
+}
+
+func (l asynqLogger) Debug(args ...any) { l.logger.Debug(fmt.Sprint(args...), "component", "asynq") }
+func (l asynqLogger) Info(args ...any)  { l.logger.Info(fmt.Sprint(args...), "component", "asynq") }
+func (l asynqLogger) Warn(args ...any)  { l.logger.Warn(fmt.Sprint(args...), "component", "asynq") }
+func (l asynqLogger) Error(args ...any) { l.logger.Error(fmt.Sprint(args...), "component", "asynq") }
+
+// Asynq calls Fatal on unrecoverable errors and expects the process to exit
+func (l asynqLogger) Fatal(args ...any) {
+	l.logger.Error(fmt.Sprint(args...), "component", "asynq")
+	os.Exit(1)
+}
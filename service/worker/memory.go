@@ -0,0 +1,463 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/mail"
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/webpush"
+	"github.com/danglnh07/zola/util"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+)
+
+// Same defaults as asynq, so both backends behave the same
+const (
+	defaultMaxRetry    = 25
+	defaultTaskTimeout = 30 * time.Minute
+)
+
+// Returned when the in-memory queue is full, the caller should retry later
+var ErrQueueFull = errors.New("task queue is full")
+
+// Returned when enqueuing after the broker has been closed
+var ErrBrokerClosed = errors.New("task broker is closed")
+
+// Task waiting in the in-memory queue, along with its options
+type memoryTask struct {
+	task      *asynq.Task
+	id        string
+	queue     string
+	uniqueKey string
+	maxRetry  int
+	retried   int
+	timeout   time.Duration
+	deadline  time.Time
+	retention time.Duration
+}
+
+// In-memory broker, an implementation of TaskQueue that keeps the tasks in a bounded
+// channel instead of Redis. Tasks are lost when the process exits, so this is meant for
+// tests and single binary deployments.
+type InMemoryBroker struct {
+	tasks chan *memoryTask
+
+	mutex   sync.Mutex
+	taskIDs map[string]struct{}    // IDs of tasks that are pending, or completed and retained
+	unique  map[string]time.Time   // Unique keys and their expiration
+	depths  map[string]*queueDepth // Number of tasks of each queue, since every queue shares the channel
+	closed  bool
+	done    chan struct{}
+}
+
+// Number of tasks of a queue ready to be processed, and waiting in a timer (scheduled or waiting for a retry)
+type queueDepth struct {
+	pending   int
+	scheduled int
+}
+
+// Constructor method for InMemoryBroker, capacity is the maximum number of tasks ready to be processed
+func NewInMemoryBroker(capacity int) *InMemoryBroker {
+	return &InMemoryBroker{
+		tasks:   make(chan *memoryTask, capacity),
+		taskIDs: make(map[string]struct{}),
+		unique:  make(map[string]time.Time),
+		depths: map[string]*queueDepth{
+			QueueRealtime: {},
+			QueueDefault:  {},
+		},
+		done: make(chan struct{}),
+	}
+}
+
+// Method to enqueue a task, following the same options as asynq.Client
+func (broker *InMemoryBroker) EnqueueContext(
+	ctx context.Context,
+	task *asynq.Task,
+	opts ...asynq.Option,
+) (*asynq.TaskInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mt := &memoryTask{
+		task:     task,
+		id:       uuid.NewString(),
+		queue:    QueueDefault,
+		maxRetry: defaultMaxRetry,
+		timeout:  defaultTaskTimeout,
+	}
+	processAt := time.Now()
+	var uniqueTTL time.Duration
+
+	for _, opt := range opts {
+		switch opt.Type() {
+		case asynq.MaxRetryOpt:
+			mt.maxRetry = max(0, opt.Value().(int))
+		case asynq.QueueOpt:
+			mt.queue = opt.Value().(string)
+		case asynq.TaskIDOpt:
+			mt.id = opt.Value().(string)
+		case asynq.TimeoutOpt:
+			mt.timeout = opt.Value().(time.Duration)
+		case asynq.DeadlineOpt:
+			mt.deadline = opt.Value().(time.Time)
+		case asynq.UniqueOpt:
+			uniqueTTL = opt.Value().(time.Duration)
+		case asynq.ProcessAtOpt:
+			processAt = opt.Value().(time.Time)
+		case asynq.ProcessInOpt:
+			processAt = time.Now().Add(opt.Value().(time.Duration))
+		case asynq.RetentionOpt:
+			mt.retention = opt.Value().(time.Duration)
+		}
+	}
+
+	broker.mutex.Lock()
+	if broker.closed {
+		broker.mutex.Unlock()
+		return nil, ErrBrokerClosed
+	}
+	if _, ok := broker.taskIDs[mt.id]; ok {
+		broker.mutex.Unlock()
+		return nil, asynq.ErrTaskIDConflict
+	}
+	if uniqueTTL > 0 {
+		sum := sha256.Sum256(task.Payload())
+		mt.uniqueKey = fmt.Sprintf("%s:%s:%s", mt.queue, task.Type(), hex.EncodeToString(sum[:]))
+		if expiration, ok := broker.unique[mt.uniqueKey]; ok && time.Now().Before(expiration) {
+			broker.mutex.Unlock()
+			return nil, asynq.ErrDuplicateTask
+		}
+		broker.unique[mt.uniqueKey] = time.Now().Add(uniqueTTL)
+	}
+	broker.taskIDs[mt.id] = struct{}{}
+	broker.mutex.Unlock()
+
+	info := &asynq.TaskInfo{
+		ID:            mt.id,
+		Queue:         mt.queue,
+		Type:          task.Type(),
+		Payload:       task.Payload(),
+		State:         asynq.TaskStatePending,
+		MaxRetry:      mt.maxRetry,
+		Timeout:       mt.timeout,
+		Deadline:      mt.deadline,
+		NextProcessAt: processAt,
+		Retention:     mt.retention,
+	}
+
+	// Scheduled tasks wait in a timer until they're ready
+	if delay := time.Until(processAt); delay > 0 {
+		info.State = asynq.TaskStateScheduled
+		broker.schedule(mt, delay)
+		return info, nil
+	}
+
+	// Ready tasks are rejected when the queue is full, so the caller get back-pressure
+	broker.count(mt.queue, 1, 0)
+	select {
+	case broker.tasks <- mt:
+		return info, nil
+	default:
+		broker.count(mt.queue, -1, 0)
+		broker.release(mt)
+		return nil, ErrQueueFull
+	}
+}
+
+// Push the task into the queue after the delay, used by scheduled and retried tasks
+func (broker *InMemoryBroker) schedule(mt *memoryTask, delay time.Duration) {
+	broker.count(mt.queue, 0, 1)
+	time.AfterFunc(delay, func() {
+		broker.count(mt.queue, 0, -1)
+		broker.push(mt)
+	})
+}
+
+// Push a scheduled or retried task into the queue, wait if the queue is full
+func (broker *InMemoryBroker) push(mt *memoryTask) {
+	broker.count(mt.queue, 1, 0)
+	select {
+	case broker.tasks <- mt:
+	case <-broker.done:
+		broker.count(mt.queue, -1, 0)
+	}
+}
+
+// Take a task out of the queue, to be processed
+func (broker *InMemoryBroker) take(mt *memoryTask) {
+	broker.count(mt.queue, -1, 0)
+}
+
+// Update the number of pending and scheduled tasks of the queue
+func (broker *InMemoryBroker) count(queue string, pending, scheduled int) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	depth, ok := broker.depths[queue]
+	if !ok {
+		depth = &queueDepth{}
+		broker.depths[queue] = depth
+	}
+	depth.pending += pending
+	depth.scheduled += scheduled
+}
+
+// Forget the task, so a task with the same ID or unique key can be enqueued again
+func (broker *InMemoryBroker) release(mt *memoryTask) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	delete(broker.taskIDs, mt.id)
+	if mt.uniqueKey != "" {
+		delete(broker.unique, mt.uniqueKey)
+	}
+}
+
+// Called when a task is done (completed or archived)
+func (broker *InMemoryBroker) finish(mt *memoryTask) {
+	if mt.retention <= 0 {
+		broker.release(mt)
+		return
+	}
+	time.AfterFunc(mt.retention, func() { broker.release(mt) })
+}
+
+// Method to stop accepting new tasks, tasks left in the queue are dropped
+func (broker *InMemoryBroker) Close() {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	if !broker.closed {
+		broker.closed = true
+		close(broker.done)
+	}
+}
+
+// Number of tasks ready to be processed
+func (broker *InMemoryBroker) Len() int {
+	return len(broker.tasks)
+}
+
+// Method to get the number of tasks ready to be processed and waiting in a timer, by queue
+func (broker *InMemoryBroker) QueueStats() ([]metrics.QueueStat, error) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	queues := slices.Sorted(maps.Keys(broker.depths))
+	stats := make([]metrics.QueueStat, 0, 2*len(queues))
+	for _, queue := range queues {
+		depth := broker.depths[queue]
+		stats = append(stats,
+			metrics.QueueStat{Queue: queue, State: "pending", Tasks: depth.pending},
+			metrics.QueueStat{Queue: queue, State: "scheduled", Tasks: depth.scheduled},
+		)
+	}
+	return stats, nil
+}
+
+// Context keys used to expose the retry info to handlers, like asynq does
+type retryContextKey struct{}
+
+type retryInfo struct {
+	retried  int
+	maxRetry int
+}
+
+// Get the number of times the task being processed has been retried, for both asynq and in-memory tasks
+func retryCount(ctx context.Context) int {
+	if retried, ok := asynq.GetRetryCount(ctx); ok {
+		return retried
+	}
+
+	if info, ok := ctx.Value(retryContextKey{}).(retryInfo); ok {
+		return info.retried
+	}
+
+	return 0
+}
+
+// Check if this is the last attempt of the task being processed, for both asynq and in-memory tasks
+func isLastAttempt(ctx context.Context) bool {
+	if retried, ok := asynq.GetRetryCount(ctx); ok {
+		maxRetry, _ := asynq.GetMaxRetry(ctx)
+		return retried >= maxRetry
+	}
+
+	if info, ok := ctx.Value(retryContextKey{}).(retryInfo); ok {
+		return info.retried >= info.maxRetry
+	}
+
+	return true
+}
+
+// Same delay as asynq.DefaultRetryDelayFunc: n^4 + 15 + random(0, 30 * (n + 1)) seconds
+func retryDelay(retried int) time.Duration {
+	seconds := int(math.Pow(float64(retried), 4)) + 15 + rand.IntN(30*(retried+1))
+	return time.Duration(seconds) * time.Second
+}
+
+// In-memory task processor, process the tasks of an InMemoryBroker with a pool of worker goroutines
+type InMemoryTaskProcessor struct {
+	*baseTaskProcessor
+	broker      *InMemoryBroker
+	concurrency int
+	workers     atomic.Int64 // Worker goroutines currently running
+	wg          sync.WaitGroup
+	quit        chan struct{}
+	ctx         context.Context // Parent context of the tasks, cancelled when the shutdown timeout is reached
+	cancel      context.CancelFunc
+}
+
+// Constructor method for in-memory task processor
+func NewInMemoryTaskProcessor(
+	broker *InMemoryBroker,
+	concurrency int,
+	queries *db.Queries,
+	hub *pubsub.Hub,
+	distributor TaskDistributor,
+	mailer mail.Mailer,
+	pusher *webpush.Client,
+	runtime *util.RuntimeStore,
+	logger *slog.Logger,
+) TaskProcessor {
+	return &InMemoryTaskProcessor{
+		baseTaskProcessor: &baseTaskProcessor{
+			queries:     queries,
+			hub:         hub,
+			distributor: distributor,
+			mailer:      mailer,
+			pusher:      pusher,
+			runtime:     runtime,
+			config:      runtime.Get(),
+			logger:      logger,
+		},
+		broker:      broker,
+		concurrency: max(1, concurrency),
+		quit:        make(chan struct{}),
+	}
+}
+
+// Method to start the worker goroutines, it returns immediately like asynq.Server.Start
+func (processor *InMemoryTaskProcessor) Start() error {
+	mux := processor.newServeMux()
+	processor.ctx, processor.cancel = context.WithCancel(context.Background())
+
+	for range processor.concurrency {
+		processor.wg.Add(1)
+		processor.workers.Add(1)
+		go func() {
+			defer processor.wg.Done()
+			defer processor.workers.Add(-1)
+			for {
+				select {
+				case <-processor.quit:
+					return
+				case <-processor.broker.done:
+					return
+				case mt := <-processor.broker.tasks:
+					processor.broker.take(mt)
+					processor.process(mux, mt)
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Method to stop picking new tasks and wait for active tasks to complete. Active tasks are
+// cancelled once the shutdown timeout is reached. Tasks left in the queue are dropped, since
+// nothing would process them anymore
+func (processor *InMemoryTaskProcessor) Shutdown() {
+	close(processor.quit)
+
+	done := make(chan struct{})
+	go func() {
+		processor.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(processor.config.ShutdownTimeout):
+		processor.logger.Warn("Shutdown timeout reached, cancelling active tasks")
+		processor.cancel()
+		<-done
+	}
+
+	if processor.cancel != nil {
+		processor.cancel()
+	}
+	processor.broker.Close()
+	if dropped := processor.broker.Len(); dropped > 0 {
+		processor.logger.Warn("Dropped pending tasks of the in-memory queue", "count", dropped)
+	}
+}
+
+// Method to check that the worker goroutines are running
+func (processor *InMemoryTaskProcessor) Ping() error {
+	if processor.workers.Load() == 0 {
+		return ErrProcessorNotRunning
+	}
+	return nil
+}
+
+// Process a task, then schedule a retry or archive it if it failed
+func (processor *InMemoryTaskProcessor) process(mux *asynq.ServeMux, mt *memoryTask) {
+	ctx := context.WithValue(processor.ctx, retryContextKey{}, retryInfo{
+		retried:  mt.retried,
+		maxRetry: mt.maxRetry,
+	})
+
+	var cancel context.CancelFunc
+	if !mt.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, mt.deadline)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, mt.timeout)
+	}
+	defer cancel()
+
+	err := processor.handle(ctx, mux, mt.task)
+	if err == nil {
+		processor.broker.finish(mt)
+		return
+	}
+
+	if errors.Is(err, asynq.SkipRetry) || mt.retried >= mt.maxRetry {
+		processor.logger.ErrorContext(ctx, "Task failed, archived", "task_name", mt.task.Type(), "id", mt.id, "retried", mt.retried, "error", err)
+		processor.broker.finish(mt)
+		return
+	}
+
+	delay := retryDelay(mt.retried)
+	mt.retried++
+	processor.logger.ErrorContext(ctx, "Task failed, will be retried", "task_name", mt.task.Type(), "id", mt.id, "retry_in", delay, "error", err)
+	processor.broker.schedule(mt, delay)
+}
+
+// Call the handler, a panic is turned into an error so the worker goroutine survives
+func (processor *InMemoryTaskProcessor) handle(ctx context.Context, mux *asynq.ServeMux, task *asynq.Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing task: %v", r)
+		}
+	}()
+
+	return mux.ProcessTask(ctx, task)
+}
@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/hibiken/asynq"
+)
+
+func TestInMemoryBrokerQueueStats(t *testing.T) {
+	broker := NewInMemoryBroker(10)
+	defer broker.Close()
+	ctx := context.Background()
+
+	enqueue := func(opts ...asynq.Option) {
+		t.Helper()
+		if _, err := broker.EnqueueContext(ctx, asynq.NewTask("test", nil), opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enqueue(asynq.Queue(QueueRealtime))
+	enqueue(asynq.Queue(QueueRealtime))
+	enqueue()
+	enqueue(asynq.Queue(QueueRealtime), asynq.ProcessIn(time.Hour))
+
+	stats, err := broker.QueueStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []metrics.QueueStat{
+		{Queue: QueueDefault, State: "pending", Tasks: 1},
+		{Queue: QueueDefault, State: "scheduled", Tasks: 0},
+		{Queue: QueueRealtime, State: "pending", Tasks: 2},
+		{Queue: QueueRealtime, State: "scheduled", Tasks: 1},
+	}
+	if !slices.Equal(stats, want) {
+		t.Fatalf("got %v, want %v", stats, want)
+	}
+
+	// Taking a task out of the channel removes it from its own queue
+	broker.take(<-broker.tasks)
+	stats, _ = broker.QueueStats()
+	if stats[2].Tasks != 1 {
+		t.Fatalf("got %d pending realtime tasks, want 1", stats[2].Tasks)
+	}
+}
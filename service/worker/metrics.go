@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/hibiken/asynq"
+)
+
+// Task middleware recording the processing time and retries of every task
+func taskMetricsMiddleware(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		if retryCount(ctx) > 0 {
+			metrics.TaskRetries.WithLabelValues(task.Type()).Inc()
+		}
+
+		start := time.Now()
+		err := next.ProcessTask(ctx, task)
+
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		metrics.TaskDuration.WithLabelValues(task.Type(), result).Observe(time.Since(start).Seconds())
+
+		return err
+	})
+}
+
+// Get the number of tasks of every Redis queue, by state
+func RedisQueueStats(inspector *asynq.Inspector) func() ([]metrics.QueueStat, error) {
+	return func() ([]metrics.QueueStat, error) {
+		queues, err := inspector.Queues()
+		if err != nil {
+			return nil, err
+		}
+
+		var stats []metrics.QueueStat
+		for _, queue := range queues {
+			info, err := inspector.GetQueueInfo(queue)
+			if err != nil {
+				return nil, err
+			}
+			stats = append(stats,
+				metrics.QueueStat{Queue: queue, State: "pending", Tasks: info.Pending},
+				metrics.QueueStat{Queue: queue, State: "active", Tasks: info.Active},
+				metrics.QueueStat{Queue: queue, State: "scheduled", Tasks: info.Scheduled},
+				metrics.QueueStat{Queue: queue, State: "retry", Tasks: info.Retry},
+				metrics.QueueStat{Queue: queue, State: "archived", Tasks: info.Archived},
+			)
+		}
+		return stats, nil
+	}
+}
@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/hibiken/asynq"
+)
+
+const SendModerationNotice = "send-moderation-notice"
+
+// Payload of the send moderation notice task
+type ModerationNoticePayload struct {
+	ActionID uint `json:"action_id"`
+}
+
+func (distributor *QueueTaskDistributor) DistributeTaskSendModerationNotice(
+	ctx context.Context,
+	payload ModerationNoticePayload,
+	opts ...asynq.Option,
+) (err error) {
+	// Marshal payload
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return distributor.DistributeTask(ctx, SendModerationNotice, data, opts...)
+}
+
+// Email the account about a warning, a mute or a ban, so it's told even if it's not connected
+func (processor *baseTaskProcessor) ProcessTaskSendModerationNotice(ctx context.Context, task *asynq.Task) (err error) {
+	processor.logger.InfoContext(ctx, "Start processing task", "task name", SendModerationNotice)
+
+	// Unmarshal payload
+	var payload ModerationNoticePayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	action, err := processor.queries.ModerationActions.GetByID(ctx, payload.ActionID)
+	if err != nil {
+		return err
+	}
+
+	// The account may have been deleted since
+	account, err := processor.queries.Accounts.GetByID(ctx, action.AccountID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if account.Email == "" {
+		return nil
+	}
+
+	reason := action.Reason
+	if reason == "" {
+		reason = "No reason was given."
+	}
+
+	var subject, notice string
+	switch action.Action {
+	case db.ModerationWarn:
+		subject = "You received a warning on Zola"
+		notice = "A moderator sent you a warning. Further violations of the rules may get your account muted or banned."
+	case db.ModerationMute:
+		if action.ExpiresAt == nil {
+			return fmt.Errorf("mute action %d has no end: %w", action.ID, asynq.SkipRetry)
+		}
+		subject = "Your Zola account is muted"
+		notice = fmt.Sprintf("A moderator muted your account, you can't send messages until %s.",
+			action.ExpiresAt.UTC().Format("January 2, 2006 at 15:04 UTC"))
+	case db.ModerationBan:
+		subject = "Your Zola account is banned"
+		notice = "A moderator banned your account, you can't sign in anymore."
+	default:
+		// The other actions are not notified
+		return nil
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\n%s\n\nReason: %s\n", account.DisplayName, notice, reason)
+	return processor.mailer.SendEmail(account.Email, subject, body)
+}
@@ -0,0 +1,198 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	// How often the relay looks for pending outbox events
+	outboxPollInterval = 500 * time.Millisecond
+
+	// Maximum number of events relayed in one transaction
+	outboxBatchSize = 100
+
+	// How long the relayed tasks are kept after completion. While the task is kept, relaying
+	// the same event again is rejected by asynq, so a relay crashing between enqueue and commit
+	// does not deliver the task twice
+	outboxTaskRetention = 24 * time.Hour
+
+	// How often the relay does its maintenance: it looks for the account deletions which are
+	// due, and deletes the old dispatched events. The deletion task is scheduled when the deletion
+	// is requested, but a task of the in-memory backend is lost on restart and a Redis queue can
+	// be flushed, so the database is the source of truth
+	maintenanceInterval = time.Hour
+
+	// Maximum number of account deletions enqueued by one sweep, the rest wait for the next one
+	deletionSweepBatchSize = 100
+
+	// How long the dispatched events are kept, for troubleshooting, before they're deleted
+	outboxEventRetention = 7 * 24 * time.Hour
+
+	// Maximum number of dispatched events deleted in one statement, to keep the locks short
+	outboxPruneBatchSize = 1000
+)
+
+// Build an outbox event for the task. It should be created in the same transaction as the data.
+// The trace context of ctx is stored with the payload, so the trace continues once relayed
+func NewOutboxEvent(ctx context.Context, taskType string, payload any) (*db.OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = wrapPayload(ctx, data); err != nil {
+		return nil, err
+	}
+
+	return &db.OutboxEvent{
+		TaskType: taskType,
+		Payload:  data,
+	}, nil
+}
+
+// Same as NewOutboxEvent, but the task is only processed at the given time
+func NewScheduledOutboxEvent(ctx context.Context, taskType string, payload any, processAt time.Time) (*db.OutboxEvent, error) {
+	event, err := NewOutboxEvent(ctx, taskType, payload)
+	if err != nil {
+		return nil, err
+	}
+	event.ProcessAt = &processAt
+	return event, nil
+}
+
+// Outbox relay, move pending outbox events into the task queue with at-least-once semantics.
+// Events are claimed with FOR UPDATE SKIP LOCKED, so several relays can run at the same time
+type OutboxRelay struct {
+	queries     *db.Queries
+	distributor TaskDistributor
+	logger      *slog.Logger
+}
+
+// Constructor method for OutboxRelay
+func NewOutboxRelay(queries *db.Queries, distributor TaskDistributor, logger *slog.Logger) *OutboxRelay {
+	return &OutboxRelay{
+		queries:     queries,
+		distributor: distributor,
+		logger:      logger,
+	}
+}
+
+// Method to run the relay until the context is cancelled
+func (relay *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	// The first maintenance runs on start, to pick up the tasks lost by a restart
+	var nextMaintenance time.Time
+	for {
+		if now := time.Now(); !now.Before(nextMaintenance) {
+			if _, err := relay.SweepAccountDeletions(ctx, now); err != nil && ctx.Err() == nil {
+				relay.logger.ErrorContext(ctx, "Failed to sweep account deletions", "error", err)
+			}
+			if _, err := relay.PruneDispatched(ctx, now); err != nil && ctx.Err() == nil {
+				relay.logger.ErrorContext(ctx, "Failed to prune dispatched outbox events", "error", err)
+			}
+			nextMaintenance = now.Add(maintenanceInterval)
+		}
+
+		// Keep relaying while there are full batches, to catch up quickly after an outage
+		for {
+			relayed, err := relay.RelayBatch(ctx)
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				relay.logger.ErrorContext(ctx, "Failed to relay outbox events", "error", err)
+				break
+			}
+			if relayed < outboxBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Method to relay one batch of pending events, return the number of events dispatched
+func (relay *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	// Pending events locked by another relay are skipped
+	return relay.queries.Outbox.Relay(ctx, outboxBatchSize, func(event *db.OutboxEvent) error {
+		opts := []asynq.Option{
+			asynq.TaskID(fmt.Sprintf("outbox:%d", event.ID)),
+			asynq.Retention(outboxTaskRetention),
+		}
+		if event.ProcessAt != nil {
+			opts = append(opts, asynq.ProcessAt(*event.ProcessAt))
+		}
+
+		err := relay.distributor.DistributeTask(ctx, event.TaskType, event.Payload, opts...)
+
+		// The event was already enqueued, but the relay failed to mark it as dispatched
+		if errors.Is(err, asynq.ErrTaskIDConflict) {
+			return nil
+		}
+
+		if err != nil {
+			relay.logger.ErrorContext(ctx, "Failed to enqueue outbox event", "id", event.ID, "task_type", event.TaskType, "error", err)
+		}
+		return err
+	})
+}
+
+// Method to enqueue the deletion of the accounts whose grace period is over, return the number
+// of deletions enqueued. The task ID holds the sweep window, so an account is enqueued at most
+// once per window, even by several relays, and a task which never completed doesn't block the
+// next windows. A deletion enqueued twice is skipped by the worker once the account is erased
+func (relay *OutboxRelay) SweepAccountDeletions(ctx context.Context, now time.Time) (int, error) {
+	ids, err := relay.queries.Accounts.ListDueDeletions(ctx, now, deletionSweepBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	window := now.Truncate(maintenanceInterval).Unix()
+	enqueued := 0
+	for _, id := range ids {
+		err := relay.distributor.DistributeTaskDeleteAccount(ctx, DeleteAccountPayload{AccountID: id},
+			asynq.TaskID(fmt.Sprintf("%s:%d:%d", DeleteAccount, id, window)))
+		if errors.Is(err, asynq.ErrTaskIDConflict) {
+			continue
+		}
+		if err != nil {
+			return enqueued, err
+		}
+		enqueued++
+	}
+
+	if enqueued > 0 {
+		relay.logger.InfoContext(ctx, "Account deletions enqueued", "count", enqueued)
+	}
+	return enqueued, nil
+}
+
+// Method to delete the events dispatched before the retention period, return the number of
+// events deleted
+func (relay *OutboxRelay) PruneDispatched(ctx context.Context, now time.Time) (int64, error) {
+	var pruned int64
+	for {
+		deleted, err := relay.queries.Outbox.DeleteDispatched(ctx, now.Add(-outboxEventRetention), outboxPruneBatchSize)
+		pruned += deleted
+		if err != nil || deleted < outboxPruneBatchSize {
+			if pruned > 0 {
+				relay.logger.InfoContext(ctx, "Dispatched outbox events pruned", "count", pruned)
+			}
+			return pruned, err
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/danglnh07/zola/service/logging"
+)
+
+// The deletions which are due are enqueued again from the database, such as after a restart
+// of the in-memory backend lost the scheduled tasks
+func TestSweepAccountDeletions(t *testing.T) {
+	ctx := context.Background()
+	queries := newTestQueries(t)
+	due := createTestAccount(t, queries, "alice")
+	later := createTestAccount(t, queries, "bob")
+	createTestAccount(t, queries, "carol")
+
+	past, future := time.Now().Add(-time.Minute).UTC(), time.Now().Add(time.Hour)
+	if err := queries.Accounts.ScheduleDeletion(ctx, due.ID, &past); err != nil {
+		t.Fatal(err)
+	}
+	if err := queries.Accounts.ScheduleDeletion(ctx, later.ID, &future); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logging.NewLogger(io.Discard, "text", nil)
+	broker := NewInMemoryBroker(10)
+	defer broker.Close()
+	relay := NewOutboxRelay(queries, NewInMemoryTaskDistributor(broker, logger), logger)
+
+	// The same window doesn't enqueue the deletion twice
+	now := time.Now()
+	for _, want := range []int{1, 0} {
+		enqueued, err := relay.SweepAccountDeletions(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enqueued != want {
+			t.Fatalf("enqueued %d deletions, want %d", enqueued, want)
+		}
+	}
+
+	stats, err := broker.QueueStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := 0
+	for _, stat := range stats {
+		if stat.State == "pending" {
+			pending += stat.Tasks
+		}
+	}
+	if pending != 1 {
+		t.Fatalf("got %d pending tasks, want 1", pending)
+	}
+}
@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/media"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/hibiken/asynq"
+)
+
+const ProcessImage = "process-image"
+
+// Payload of the process image task
+type ProcessImagePayload struct {
+	AttachmentID uint `json:"attachment_id"`
+}
+
+func (distributor *QueueTaskDistributor) DistributeTaskProcessImage(
+	ctx context.Context,
+	payload ProcessImagePayload,
+	opts ...asynq.Option,
+) (err error) {
+	// Marshal payload
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return distributor.DistributeTask(ctx, ProcessImage, data, opts...)
+}
+
+func (processor *baseTaskProcessor) ProcessTaskProcessImage(ctx context.Context, task *asynq.Task) (err error) {
+	processor.logger.InfoContext(ctx, "Start processing task", "task name", ProcessImage)
+
+	// Unmarshal payload
+	var payload ProcessImagePayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	attachment, err := processor.queries.Attachments.GetByID(ctx, payload.AttachmentID)
+	if err != nil {
+		return err
+	}
+
+	// The task may be retried after the attachment has been processed
+	if attachment.Status == db.AttachmentReady {
+		return nil
+	}
+
+	if err := processor.processImage(ctx, attachment); err != nil {
+		// Only mark the attachment as failed if this is the last attempt, or if it can't succeed
+		// such as when the image is too large
+		if isLastAttempt(ctx) || errors.Is(err, asynq.SkipRetry) {
+			if err := processor.queries.Attachments.MarkFailed(ctx, attachment.ID); err != nil {
+				processor.logger.ErrorContext(ctx, "Failed to mark attachment as failed", "attachment_id", attachment.ID, "error", err)
+			}
+		}
+		return err
+	}
+
+	// Let the clients know that the attachment can be rendered. The image may be processed by
+	// a worker process, so the event is delivered by the process the clients are connected to
+	err = processor.distributor.DistributeTaskDeliverEvent(ctx, DeliverEventPayload{
+		AccountIDs: attachmentAudience(attachment),
+		Event:      pubsub.Event{Type: pubsub.AttachmentReady, Payload: attachment},
+	})
+	if err != nil {
+		processor.logger.ErrorContext(ctx, "Failed to send attachment ready event", "attachment_id", attachment.ID, "error", err)
+	}
+
+	processor.logger.InfoContext(ctx, "Task completed successfully", "task name", ProcessImage)
+
+	return nil
+}
+
+// Generate thumbnails, strip metadata and compute the placeholder of an attachment, then
+// save the result into the database
+func (processor *baseTaskProcessor) processImage(ctx context.Context, attachment *db.Attachment) error {
+	data, err := os.ReadFile(attachment.Path)
+	if err != nil {
+		return err
+	}
+
+	img, err := media.Decode(data, processor.config.MaxImagePixels)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w: %w", err, asynq.SkipRetry)
+	}
+
+	// Overwrite the original file with a re-encoded version, which strip EXIF metadata.
+	// GIF cannot hold EXIF data and re-encoding would drop every frame except the first.
+	if img.Format != "gif" {
+		var buf bytes.Buffer
+		if err := img.Encode(&buf); err != nil {
+			return err
+		}
+		if err := os.WriteFile(attachment.Path, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		attachment.Size = int64(buf.Len())
+	}
+
+	// Generate thumbnails, skip the sizes which are not smaller than the original
+	var thumbnails []db.AttachmentThumbnail
+	dir := filepath.Dir(attachment.Path)
+	for _, size := range media.ThumbnailSizes {
+		if img.Width() <= size && img.Height() <= size {
+			continue
+		}
+
+		thumbnail := img.Thumbnail(size)
+		path := filepath.Join(dir, fmt.Sprintf("thumbnail_%d.%s", size, thumbnail.Extension()))
+
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = thumbnail.Encode(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+
+		thumbnails = append(thumbnails, db.AttachmentThumbnail{
+			AttachmentID: attachment.ID,
+			Size:         size,
+			Width:        thumbnail.Width(),
+			Height:       thumbnail.Height(),
+			Path:         path,
+		})
+	}
+
+	placeholder, err := img.Placeholder()
+	if err != nil {
+		return err
+	}
+
+	attachment.Width = img.Width()
+	attachment.Height = img.Height()
+	attachment.Placeholder = placeholder
+	attachment.Status = db.AttachmentReady
+
+	// Thumbnails of the previous attempt, if any, are replaced
+	return processor.queries.Attachments.SaveProcessed(ctx, attachment, thumbnails)
+}
+
+// Get the accounts that can see the attachment: the uploader, and the recipients of the
+// message it's attached to. Nil for attachments of the public chat, which every client can see
+func attachmentAudience(attachment *db.Attachment) []uint {
+	if attachment.Message == nil {
+		return []uint{attachment.UploaderID}
+	}
+
+	switch attachment.Message.ChatType {
+	case db.PrivateChat:
+		return []uint{attachment.UploaderID, *attachment.Message.ReceiverID}
+	default:
+		return nil
+	}
+}
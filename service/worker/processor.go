@@ -2,47 +2,127 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"sync/atomic"
 
 	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/mail"
 	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/webpush"
+	"github.com/danglnh07/zola/util"
 	"github.com/hibiken/asynq"
 )
 
+// Returned by Ping when the processor is not started or has been shut down
+var ErrProcessorNotRunning = errors.New("task processor is not running")
+
 // Task processor interface
 type TaskProcessor interface {
 	Start() error
+	Shutdown()
+	Ping() error // Check that the processor is running and can reach its queue
 	ProcessTaskSendMessage(ctx context.Context, task *asynq.Task) (err error)
+	ProcessTaskProcessImage(ctx context.Context, task *asynq.Task) (err error)
+	ProcessTaskSendEmailDigest(ctx context.Context, task *asynq.Task) (err error)
+	ProcessTaskSendPushNotification(ctx context.Context, task *asynq.Task) (err error)
+	ProcessTaskDeliverEvent(ctx context.Context, task *asynq.Task) (err error)
+	ProcessTaskExportData(ctx context.Context, task *asynq.Task) (err error)
+	ProcessTaskExpireDataExport(ctx context.Context, task *asynq.Task) (err error)
+	ProcessTaskDeleteAccount(ctx context.Context, task *asynq.Task) (err error)
+	ProcessTaskSendModerationNotice(ctx context.Context, task *asynq.Task) (err error)
+}
+
+// Dependencies and task handlers shared by every task processor implementation
+type baseTaskProcessor struct {
+	queries     *db.Queries
+	hub         *pubsub.Hub
+	distributor TaskDistributor
+	mailer      mail.Mailer
+	pusher      *webpush.Client
+	runtime     *util.RuntimeStore
+	config      *util.Config // Config at startup, runtime fields must be read from runtime
+	logger      *slog.Logger
+}
+
+// Method to create the mux routing every task type to its handler
+func (processor *baseTaskProcessor) newServeMux() *asynq.ServeMux {
+	mux := asynq.NewServeMux()
+	mux.Use(taskTracingMiddleware, taskMetricsMiddleware)
+
+	mux.HandleFunc(SendMessage, processor.ProcessTaskSendMessage)
+	mux.HandleFunc(ProcessImage, processor.ProcessTaskProcessImage)
+	mux.HandleFunc(SendEmailDigest, processor.ProcessTaskSendEmailDigest)
+	mux.HandleFunc(SendPushNotification, processor.ProcessTaskSendPushNotification)
+	mux.HandleFunc(DeliverEvent, processor.ProcessTaskDeliverEvent)
+	mux.HandleFunc(ExportData, processor.ProcessTaskExportData)
+	mux.HandleFunc(ExpireDataExport, processor.ProcessTaskExpireDataExport)
+	mux.HandleFunc(DeleteAccount, processor.ProcessTaskDeleteAccount)
+	mux.HandleFunc(SendModerationNotice, processor.ProcessTaskSendModerationNotice)
+
+	return mux
 }
 
 // Redis task processor
 type RedisTaskProcessor struct {
+	*baseTaskProcessor
 	server  *asynq.Server
-	queries *db.Queries
-	hub     *pubsub.Hub
-	logger  *slog.Logger
+	running atomic.Bool
 }
 
-// Constructor method for Redis task processor
+// Constructor method for Redis task processor, only the given queues are processed, each with
+// its priority
 func NewRedisTaskProcessor(
 	redisOpts asynq.RedisClientOpt,
+	queues map[string]int,
 	queries *db.Queries,
 	hub *pubsub.Hub,
+	distributor TaskDistributor,
+	mailer mail.Mailer,
+	pusher *webpush.Client,
+	runtime *util.RuntimeStore,
 	logger *slog.Logger,
 ) TaskProcessor {
 	return &RedisTaskProcessor{
-		server:  asynq.NewServer(redisOpts, asynq.Config{}),
-		queries: queries,
-		hub:     hub,
-		logger:  logger,
+		baseTaskProcessor: &baseTaskProcessor{
+			queries:     queries,
+			hub:         hub,
+			distributor: distributor,
+			mailer:      mailer,
+			pusher:      pusher,
+			runtime:     runtime,
+			config:      runtime.Get(),
+			logger:      logger,
+		},
+		server: asynq.NewServer(redisOpts, asynq.Config{
+			Concurrency:     runtime.Get().WorkerConcurrency,
+			ShutdownTimeout: runtime.Get().ShutdownTimeout,
+			Queues:          queues,
+			Logger:          asynqLogger{logger},
+		}),
 	}
 }
 
 // Method to start the worker server
 func (processor *RedisTaskProcessor) Start() error {
-	mux := asynq.NewServeMux()
+	if err := processor.server.Start(processor.newServeMux()); err != nil {
+		return err
+	}
+	processor.running.Store(true)
+	return nil
+}
 
-	mux.HandleFunc(SendMessage, processor.ProcessTaskSendMessage)
+// Method to stop pulling new tasks and wait for active tasks to complete, up to the shutdown timeout.
+// Tasks still running after the timeout are pushed back to the queue
+func (processor *RedisTaskProcessor) Shutdown() {
+	processor.running.Store(false)
+	processor.server.Shutdown()
+}
 
-	return processor.server.Start(mux)
+// Method to check that the worker server is running and connected to Redis
+func (processor *RedisTaskProcessor) Ping() error {
+	if !processor.running.Load() {
+		return ErrProcessorNotRunning
+	}
+	return processor.server.Ping()
 }
@@ -0,0 +1,30 @@
+package worker
+
+// Task queues. The realtime queue holds the tasks writing to the WebSocket clients or checking
+// their presence, it is processed by the serve command since the clients are connected to it.
+// The default queue holds the other tasks, it can be processed by separate worker processes
+const (
+	QueueRealtime = "realtime"
+	QueueDefault  = "default"
+)
+
+// Queue of every task type, the task types not listed go to the default queue
+var taskQueues = map[string]string{
+	SendMessage:          QueueRealtime,
+	SendEmailDigest:      QueueRealtime,
+	SendPushNotification: QueueRealtime,
+	DeliverEvent:         QueueRealtime,
+	ProcessImage:         QueueDefault,
+	ExportData:           QueueDefault,
+	ExpireDataExport:     QueueDefault,
+	DeleteAccount:        QueueDefault,
+	SendModerationNotice: QueueDefault,
+}
+
+// Get the queue a task type is sent to
+func queueOf(taskType string) string {
+	if queue, ok := taskQueues[taskType]; ok {
+		return queue
+	}
+	return QueueDefault
+}
@@ -6,12 +6,16 @@ import (
 	"fmt"
 
 	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/danglnh07/zola/service/tracing"
 	"github.com/hibiken/asynq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const SendMessage = "send-message"
 
-func (distributor *RedisTaskDistributor) DistributeTaskSendMessage(
+func (distributor *QueueTaskDistributor) DistributeTaskSendMessage(
 	ctx context.Context,
 	payload db.Message,
 	opts ...asynq.Option,
@@ -22,57 +26,98 @@ func (distributor *RedisTaskDistributor) DistributeTaskSendMessage(
 		return err
 	}
 
-	// Create new task
-	task := asynq.NewTask(SendMessage, data, opts...)
-
-	// Send task to Redis queue
-	info, err := distributor.client.EnqueueContext(ctx, task)
-	if err != nil {
-		return err
-	}
-
-	// Log task info
-	distributor.logger.Info("Task info", "task_name", SendMessage, "queue", info.Queue, "max_retry", info.MaxRetry)
-
-	return nil
+	return distributor.DistributeTask(ctx, SendMessage, data, opts...)
 }
 
-func (processor *RedisTaskProcessor) ProcessTaskSendMessage(ctx context.Context, task *asynq.Task) (err error) {
-	processor.logger.Info("Start processing task", "task name", SendMessage)
+func (processor *baseTaskProcessor) ProcessTaskSendMessage(ctx context.Context, task *asynq.Task) (err error) {
+	processor.logger.InfoContext(ctx, "Start processing task", "task name", SendMessage)
 
 	// Unmarshal payload
 	var message db.Message
 	if err := json.Unmarshal(task.Payload(), &message); err != nil {
-		return err
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
 	}
 
-	processor.logger.Info("", "Clients size", len(processor.hub.Clients))
-	processor.logger.Info("", "Processor hub", fmt.Sprintf("%p", processor.hub))
-
 	// Check if this is a broadcast message or a private message
 	var success int
 	switch message.ChatType {
 	case db.PublicChat:
-		// Send the message to all online client
-		for _, client := range processor.hub.Clients {
-			if err := client.WriteMessage(message); err != nil {
-				processor.logger.Error(fmt.Sprintf("Failed to send message %d to client %d", message.ID, client.AccountID), "error", err)
+		// Send the message to all online client, except the ones who blocked the sender
+		blockerIDs, err := processor.queries.Blocks.ListBlockerIDs(ctx, message.SenderID)
+		if err != nil {
+			return err
+		}
+		blockers := make(map[uint]bool, len(blockerIDs))
+		for _, id := range blockerIDs {
+			blockers[id] = true
+		}
+
+		// Clients which disconnect in the meantime are skipped by SendTo
+		recipients := processor.hub.OnlineAccountIDs()
+		_, span := tracing.Tracer().Start(ctx, "websocket broadcast", trace.WithAttributes(
+			attribute.Int("zola.message.id", int(message.ID)),
+			attribute.Int("zola.websocket.clients", len(recipients)),
+		))
+		for _, accountID := range recipients {
+			if blockers[accountID] {
+				continue
+			}
+			online, err := processor.hub.SendTo(accountID, message)
+			if err != nil {
+				processor.logger.ErrorContext(ctx, "Failed to send message to client", "message_id", message.ID, "account_id", accountID, "error", err)
+				metrics.MessagesDelivered.WithLabelValues(string(message.ChatType), "failed").Inc()
 				continue
 			}
-			processor.logger.Info(fmt.Sprintf("Message %d sent to client %d successfully", message.ID, client.AccountID), "error", err)
+			if !online {
+				continue
+			}
+			metrics.MessagesDelivered.WithLabelValues(string(message.ChatType), "sent").Inc()
+			processor.logger.DebugContext(ctx, "Message sent to client", "message_id", message.ID, "account_id", accountID)
 			success++
 		}
-		processor.logger.Info(fmt.Sprintf("%d / %d message sent success", success, len(processor.hub.Clients)))
+		processor.logger.InfoContext(ctx, "Message broadcast", "message_id", message.ID, "sent", success, "clients", len(recipients))
+		span.SetAttributes(attribute.Int("zola.websocket.sent", success))
+		span.End()
 	case db.PrivateChat:
-		if client, ok := processor.hub.Clients[*message.ReceiverID]; ok {
-			return client.WriteMessage(message)
+		_, span := tracing.Tracer().Start(ctx, "websocket write", trace.WithAttributes(
+			attribute.Int("zola.message.id", int(message.ID)),
+			attribute.Int("zola.account.id", int(*message.ReceiverID)),
+		))
+		online, err := processor.hub.SendTo(*message.ReceiverID, message)
+		span.SetAttributes(attribute.Bool("zola.websocket.online", online))
+		endSpan(span, err)
+		if err != nil {
+			metrics.MessagesDelivered.WithLabelValues(string(message.ChatType), "failed").Inc()
+			return err
+		}
+		if online {
+			metrics.MessagesDelivered.WithLabelValues(string(message.ChatType), "sent").Inc()
+			processor.logger.InfoContext(ctx, "Message sent to client", "message_id", message.ID, "account_id", *message.ReceiverID)
+			return nil
 		}
 
-		processor.logger.Info(fmt.Sprintf("Receiver %d currently offline, changed to send notification", *message.ReceiverID))
-		// Process with notification
+		metrics.MessagesDelivered.WithLabelValues(string(message.ChatType), "offline").Inc()
+		trace.SpanFromContext(ctx).AddEvent("receiver offline")
+		// Muted senders don't notify the receiver, the message is read once they come back
+		muted, err := processor.queries.Mutes.Exists(ctx, *message.ReceiverID, message.SenderID)
+		if err != nil {
+			return err
+		}
+		if muted {
+			processor.logger.InfoContext(ctx, "Receiver offline and sender muted, no notification sent", "message_id", message.ID, "account_id", *message.ReceiverID)
+			break
+		}
+
+		processor.logger.InfoContext(ctx, "Receiver offline, sending notifications instead", "message_id", message.ID, "account_id", *message.ReceiverID)
+		if err := processor.schedulePushNotifications(ctx, &message); err != nil {
+			return err
+		}
+		if err := processor.scheduleEmailDigest(ctx, *message.ReceiverID); err != nil {
+			return err
+		}
 	}
 
-	processor.logger.Info("Task completed successfully", "task name", SendMessage)
+	processor.logger.InfoContext(ctx, "Task completed successfully", "task name", SendMessage)
 
 	return nil
 }
@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/webpush"
+	"github.com/danglnh07/zola/util"
+	"github.com/hibiken/asynq"
+)
+
+const SendPushNotification = "send-push-notification"
+
+// How long the push service should keep the notification while the device is offline
+const pushTTL = 24 * time.Hour
+
+// Payload of the send push notification task
+type PushNotificationPayload struct {
+	SubscriptionID uint `json:"subscription_id"`
+	MessageID      uint `json:"message_id"`
+}
+
+// Notification sent to the service worker of the browser
+type PushNotification struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	MessageID uint   `json:"message_id"`
+	SenderID  uint   `json:"sender_id"`
+}
+
+func (distributor *QueueTaskDistributor) DistributeTaskSendPushNotification(
+	ctx context.Context,
+	payload PushNotificationPayload,
+	opts ...asynq.Option,
+) (err error) {
+	// Marshal payload
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return distributor.DistributeTask(ctx, SendPushNotification, data, opts...)
+}
+
+// Schedule a push notification of the message for every device the offline receiver subscribed
+func (processor *baseTaskProcessor) schedulePushNotifications(ctx context.Context, message *db.Message) error {
+	if !processor.runtime.Get().FeatureEnabled(util.FeatureWebPush) {
+		return nil
+	}
+
+	receiverID := *message.ReceiverID
+
+	pref, err := processor.queries.NotificationPreferences.Get(ctx, receiverID)
+	if err != nil {
+		return err
+	}
+
+	if !pref.PushEnabled {
+		return nil
+	}
+
+	subscriptions, err := processor.queries.PushSubscriptions.ListByAccount(ctx, receiverID)
+	if err != nil {
+		return err
+	}
+
+	processAt := pref.NextDeliveryTime(time.Now())
+	for _, subscription := range subscriptions {
+		err := processor.distributor.DistributeTaskSendPushNotification(
+			ctx,
+			PushNotificationPayload{SubscriptionID: subscription.ID, MessageID: message.ID},
+			asynq.ProcessAt(processAt),
+			asynq.TaskID(fmt.Sprintf("%s:%d:%d", SendPushNotification, subscription.ID, message.ID)),
+		)
+		if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (processor *baseTaskProcessor) ProcessTaskSendPushNotification(ctx context.Context, task *asynq.Task) (err error) {
+	processor.logger.InfoContext(ctx, "Start processing task", "task name", SendPushNotification)
+
+	// Unmarshal payload
+	var payload PushNotificationPayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	// The feature may have been turned off after the notification was scheduled
+	if !processor.runtime.Get().FeatureEnabled(util.FeatureWebPush) {
+		return nil
+	}
+
+	// The subscription may have been removed since the task was scheduled
+	subscription, err := processor.queries.PushSubscriptions.GetByID(ctx, payload.SubscriptionID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Skip if the receiver came back online or already read the message
+	if processor.hub.IsOnline(subscription.AccountID) {
+		return nil
+	}
+
+	message, err := processor.queries.Messages.GetByID(ctx, payload.MessageID)
+	if err != nil {
+		return err
+	}
+	if message.ReadAt != nil {
+		return nil
+	}
+
+	// The receiver may have muted the sender while the notification waited for the quiet hours
+	muted, err := processor.queries.Mutes.Exists(ctx, subscription.AccountID, message.SenderID)
+	if err != nil {
+		return err
+	}
+	if muted {
+		return nil
+	}
+
+	notification, err := json.Marshal(PushNotification{
+		Type:      "message",
+		Title:     message.Sender.DisplayName,
+		Body:      preview(message.Content),
+		MessageID: message.ID,
+		SenderID:  message.SenderID,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = processor.pusher.Send(ctx, webpush.Subscription{
+		Endpoint: subscription.Endpoint,
+		P256dh:   subscription.P256dh,
+		Auth:     subscription.Auth,
+	}, notification, pushTTL)
+	if errors.Is(err, webpush.ErrSubscriptionExpired) {
+		// The browser unsubscribed or the subscription expired, it will never be valid again
+		processor.logger.InfoContext(ctx, "Push subscription expired, removing it", "subscription_id", subscription.ID)
+		return processor.queries.PushSubscriptions.Delete(ctx, subscription.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	processor.logger.InfoContext(ctx, "Task completed successfully", "task name", SendPushNotification)
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/logging"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/webpush"
+	"github.com/danglnh07/zola/util"
+	"github.com/hibiken/asynq"
+)
+
+// Migrated in-memory SQLite database
+func newTestQueries(t *testing.T) *db.Queries {
+	t.Helper()
+
+	queries, err := db.NewQueries(&util.Config{DBConn: "sqlite::memory:"}, logging.NewLogger(io.Discard, "text", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { queries.Close() })
+
+	migrator, err := db.NewMigrator(queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return queries
+}
+
+func createTestAccount(t *testing.T, queries *db.Queries, handle string) *db.Account {
+	t.Helper()
+
+	account := &db.Account{
+		Username:        handle,
+		Email:           handle + "@example.com",
+		OauthProvider:   "google",
+		OauthProviderID: handle,
+		Handle:          handle,
+		DisplayName:     handle,
+	}
+	if err := queries.Accounts.Create(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func TestProcessTaskSendPushNotification(t *testing.T) {
+	for _, status := range []int{http.StatusCreated, http.StatusNotFound, http.StatusGone} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			ctx := context.Background()
+			queries := newTestQueries(t)
+			sender := createTestAccount(t, queries, "alice")
+			receiver := createTestAccount(t, queries, "bob")
+
+			var pushes int
+			service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				pushes++
+				w.WriteHeader(status)
+			}))
+			defer service.Close()
+
+			// Keys of the browser
+			uaKey, err := ecdh.P256().GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subscription := db.PushSubscription{
+				AccountID: receiver.ID,
+				Endpoint:  service.URL + "/push/bob",
+				P256dh:    base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes()),
+				Auth:      base64.RawURLEncoding.EncodeToString(make([]byte, 16)),
+			}
+			if err := queries.PushSubscriptions.Upsert(ctx, &subscription); err != nil {
+				t.Fatal(err)
+			}
+
+			message := db.Message{
+				SenderID:   sender.ID,
+				ReceiverID: &receiver.ID,
+				ChatType:   db.PrivateChat,
+				Content:    "hello",
+			}
+			if err := queries.Messages.Create(ctx, &message); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := webpush.GenerateVAPIDKeys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := &util.Config{Features: []string{util.FeatureWebPush}}
+			logger := logging.NewLogger(io.Discard, "text", nil)
+			processor := &baseTaskProcessor{
+				queries: queries,
+				hub:     pubsub.NewHub(),
+				pusher:  webpush.NewClient(keys, "mailto:admin@example.com"),
+				runtime: util.NewRuntimeStore(config, nil, logger),
+				config:  config,
+				logger:  logger,
+			}
+
+			payload, _ := json.Marshal(PushNotificationPayload{SubscriptionID: subscription.ID, MessageID: message.ID})
+			if err := processor.ProcessTaskSendPushNotification(ctx, asynq.NewTask(SendPushNotification, payload)); err != nil {
+				t.Fatal(err)
+			}
+			if pushes != 1 {
+				t.Fatalf("got %d pushes, want 1", pushes)
+			}
+
+			// The subscription is removed once the push service reports it's gone
+			_, err = queries.PushSubscriptions.GetByID(ctx, subscription.ID)
+			expired := status == http.StatusNotFound || status == http.StatusGone
+			if expired && !errors.Is(err, db.ErrNotFound) {
+				t.Fatalf("subscription not deleted after status %d: %v", status, err)
+			}
+			if !expired && err != nil {
+				t.Fatalf("subscription deleted after status %d: %v", status, err)
+			}
+		})
+	}
+}
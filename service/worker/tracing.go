@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/danglnh07/zola/service/logging"
+	"github.com/danglnh07/zola/service/tracing"
+	"github.com/hibiken/asynq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Task payload carrying the trace context and request ID of the code that created the task, so
+// the trace and the logs continue in the worker even after waiting in the outbox and the task queue
+type tracedPayload struct {
+	TraceContext propagation.MapCarrier `json:"trace_context"`
+	RequestID    string                 `json:"request_id,omitempty"`
+	Payload      json.RawMessage        `json:"payload"`
+}
+
+// Wrap the payload with the trace context and request ID of ctx
+func wrapPayload(ctx context.Context, payload []byte) ([]byte, error) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	return json.Marshal(tracedPayload{
+		TraceContext: carrier,
+		RequestID:    logging.RequestID(ctx),
+		Payload:      payload,
+	})
+}
+
+// Unwrap a payload created by wrapPayload, return the context carrying its trace context and
+// request ID. Payloads without trace context (tasks enqueued by an older version) are returned as is
+func unwrapPayload(ctx context.Context, payload []byte) (context.Context, []byte) {
+	var traced tracedPayload
+	if err := json.Unmarshal(payload, &traced); err != nil || traced.TraceContext == nil || traced.Payload == nil {
+		return ctx, payload
+	}
+
+	if traced.RequestID != "" {
+		ctx = logging.WithRequestID(ctx, traced.RequestID)
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, traced.TraceContext), traced.Payload
+}
+
+// Record the error on the span, if any, then end it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Task middleware unwrapping the payload, so handlers get the payload they were enqueued
+// with, and processing the task in a span continuing the trace of the payload
+func taskTracingMiddleware(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) (err error) {
+		ctx, payload := unwrapPayload(ctx, task.Payload())
+
+		ctx, span := tracing.Tracer().Start(ctx, "process "+task.Type(),
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("messaging.system", "asynq"),
+				attribute.String("messaging.operation", "process"),
+				attribute.String("zola.task.type", task.Type()),
+				attribute.Int("zola.task.retry_count", retryCount(ctx)),
+			),
+		)
+		defer func() { endSpan(span, err) }()
+
+		return next.ProcessTask(ctx, asynq.NewTask(task.Type(), payload))
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danglnh07/zola/db"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/worker"
+	"github.com/danglnh07/zola/util"
+)
+
+const userUsage = `Usage: zola user <command>
+
+Commands:
+  list [-search text] [-role user|moderator|admin] [-banned] [-limit n] [-offset n]
+                              List the accounts, 50 at a time by default
+  show <id>                   Show an account
+  ban <id> [reason]           Ban an account, its tokens are revoked and its clients disconnected
+  unban <id>                  Lift the ban of an account
+  revoke-tokens <id>          Revoke the tokens of an account and disconnect its clients
+  set-role <id> <user|moderator|admin>
+                              Change the role of an account
+
+Bans and unbans are recorded in the moderation audit trail, without a moderator.
+The clients are disconnected by the running server, once it relays the outbox event`
+
+// Time given to the commands talking to the database
+const commandTimeout = 30 * time.Second
+
+// Run the user subcommand
+func RunUser(args []string, config *util.Config, logger *slog.Logger) error {
+	if len(args) == 0 {
+		return usageError(userUsage)
+	}
+
+	queries, _, err := connectDatabase(config, logger)
+	if err != nil {
+		return err
+	}
+	defer queries.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	switch args[0] {
+	case "list":
+		return listUsers(ctx, queries, args[1:])
+	case "show":
+		if len(args) != 2 {
+			return usageError(userUsage)
+		}
+		return showUser(ctx, queries, args[1])
+	case "ban":
+		if len(args) < 2 {
+			return usageError(userUsage)
+		}
+		id, err := parseAccountID(args[1])
+		if err != nil {
+			return err
+		}
+		reason := strings.Join(args[2:], " ")
+
+		// Banning revokes the tokens, the open connections are closed by the server
+		err = updateAccount(ctx, queries, id, func(tx *db.Queries) error {
+			if err := tx.Accounts.Ban(ctx, id, reason); err != nil {
+				return err
+			}
+			return tx.ModerationActions.Create(ctx, &db.ModerationAction{AccountID: id, Action: db.ModerationBan, Reason: reason})
+		}, &worker.DeliverEventPayload{
+			AccountIDs:  []uint{id},
+			Event:       pubsub.Event{Type: pubsub.AccountBanned, Payload: pubsub.AccountBannedPayload{Reason: reason}},
+			CloseReason: "account banned",
+		})
+		if err != nil {
+			return err
+		}
+		logger.Info("Account banned", "id", id, "reason", reason)
+		return nil
+	case "unban":
+		if len(args) != 2 {
+			return usageError(userUsage)
+		}
+		id, err := parseAccountID(args[1])
+		if err != nil {
+			return err
+		}
+
+		err = updateAccount(ctx, queries, id, func(tx *db.Queries) error {
+			if err := tx.Accounts.Unban(ctx, id); err != nil {
+				return err
+			}
+			return tx.ModerationActions.Create(ctx, &db.ModerationAction{AccountID: id, Action: db.ModerationUnban})
+		}, nil)
+		if err != nil {
+			return err
+		}
+		logger.Info("Account unbanned", "id", id)
+		return nil
+	case "revoke-tokens":
+		if len(args) != 2 {
+			return usageError(userUsage)
+		}
+		id, err := parseAccountID(args[1])
+		if err != nil {
+			return err
+		}
+
+		err = updateAccount(ctx, queries, id, func(tx *db.Queries) error {
+			return tx.Accounts.RevokeTokens(ctx, id)
+		}, &worker.DeliverEventPayload{
+			AccountIDs:  []uint{id},
+			Event:       pubsub.Event{Type: pubsub.SessionRevoked},
+			CloseReason: "session revoked",
+		})
+		if err != nil {
+			return err
+		}
+		logger.Info("Account tokens revoked", "id", id)
+		return nil
+	case "set-role":
+		if len(args) != 3 {
+			return usageError(userUsage)
+		}
+		id, err := parseAccountID(args[1])
+		if err != nil {
+			return err
+		}
+		role := db.Role(args[2])
+		if role != db.RoleUser && role != db.RoleModerator && role != db.RoleAdmin {
+			return fmt.Errorf("invalid role %q, must be one of %s, %s or %s", role, db.RoleUser, db.RoleModerator, db.RoleAdmin)
+		}
+
+		err = updateAccount(ctx, queries, id, func(tx *db.Queries) error {
+			return tx.Accounts.SetRole(ctx, id, role)
+		}, nil)
+		if err != nil {
+			return err
+		}
+		logger.Info("Account role changed", "id", id, "role", role)
+		return nil
+	default:
+		return usageError(userUsage)
+	}
+}
+
+// Parse the account ID argument of a command
+func parseAccountID(arg string) (uint, error) {
+	id, err := strconv.ParseUint(arg, 10, 0)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid account ID %q", arg)
+	}
+	return uint(id), nil
+}
+
+// Update an account in a transaction. If event is set, it's delivered to the WebSocket clients
+// through the outbox, so it's only sent if the update is committed
+func updateAccount(
+	ctx context.Context,
+	queries *db.Queries,
+	id uint,
+	update func(tx *db.Queries) error,
+	event *worker.DeliverEventPayload,
+) error {
+	err := queries.Transaction(ctx, func(tx *db.Queries) error {
+		if err := update(tx); err != nil {
+			return err
+		}
+		if event == nil {
+			return nil
+		}
+
+		outboxEvent, err := worker.NewOutboxEvent(ctx, worker.DeliverEvent, event)
+		if err != nil {
+			return err
+		}
+		return tx.Outbox.Create(ctx, outboxEvent)
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("account %d not found", id)
+	}
+	return err
+}
+
+// Print the accounts matching the flags
+func listUsers(ctx context.Context, queries *db.Queries, args []string) error {
+	var filter db.AccountFilter
+	var role string
+	var banned bool
+	flagSet := flag.NewFlagSet("user list", flag.ContinueOnError)
+	flagSet.SetOutput(io.Discard)
+	flagSet.StringVar(&filter.Search, "search", "", "Part of the username, email or handle")
+	flagSet.StringVar(&role, "role", "", "Only list the accounts with this role")
+	flagSet.BoolVar(&banned, "banned", false, "Only list the banned accounts")
+	flagSet.IntVar(&filter.Limit, "limit", 50, "Maximum number of accounts")
+	flagSet.IntVar(&filter.Offset, "offset", 0, "Number of accounts to skip")
+	if err := flagSet.Parse(args); err != nil || flagSet.NArg() > 0 {
+		return usageError(userUsage)
+	}
+	filter.Role = db.Role(role)
+	if banned {
+		filter.Banned = &banned
+	}
+
+	accounts, err := queries.Accounts.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tUSERNAME\tEMAIL\tROLE\tSTATUS\tCREATED AT")
+	for _, account := range accounts {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			account.ID, account.Username, account.Email, account.Role, accountStatus(&account),
+			account.CreatedAt.Format(time.RFC3339))
+	}
+	return writer.Flush()
+}
+
+// Print the details of an account
+func showUser(ctx context.Context, queries *db.Queries, arg string) error {
+	id, err := parseAccountID(arg)
+	if err != nil {
+		return err
+	}
+
+	account, err := queries.Accounts.GetByID(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("account %d not found", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	devices, err := queries.PushSubscriptions.ListByAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "ID\t%d\n", account.ID)
+	fmt.Fprintf(writer, "Username\t%s\n", account.Username)
+	fmt.Fprintf(writer, "Handle\t@%s\n", account.Handle)
+	fmt.Fprintf(writer, "Email\t%s\n", account.Email)
+	fmt.Fprintf(writer, "OAuth provider\t%s (%s)\n", account.OauthProvider, account.OauthProviderID)
+	fmt.Fprintf(writer, "Role\t%s\n", account.Role)
+	fmt.Fprintf(writer, "Status\t%s\n", accountStatus(account))
+	if account.BanReason != "" {
+		fmt.Fprintf(writer, "Ban reason\t%s\n", account.BanReason)
+	}
+	fmt.Fprintf(writer, "DM privacy\t%s\n", account.DMPrivacy)
+	fmt.Fprintf(writer, "Token version\t%d\n", account.TokenVersion)
+	fmt.Fprintf(writer, "Push devices\t%d\n", len(devices))
+	fmt.Fprintf(writer, "Created at\t%s\n", account.CreatedAt.Format(time.RFC3339))
+	return writer.Flush()
+}
+
+// Describe whether the account is banned, muted or about to be deleted
+func accountStatus(account *db.Account) string {
+	if account.BannedAt != nil {
+		return "banned since " + account.BannedAt.Format(time.RFC3339)
+	}
+	if account.MutedUntil != nil && account.MutedUntil.After(time.Now()) {
+		return "muted until " + account.MutedUntil.Format(time.RFC3339)
+	}
+	if account.DeletionScheduledAt != nil {
+		return "deletion scheduled for " + account.DeletionScheduledAt.Format(time.RFC3339)
+	}
+	return "active"
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// How often the config files are checked for changes
+const configPollInterval = 2 * time.Second
+
+// Runtime config store, holds the current config and reloads it on SIGHUP or when one of
+// its files changes. Only the fields tagged with runtime take effect on reload, changes to
+// other fields are reported and ignored until restart
+type RuntimeStore struct {
+	current atomic.Pointer[Config]
+	load    func() (*Config, error)
+	logger  *slog.Logger
+
+	mutex       sync.Mutex // Serialize reloads and subscriptions
+	subscribers []func(config *Config)
+}
+
+// Constructor method for RuntimeStore. load is called on every reload, it should load and
+// validate the config from the same sources as the initial one
+func NewRuntimeStore(config *Config, load func() (*Config, error), logger *slog.Logger) *RuntimeStore {
+	store := &RuntimeStore{
+		load:   load,
+		logger: logger,
+	}
+	store.current.Store(config)
+	return store
+}
+
+// Method to get the current config. It must not be modified, and should be fetched again
+// instead of being kept, so the new values are used after a reload
+func (store *RuntimeStore) Get() *Config {
+	return store.current.Load()
+}
+
+// Method to register a function called with the new config after every reload. It is also
+// called immediately with the current config
+func (store *RuntimeStore) Subscribe(fn func(config *Config)) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.subscribers = append(store.subscribers, fn)
+	fn(store.current.Load())
+}
+
+// Method to load the config again and apply its runtime fields. If the new config is
+// invalid, it is rejected and the current config is kept
+func (store *RuntimeStore) Reload() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	loaded, err := store.load()
+	if err != nil {
+		store.logger.Error("Invalid config, reload rejected", "error", err)
+		return err
+	}
+
+	// Start from the current config, so only the runtime fields change
+	current := store.current.Load()
+	next := *current
+	currentValue, loadedValue, nextValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(loaded).Elem(), reflect.ValueOf(&next).Elem()
+	var changed, ignored []string
+	for _, field := range configFields() {
+		if reflect.DeepEqual(currentValue.Field(field.index).Interface(), loadedValue.Field(field.index).Interface()) {
+			continue
+		}
+		if !field.runtime {
+			ignored = append(ignored, field.key)
+			continue
+		}
+		nextValue.Field(field.index).Set(loadedValue.Field(field.index))
+		changed = append(changed, field.key)
+	}
+
+	if len(ignored) > 0 {
+		store.logger.Warn("Config changes require a restart to take effect", "keys", ignored)
+	}
+	if len(changed) == 0 {
+		store.logger.Info("Config reloaded, nothing changed")
+		return nil
+	}
+
+	store.current.Store(&next)
+	for _, subscriber := range store.subscribers {
+		subscriber(&next)
+	}
+	store.logger.Info("Config reloaded", "changed", changed)
+
+	return nil
+}
+
+// Method to reload the config on SIGHUP or when one of the config files changes, until the
+// context is cancelled
+func (store *RuntimeStore) Watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	modTimes := fileModTimes(store.Get().Files())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			store.logger.Info("SIGHUP received, reloading config")
+			store.Reload()
+		case <-ticker.C:
+			latest := fileModTimes(store.Get().Files())
+			if !reflect.DeepEqual(modTimes, latest) {
+				modTimes = latest
+				store.logger.Info("Config file changed, reloading config")
+				store.Reload()
+			}
+		}
+	}
+}
+
+// Get the modification time of every file, missing files are skipped
+func fileModTimes(paths []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	return modTimes
+}
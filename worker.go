@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/danglnh07/zola/api"
+	"github.com/danglnh07/zola/service/metrics"
+	"github.com/danglnh07/zola/service/pubsub"
+	"github.com/danglnh07/zola/service/tracing"
+	"github.com/danglnh07/zola/service/worker"
+	"github.com/danglnh07/zola/util"
+)
+
+// Run the worker command: process the default task queue, apart from the API server. The tasks
+// of the realtime queue need the WebSocket clients, so they're left to the serve command, which
+// must run as a single replica. It blocks until a shutdown signal is received
+func RunWorker(config *util.Config, logger *slog.Logger, logLevel *slog.LevelVar) error {
+	// The in-memory queue lives in the serve process, other processes can't reach it
+	if config.TaskBackend != util.TaskBackendRedis {
+		return errors.New("the worker command requires the redis task backend")
+	}
+	logger.LogAttrs(context.Background(), slog.LevelInfo, "Effective config", config.Redacted()...)
+
+	runtimeStore := newRuntimeStore(config, logger, logLevel)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), config)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
+	queries, _, err := connectDatabase(config, logger)
+	if err != nil {
+		return err
+	}
+
+	pusher, err := newPusher(config)
+	if err != nil {
+		return err
+	}
+
+	// No client is connected to a worker, the hub stays empty
+	backend, err := newTaskBackend(
+		config, map[string]int{worker.QueueDefault: 1}, queries, pubsub.NewHub(), pusher, runtimeStore, logger,
+	)
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := queries.DB.DB()
+	if err != nil {
+		return err
+	}
+	metrics.RegisterDBStats(sqlDB, queries.Dialect)
+	metrics.RegisterQueueStats(backend.queueStats, logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go runtimeStore.Watch(ctx)
+
+	if err = backend.processor.Start(); err != nil {
+		return fmt.Errorf("failed to start the task processor: %w", err)
+	}
+	logger.Info("Worker started", "queue", worker.QueueDefault, "concurrency", config.WorkerConcurrency)
+
+	// Expose the task metrics, the admin address must differ from the one of the serve
+	// command if both run on the same host
+	adminErr := make(chan error, 1)
+	var adminServer *api.AdminServer
+	if config.AdminListenAddr != "" {
+		adminServer = api.NewAdminServer(config, logger)
+		go func() {
+			adminErr <- adminServer.Start()
+		}()
+	}
+
+	var errs []error
+	select {
+	case <-ctx.Done():
+		logger.Info("Shutdown signal received, shutting down")
+	case err = <-adminErr:
+		errs = append(errs, fmt.Errorf("admin server shut down unexpectedly: %w", err))
+	}
+	stop()
+
+	// Let the active tasks complete, then release the connections
+	backend.processor.Shutdown()
+	backend.Close()
+
+	if adminServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		if err = adminServer.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down the admin server gracefully: %w", err))
+		}
+		cancel()
+	}
+
+	if err = queries.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close the database: %w", err))
+	}
+
+	tracingCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	if err = shutdownTracing(tracingCtx); err != nil {
+		logger.Error("Failed to flush the traces", "error", err)
+	}
+	cancel()
+
+	logger.Info("Shutdown complete")
+	return errors.Join(errs...)
+}